@@ -0,0 +1,61 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * vyatta-dhcp-route rewrites the arguments of an ip route command issued
+ * by a DHCP client according to the dhcp-client policy of the interface.
+ *
+ * Usage: vyatta-dhcp-route [-config file] -- <ip arguments>
+ *
+ * The arguments to run ip with are written one per line. Nothing is
+ * written if the route is not to be installed.
+ */
+package main
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/static"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	config := flag.String("config", "/etc/vyatta-routing/rib.json",
+		"Translated static routing configuration")
+	flag.Parse()
+
+	args := flag.Args()
+	ifname := static.IpRouteDevice(args)
+	if ifname == "" {
+		for _, arg := range args {
+			fmt.Println(arg)
+		}
+		return
+	}
+
+	cfg := make(map[string]interface{})
+	cfg_json, err := ioutil.ReadFile(*config)
+	if err == nil {
+		err = json.Unmarshal(cfg_json, &cfg)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", *config, err)
+		os.Exit(1)
+	}
+
+	policy, err := static.GetDhcpRoutePolicy(cfg, ifname)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, arg := range policy.RewriteIpArgs(args) {
+		fmt.Println(arg)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * vyatta-pbr-tables is a client of the PBR table registry, for use by
+ * scripts and components which are not written in Go.
+ *
+ * Usage:
+ *     vyatta-pbr-tables acquire <user> <vrf> <table>
+ *     vyatta-pbr-tables release <user> <vrf> <table>
+ *     vyatta-pbr-tables in-use <vrf> <table>
+ *     vyatta-pbr-tables list
+ *     vyatta-pbr-tables collect
+ *
+ * in-use exits with status 0 if the table is held by any user and 1 if not.
+ */
+package main
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: vyatta-pbr-tables "+
+		"acquire|release <user> <vrf> <table> | in-use <vrf> <table> | "+
+		"list | collect")
+	os.Exit(2)
+}
+
+func parseTable(table string) uint32 {
+	n, err := strconv.ParseUint(table, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid table %s\n", table)
+		os.Exit(2)
+	}
+	return uint32(n)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(3)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	registry := static.NewTableRegistry()
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "acquire", "release":
+		if len(args) != 3 {
+			usage()
+		}
+		var err error
+		if os.Args[1] == "acquire" {
+			err = registry.Acquire(args[0], args[1], parseTable(args[2]))
+		} else {
+			err = registry.Release(args[0], args[1], parseTable(args[2]))
+		}
+		if err != nil {
+			fail(err)
+		}
+	case "in-use":
+		if len(args) != 2 {
+			usage()
+		}
+		in_use, err := registry.InUse(args[0], parseTable(args[1]))
+		if err != nil {
+			fail(err)
+		}
+		if !in_use {
+			os.Exit(1)
+		}
+	case "list":
+		tables, err := registry.List()
+		if err != nil {
+			fail(err)
+		}
+		for _, tbl := range tables {
+			fmt.Printf("%s %d %d %s\n", tbl.RoutingInstance, tbl.Table,
+				tbl.KernelTable, strings.Join(tbl.Users, ","))
+		}
+	case "collect":
+		released, err := registry.Collect()
+		for _, key := range released {
+			fmt.Printf("Released %s\n", key)
+		}
+		if err != nil {
+			fail(err)
+		}
+	default:
+		usage()
+	}
+}
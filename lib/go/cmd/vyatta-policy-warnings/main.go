@@ -0,0 +1,45 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * vyatta-policy-warnings prints the warnings of the policy package for
+ * a configuration, for the configd validate script of the route policy
+ * model to show them.
+ *
+ * The internal JSON of the configuration, containing the policy route,
+ * protocols and routing trees, is read from standard input.
+ *
+ * Usage: vyatta-policy-warnings < <config>
+ */
+package main
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/policy"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: vyatta-policy-warnings < <config>")
+		os.Exit(2)
+	}
+
+	cfg := make(map[string]interface{})
+	cfg_json, err := ioutil.ReadAll(os.Stdin)
+	if err == nil {
+		err = json.Unmarshal(cfg_json, &cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		os.Exit(3)
+	}
+
+	for _, warning := range policy.Warnings(cfg) {
+		fmt.Println("Warning: " + warning)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * vyatta-static-arp is run as a service whenever the static ARP or IPv6
+ * neighbour configuration changes. It reconciles the kernel neighbour
+ * table with the configuration and, if any entries are configured, keeps
+ * them installed across link flaps and interface re-creation.
+ *
+ * Usage: vyatta-static-arp [-debug] <config file>
+ */
+package main
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/static"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	debug := flag.Bool("debug", false, "Turn on debugging")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: vyatta-static-arp [-debug] <config file>")
+		os.Exit(2)
+	}
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	config := flag.Arg(0)
+	cfg := make(map[string]interface{})
+	cfg_json, err := ioutil.ReadFile(config)
+	if err == nil {
+		err = json.Unmarshal(cfg_json, &cfg)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		log.Fatalf("Failed to load %s: %s", config, err)
+	}
+
+	entries, err := static.ArpConfig(cfg)
+	if err != nil {
+		log.Errorln(err)
+	}
+
+	arp := static.NewStaticArp()
+	err = arp.Set(entries)
+	if err != nil {
+		log.Errorln(err)
+	}
+
+	if !arp.HasEntries() {
+		log.Infoln("No static arp configuration - exiting.")
+		return
+	}
+
+	log.Infoln("Listening for link and neighbour events")
+	err = arp.Run()
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * vyatta-static-check validates the static configuration of a
+ * routing-instance against the rest of a configuration, for the configd
+ * validate script of the static models. It checks that the source
+ * address of each route is configured on an interface of the
+ * routing-instance, or is presently on one which learns its address by
+ * DHCP, and that the interface of each static ARP and IPv6 neighbour
+ * belongs to the routing-instance.
+ *
+ * The internal JSON of the configuration, containing the interfaces,
+ * protocols and routing trees, is read from standard input. Problems are
+ * printed and the exit status is 1 if there are any.
+ *
+ * Usage: vyatta-static-check [-routing-instance <name>] < <config>
+ */
+package main
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"eng.vyatta.net/protocols/static"
+	"flag"
+	"fmt"
+	multierr "github.com/hashicorp/go-multierror"
+	"io/ioutil"
+	"os"
+)
+
+/*
+ * Returns the configuration cfg restricted to the static configuration
+ * of routing-instance ri, along with the interface configuration
+ */
+func routingInstanceConfig(cfg map[string]interface{}, ri string) map[string]interface{} {
+	ri_cfg := map[string]interface{}{"interfaces": cfg["interfaces"]}
+	if ri == "default" {
+		ri_cfg["protocols"] = cfg["protocols"]
+		return ri_cfg
+	}
+
+	routing_map, _ := cfg["routing"].(map[string]interface{})
+	ri_arr, _ := routing_map["routing-instance"].([]interface{})
+	for _, ri_entry := range ri_arr {
+		ri_map, _ := ri_entry.(map[string]interface{})
+		if ri_map["instance-name"] == ri {
+			ri_cfg["routing"] = map[string]interface{}{
+				"routing-instance": []interface{}{ri_map},
+			}
+		}
+	}
+	return ri_cfg
+}
+
+func main() {
+	ri := flag.String("routing-instance", "default",
+		"Routing-instance to check")
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr,
+			"Usage: vyatta-static-check [-routing-instance <name>] < <config>")
+		os.Exit(2)
+	}
+
+	cfg := make(map[string]interface{})
+	cfg_json, err := ioutil.ReadAll(os.Stdin)
+	if err == nil {
+		err = json.Unmarshal(cfg_json, &cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		os.Exit(3)
+	}
+
+	local, dynamic := static.ConfiguredLocalAddresses(cfg)
+	err = static.AddInterfaceAddresses(local, dynamic)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get DHCP addresses: %s\n", err)
+	}
+
+	ri_cfg := routingInstanceConfig(cfg, *ri)
+	ret_err := protocols.NewMultiError()
+	ret_err = multierr.Append(ret_err,
+		static.ValidateSources(ri_cfg, local),
+		static.ValidateNeighbourInterfaces(ri_cfg,
+			static.ConfiguredInterfaceRoutingInstances(cfg)))
+	if err = ret_err.ErrorOrNil(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
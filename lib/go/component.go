@@ -482,6 +482,29 @@ func (pmc *ProtocolsModelComponent) GetSystemConfig() ([]byte, error) {
 	return cfg, nil
 }
 
+/*
+ * Returns the running configuration of the VCI model modelName, which
+ * may belong to another component, converted to our internal format
+ */
+func (pmc *ProtocolsModelComponent) GetModelConfig(modelName string) ([]byte, error) {
+	var obj interface{}
+
+	err := pmc.client.StoreConfigByModelInto(modelName, &obj)
+	if err != nil {
+		return EmptyConfig(), err
+	}
+	if obj == nil {
+		return EmptyConfig(), nil
+	}
+
+	cfg, err := rfc7951.Marshal(obj)
+	if err != nil {
+		return EmptyConfig(), err
+	}
+
+	return ConvertConfigToInternalJson(cfg)
+}
+
 /*
  * Create a subscription, run it, and add it to the Subscriptions map
  */
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, AT&T Intellectual Property.
+// Copyright (c) 2018-2019, 2021, AT&T Intellectual Property.
 // All rights reserved.
 //
 // SPDX-License-Identifier: MPL-2.0
@@ -8,6 +8,7 @@ package protocols
 import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/danos/vci/services"
+	"os/exec"
 	"sync"
 	"time"
 )
@@ -118,6 +119,14 @@ func (pd *ProtocolsDaemon) Disable() error {
 	return err
 }
 
+/*
+ * Returns whether the daemon's unit is currently active
+ */
+func (pd *ProtocolsDaemon) IsActive() bool {
+	return exec.Command("/bin/systemctl", "is-active", "--quiet",
+		pd.GetUnitName()).Run() == nil
+}
+
 func (pd *ProtocolsDaemon) stopAndDisableCallback() {
 	pd.LockControl()
 	defer pd.UnlockControl()
@@ -0,0 +1,139 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* Largest 2-byte AS number */
+const MAX_AS2 = 65535
+
+/*
+ * Parses an AS number in asplain (e.g. 65546) or asdot (e.g. 1.10)
+ * notation, as described in RFC 5396. AS 0 is reserved and rejected.
+ */
+func ParseAsn(value string) (uint32, error) {
+	var asn uint64
+
+	if fields := strings.Split(value, "."); len(fields) == 2 {
+		high, err_high := strconv.ParseUint(fields[0], 10, 16)
+		low, err_low := strconv.ParseUint(fields[1], 10, 16)
+		if err_high != nil || err_low != nil {
+			return 0, fmt.Errorf("%s is not an AS number in asdot notation; "+
+				"each part must be between 0 and 65535", value)
+		}
+		asn = high<<16 | low
+	} else {
+		var err error
+		asn, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not an AS number between 1 and "+
+				"4294967295 or in asdot notation", value)
+		}
+	}
+
+	if asn == 0 {
+		return 0, fmt.Errorf("AS 0 is reserved")
+	}
+
+	return uint32(asn), nil
+}
+
+/*
+ * Returns the AS number value converted to asplain notation, if it is in
+ * asdot notation, or otherwise value unchanged
+ */
+func asplain(value string) string {
+	if strings.Count(value, ".") != 1 {
+		return value
+	}
+	asn, err := ParseAsn(value)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatUint(uint64(asn), 10)
+}
+
+/*
+ * Returns the space separated list of communities or extended
+ * communities value, with the AS of each in asplain notation
+ */
+func asplainCommunities(value string) string {
+	communities := strings.Fields(value)
+
+	for i, community := range communities {
+		j := strings.LastIndex(community, ":")
+		if j < 0 {
+			continue
+		}
+		communities[i] = asplain(community[:j]) + community[j:]
+	}
+
+	return strings.Join(communities, " ")
+}
+
+/*
+ * Converts the leaves keys of pmap, each a list of communities or
+ * extended communities, to asplain notation
+ */
+func asplainLeaves(pmap map[string]interface{}, keys ...string) {
+	for _, key := range keys {
+		if value, ok := pmap[key].(string); ok {
+			pmap[key] = asplainCommunities(value)
+		}
+	}
+}
+
+/*
+ * Converts each AS in asdot notation of the route policy configuration
+ * in frontend_map to asplain, as the routing daemon only accepts AS
+ * numbers in asplain notation. frontend_map is modified in place.
+ */
+func TranslateAsdot(frontend_map map[string]interface{}) {
+	route_map := policyRoute(frontend_map)
+
+	for _, list := range [...]string{"community-list", "extcommunity-list"} {
+		list_map, _ := route_map[list].(map[string]interface{})
+		walkList(list_map, "standard",
+			func(_ string, std_map map[string]interface{}) {
+				walkList(std_map, "rule",
+					func(_ string, rule_map map[string]interface{}) {
+						asplainLeaves(rule_map, "community", "rt", "soo")
+					})
+			})
+	}
+
+	walkList(route_map, "route-map",
+		func(_ string, rmap_map map[string]interface{}) {
+			walkList(rmap_map, "rule",
+				func(_ string, rule_map map[string]interface{}) {
+					set_map, ok := rule_map["set"].(map[string]interface{})
+					if !ok {
+						return
+					}
+					asplainLeaves(set_map, "community", "add-community")
+					for _, key := range [...]string{"extcommunity",
+						"add-extcommunity"} {
+						if ext_map, ok := set_map[key].(map[string]interface{}); ok {
+							asplainLeaves(ext_map, "rt", "soo")
+						}
+					}
+
+					prepend, ok := set_map["as-path-prepend"].(string)
+					if !ok {
+						return
+					}
+					ases := strings.Fields(prepend)
+					for i, as := range ases {
+						ases[i] = asplain(as)
+					}
+					set_map["as-path-prepend"] = strings.Join(ases, " ")
+				})
+		})
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* Maximum number of ASes which may be prepended to an AS path */
+const MAX_AS_PATH_PREPEND = 24
+
+/*
+ * Validates a space separated list of ASes to prepend to an AS path,
+ * each in asplain or asdot notation
+ */
+func ValidateAsPathPrepend(value string) error {
+	ases := strings.Fields(value)
+	if len(ases) == 0 {
+		return fmt.Errorf("invalid AS path string")
+	}
+
+	for _, as := range ases {
+		if _, err := ParseAsn(as); err != nil {
+			return fmt.Errorf("invalid AS path string: %s", err)
+		}
+	}
+
+	if len(ases) > MAX_AS_PATH_PREPEND {
+		return fmt.Errorf("max %d as path", MAX_AS_PATH_PREPEND)
+	}
+
+	return nil
+}
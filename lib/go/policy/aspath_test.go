@@ -0,0 +1,124 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"eng.vyatta.net/protocols/policy"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateAsPathPrepend(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{value: "100"},
+		{value: "1 65536 4294967295"},
+		{value: "1.10 0.100 65535.65535"},
+		{value: "1.10 65000"},
+		{value: strings.Repeat("100 ", 24)},
+		{value: strings.Repeat("100 ", 25), err: "max 24 as path"},
+		{value: "", err: "invalid AS path string"},
+		{value: "0", err: "AS 0 is reserved"},
+		{value: "0.0", err: "AS 0 is reserved"},
+		{value: "4294967296", err: "4294967296 is not an AS number"},
+		{value: "100 abc", err: "abc is not an AS number"},
+		{value: "-1", err: "-1 is not an AS number"},
+		{value: "1.65536", err: "1.65536 is not an AS number in asdot notation"},
+	}
+
+	for _, test := range tests {
+		err := policy.ValidateAsPathPrepend(test.value)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%q: expected error %q", test.value, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%q: expected error %q, got %q", test.value, test.err, err)
+		}
+	}
+}
+
+func TestTranslateAsdot(t *testing.T) {
+	cfg := planConfig(t, `{
+		"community-list" : {
+			"standard" : [ {
+				"tagnode" : 1,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"community" : "1.10:100 no-export 100:1" } ]
+			} ],
+			"expanded" : [ {
+				"tagnode" : 100,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"regex" : "1.10:.*" } ]
+			} ]
+		},
+		"extcommunity-list" : {
+			"standard" : [ {
+				"tagnode" : 1,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"rt" : "1.10:100 10.0.0.1:1", "soo" : "0.100:1" } ]
+			} ]
+		},
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [ {
+				"tagnode" : 10,
+				"action" : "permit",
+				"set" : {
+					"community" : "0.100:1",
+					"add-community" : "1:1",
+					"extcommunity" : { "rt" : "1.10:100", "soo" : "10.0.0.1:1" },
+					"add-extcommunity" : { "rt" : "65535.65535:1" },
+					"as-path-prepend" : "1.10 65000 0.100"
+				}
+			} ]
+		} ]
+	}`)
+
+	policy.TranslateAsdot(cfg)
+
+	expected := planConfig(t, `{
+		"community-list" : {
+			"standard" : [ {
+				"tagnode" : 1,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"community" : "65546:100 no-export 100:1" } ]
+			} ],
+			"expanded" : [ {
+				"tagnode" : 100,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"regex" : "1.10:.*" } ]
+			} ]
+		},
+		"extcommunity-list" : {
+			"standard" : [ {
+				"tagnode" : 1,
+				"rule" : [ { "tagnode" : 10, "action" : "permit",
+					"rt" : "65546:100 10.0.0.1:1", "soo" : "100:1" } ]
+			} ]
+		},
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [ {
+				"tagnode" : 10,
+				"action" : "permit",
+				"set" : {
+					"community" : "100:1",
+					"add-community" : "1:1",
+					"extcommunity" : { "rt" : "65546:100", "soo" : "10.0.0.1:1" },
+					"add-extcommunity" : { "rt" : "4294967295:1" },
+					"as-path-prepend" : "65546 65000 100"
+				}
+			} ]
+		} ]
+	}`)
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unexpected translation\nexpected: %v\ngot: %v", expected, cfg)
+	}
+}
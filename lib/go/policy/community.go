@@ -0,0 +1,200 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/* Well-known communities which may be configured by name */
+var wellKnownCommunities = map[string]bool{
+	"internet":     true,
+	"local-as":     true,
+	"no-advertise": true,
+	"no-export":    true,
+}
+
+/*
+ * Parses a community in AA:NN format. The AS, in asplain or asdot
+ * notation, must be a 2-byte AS below 65535 as a standard community
+ * has no room for a 4-byte AS.
+ */
+func parseCommunity(value string) (uint32, uint32, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("%s unknown community value", value)
+	}
+
+	asn, err := ParseAsn(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %s", value, err)
+	}
+	if asn > MAX_AS2 {
+		return 0, 0, fmt.Errorf("%s: AS %d is a 4-byte AS, which does not "+
+			"fit in a standard community; use an AS4:NN extended community",
+			value, asn)
+	}
+	if asn == MAX_AS2 {
+		return 0, 0, fmt.Errorf("%s: AS 65535 is reserved for well-known "+
+			"communities", value)
+	}
+
+	nn, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: community value %s must be between "+
+			"0 and 65535", value, fields[1])
+	}
+
+	return asn, uint32(nn), nil
+}
+
+/*
+ * Validates a space separated list of communities, each either in AA:NN
+ * format or a well-known community. If allow_none is set, the list may
+ * instead be "none" alone.
+ */
+func ValidateCommunities(value string, allow_none bool) error {
+	communities := strings.Fields(value)
+	if len(communities) == 0 {
+		return fmt.Errorf("No community value")
+	}
+
+	for _, community := range communities {
+		if community == "none" && allow_none {
+			if len(communities) > 1 {
+				return fmt.Errorf("cannot configure 'none' with other attributes")
+			}
+			continue
+		}
+		if wellKnownCommunities[community] {
+			continue
+		}
+		if _, _, err := parseCommunity(community); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Validates an extended community in one of the forms:
+ *
+ *     AS2:NN  2-byte AS and 4-byte value
+ *     AS4:NN  4-byte AS, in asplain or asdot notation, and 2-byte value
+ *     IP:NN   IPv4 address and 2-byte value
+ */
+func ValidateExtCommunity(value string) error {
+	i := strings.LastIndex(value, ":")
+	if i < 0 {
+		return fmt.Errorf("%s is not an extended community in AA:NN or "+
+			"IPAddr:NN format", value)
+	}
+	admin, assigned := value[:i], value[i+1:]
+
+	nn, err := strconv.ParseUint(assigned, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s: value %s must be a number", value, assigned)
+	}
+
+	if strings.Count(admin, ".") == 3 {
+		if ip := net.ParseIP(admin); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("%s: %s is not an IPv4 address", value, admin)
+		}
+		if nn > MAX_AS2 {
+			return fmt.Errorf("%s: value must be between 0 and 65535 "+
+				"with an IPv4 address", value)
+		}
+		return nil
+	}
+
+	asn, err := ParseAsn(admin)
+	if err != nil {
+		return fmt.Errorf("%s: %s", value, err)
+	}
+	if (asn > MAX_AS2 || strings.Contains(admin, ".")) && nn > MAX_AS2 {
+		return fmt.Errorf("%s: value must be between 0 and 65535 "+
+			"with a 4-byte AS", value)
+	}
+
+	return nil
+}
+
+/*
+ * Validates a space separated list of extended communities
+ */
+func ValidateExtCommunities(value string) error {
+	communities := strings.Fields(value)
+	if len(communities) == 0 {
+		return fmt.Errorf("No extended community value")
+	}
+
+	for _, community := range communities {
+		if err := ValidateExtCommunity(community); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Validates a large community in ASN:X:Y format, as described in
+ * RFC 8092. The global administrator ASN must be in asplain notation,
+ * and both local data parts are 4-byte values.
+ */
+func ValidateLargeCommunity(value string) error {
+	fields := strings.Split(value, ":")
+	if len(fields) != 3 {
+		return fmt.Errorf("%s is not a large community in ASN:X:Y format",
+			value)
+	}
+
+	if strings.Contains(fields[0], ".") {
+		return fmt.Errorf("%s: AS %s must be in asplain notation", value,
+			fields[0])
+	}
+	if _, err := ParseAsn(fields[0]); err != nil {
+		return fmt.Errorf("%s: %s", value, err)
+	}
+
+	for _, data := range fields[1:] {
+		if _, err := strconv.ParseUint(data, 10, 32); err != nil {
+			return fmt.Errorf("%s: local data %s must be between 0 and "+
+				"4294967295", value, data)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Validates a space separated list of large communities. If allow_none
+ * is set, the list may instead be "none" alone.
+ */
+func ValidateLargeCommunities(value string, allow_none bool) error {
+	communities := strings.Fields(value)
+	if len(communities) == 0 {
+		return fmt.Errorf("No large community value")
+	}
+
+	for _, community := range communities {
+		if community == "none" && allow_none {
+			if len(communities) > 1 {
+				return fmt.Errorf("cannot configure 'none' with other attributes")
+			}
+			continue
+		}
+		if err := ValidateLargeCommunity(community); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
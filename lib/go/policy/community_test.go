@@ -0,0 +1,129 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"eng.vyatta.net/protocols/policy"
+	"testing"
+)
+
+func TestValidateCommunities(t *testing.T) {
+	tests := []struct {
+		value      string
+		allow_none bool
+		err        string
+	}{
+		{value: "100:200"},
+		{value: "1:0 65534:65535 no-export"},
+		{value: "internet local-as no-advertise"},
+		{value: "0.100:200"},
+		{value: "0:1", err: "0:1: AS 0 is reserved"},
+		{value: "65535:1",
+			err: "65535:1: AS 65535 is reserved for well-known communities"},
+		{value: "1:65536",
+			err: "1:65536: community value 65536 must be between 0 and 65535"},
+		{value: "65536:1", err: "65536:1: AS 65536 is a 4-byte AS, which " +
+			"does not fit in a standard community; use an AS4:NN extended community"},
+		{value: "1.10:1", err: "1.10:1: AS 65546 is a 4-byte AS, which " +
+			"does not fit in a standard community; use an AS4:NN extended community"},
+		{value: "x100:200", err: "x100:200: x100 is not an AS number between " +
+			"1 and 4294967295 or in asdot notation"},
+		{value: "1.65536:1", err: "1.65536:1: 1.65536 is not an AS number in " +
+			"asdot notation; each part must be between 0 and 65535"},
+		{value: "100:200:300", err: "100:200:300 unknown community value"},
+		{value: "no-exprt", err: "no-exprt unknown community value"},
+		{value: "none", err: "none unknown community value"},
+		{value: "none", allow_none: true},
+		{value: "none 100:200", allow_none: true,
+			err: "cannot configure 'none' with other attributes"},
+		{value: "", err: "No community value"},
+	}
+
+	for _, test := range tests {
+		err := policy.ValidateCommunities(test.value, test.allow_none)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%q: expected error %q", test.value, test.err)
+		case test.err != "" && err.Error() != test.err:
+			t.Errorf("%q: expected error %q, got %q", test.value, test.err, err)
+		}
+	}
+}
+
+func TestValidateExtCommunities(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{value: "100:4294967295"},
+		{value: "65536:65535"},
+		{value: "1.10:100 4294967295:1"},
+		{value: "10.0.0.1:65535"},
+		{value: "100", err: "100 is not an extended community in AA:NN or " +
+			"IPAddr:NN format"},
+		{value: "100:x", err: "100:x: value x must be a number"},
+		{value: "100:4294967296", err: "100:4294967296: value 4294967296 must be a number"},
+		{value: "65536:65536", err: "65536:65536: value must be between " +
+			"0 and 65535 with a 4-byte AS"},
+		{value: "0.100:65536", err: "0.100:65536: value must be between " +
+			"0 and 65535 with a 4-byte AS"},
+		{value: "10.0.0.1:65536", err: "10.0.0.1:65536: value must be between " +
+			"0 and 65535 with an IPv4 address"},
+		{value: "10.0.0.256:1", err: "10.0.0.256:1: 10.0.0.256 is not an IPv4 address"},
+		{value: "0:1", err: "0:1: AS 0 is reserved"},
+		{value: "", err: "No extended community value"},
+	}
+
+	for _, test := range tests {
+		err := policy.ValidateExtCommunities(test.value)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%q: expected error %q", test.value, test.err)
+		case test.err != "" && err.Error() != test.err:
+			t.Errorf("%q: expected error %q, got %q", test.value, test.err, err)
+		}
+	}
+}
+
+func TestValidateLargeCommunities(t *testing.T) {
+	tests := []struct {
+		value      string
+		allow_none bool
+		err        string
+	}{
+		{value: "1:0:0"},
+		{value: "4294967295:4294967295:4294967295 65536:1:2"},
+		{value: "none", allow_none: true},
+		{value: "none", err: "none is not a large community in ASN:X:Y format"},
+		{value: "none 1:1:1", allow_none: true,
+			err: "cannot configure 'none' with other attributes"},
+		{value: "1:1", err: "1:1 is not a large community in ASN:X:Y format"},
+		{value: "1:1:1:1", err: "1:1:1:1 is not a large community in ASN:X:Y format"},
+		{value: "0:1:1", err: "0:1:1: AS 0 is reserved"},
+		{value: "1.10:1:1", err: "1.10:1:1: AS 1.10 must be in asplain notation"},
+		{value: "4294967296:1:1", err: "4294967296:1:1: 4294967296 is not an " +
+			"AS number between 1 and 4294967295 or in asdot notation"},
+		{value: "1:4294967296:1", err: "1:4294967296:1: local data " +
+			"4294967296 must be between 0 and 4294967295"},
+		{value: "1:1:x", err: "1:1:x: local data x must be between 0 and 4294967295"},
+		{value: "", err: "No large community value"},
+	}
+
+	for _, test := range tests {
+		err := policy.ValidateLargeCommunities(test.value, test.allow_none)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%q: expected error %q", test.value, test.err)
+		case test.err != "" && err.Error() != test.err:
+			t.Errorf("%q: expected error %q, got %q", test.value, test.err, err)
+		}
+	}
+}
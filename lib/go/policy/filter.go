@@ -0,0 +1,426 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+/* Actions of a filter rule */
+const (
+	PERMIT = "permit"
+	DENY   = "deny"
+)
+
+/*
+ * The result of matching against a filter: the action and number of
+ * the first matching rule. Anything matched by no rule, including
+ * against a filter which does not exist, is denied with no rule.
+ */
+type FilterMatch struct {
+	Action string `rfc7951:"action"`
+	Rule   uint32 `rfc7951:"rule,omitempty"`
+}
+
+func (f FilterMatch) String() string {
+	if f.Rule == 0 {
+		return "no rule matched"
+	}
+	return fmt.Sprintf("%s by rule %d", f.Action, f.Rule)
+}
+
+/*
+ * Returns the first rule of the filter entry_map for which match
+ * returns true
+ */
+func firstMatch(entry_map map[string]interface{},
+	match func(rule_map map[string]interface{}) bool) FilterMatch {
+	result := FilterMatch{Action: DENY}
+
+	walkList(entry_map, "rule",
+		func(_ string, rule_map map[string]interface{}) {
+			if result.Rule != 0 || !match(rule_map) {
+				return
+			}
+			result.Rule, _ = jsonUint(rule_map["tagnode"])
+			result.Action, _ = rule_map["action"].(string)
+		})
+
+	return result
+}
+
+/*
+ * Matches prefix against prefix-list name of the route policy container
+ * route_map, where list is either prefix-list or prefix-list6. Without
+ * ge or le a rule only matches its own prefix length.
+ */
+func matchPrefixList(route_map map[string]interface{}, list, name string,
+	prefix *net.IPNet) FilterMatch {
+	plen, bits := prefix.Mask.Size()
+
+	return firstMatch(findEntry(route_map, list, name),
+		func(rule_map map[string]interface{}) bool {
+			rule_prefix, _ := rule_map["prefix"].(string)
+			_, rule_net, err := net.ParseCIDR(rule_prefix)
+			if err != nil {
+				return false
+			}
+			rule_plen, rule_bits := rule_net.Mask.Size()
+			if rule_bits != bits || plen < rule_plen ||
+				!rule_net.Contains(prefix.IP) {
+				return false
+			}
+
+			ge, has_ge := jsonUint(rule_map["ge"])
+			le, has_le := jsonUint(rule_map["le"])
+			if !has_ge && !has_le {
+				return plen == rule_plen
+			}
+			min, max := uint32(rule_plen), uint32(bits)
+			if has_ge {
+				min = ge
+			}
+			if has_le {
+				max = le
+			}
+			return uint32(plen) >= min && uint32(plen) <= max
+		})
+}
+
+/*
+ * Returns whether an access-list is an extended list, which matches a
+ * destination as well as a source
+ */
+func isExtendedAccessList(name string) bool {
+	var num int
+	if _, err := fmt.Sscan(name, &num); err != nil {
+		return false
+	}
+	return (num >= 100 && num <= 199) || (num >= 2000 && num <= 2699)
+}
+
+/*
+ * Returns whether addr matches the source or destination container
+ * addr_map of an IPv4 access-list rule, which has either a host, a
+ * network with an inverse-mask, or any
+ */
+func matchWildcard(addr_map map[string]interface{}, addr net.IP) bool {
+	if addr_map == nil {
+		return false
+	}
+	if _, ok := addr_map["any"]; ok {
+		return true
+	}
+
+	host, _ := addr_map["host"].(string)
+	network, _ := addr_map["network"].(string)
+	inverse, _ := addr_map["inverse-mask"].(string)
+	filter, wildcard := net.ParseIP(host), net.IPv4zero
+	if host == "" {
+		filter, wildcard = net.ParseIP(network), net.ParseIP(inverse)
+	}
+	if filter == nil || wildcard == nil || addr.To4() == nil {
+		return false
+	}
+
+	filter, wildcard, addr = filter.To4(), wildcard.To4(), addr.To4()
+	for i := range addr {
+		if addr[i]&^wildcard[i] != filter[i]&^wildcard[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Matches the address pair source and dest against IPv4 access-list name
+ * of the route policy container route_map. A standard list only matches
+ * the source.
+ */
+func matchAccessList(route_map map[string]interface{}, name string,
+	source, dest net.IP) FilterMatch {
+	extended := isExtendedAccessList(name)
+
+	return firstMatch(findEntry(route_map, "access-list", name),
+		func(rule_map map[string]interface{}) bool {
+			source_map, _ := rule_map["source"].(map[string]interface{})
+			if !matchWildcard(source_map, source) {
+				return false
+			}
+			if !extended {
+				return true
+			}
+			dest_map, _ := rule_map["destination"].(map[string]interface{})
+			return matchWildcard(dest_map, dest)
+		})
+}
+
+/*
+ * Matches prefix against IPv4 access-list name of the route policy
+ * container route_map, as the routing daemon does for a route-map. The
+ * address of the prefix is matched against the source, and for an
+ * extended list its netmask against the destination.
+ */
+func matchAccessListPrefix(route_map map[string]interface{}, name string,
+	prefix *net.IPNet) FilterMatch {
+	return matchAccessList(route_map, name, prefix.IP, net.IP(prefix.Mask))
+}
+
+/*
+ * Matches prefix against access-list6 name of the route policy container
+ * route_map. A rule matches prefixes within its source network, or only
+ * the network itself with exact-match.
+ */
+func matchAccessList6(route_map map[string]interface{}, name string,
+	prefix *net.IPNet) FilterMatch {
+	plen, _ := prefix.Mask.Size()
+
+	return firstMatch(findEntry(route_map, "access-list6", name),
+		func(rule_map map[string]interface{}) bool {
+			source, _ := rule_map["source"].(map[string]interface{})
+			if source == nil || prefix.IP.To4() != nil {
+				return false
+			}
+			if _, ok := source["any"]; ok {
+				return true
+			}
+
+			network, _ := source["network"].(string)
+			_, rule_net, err := net.ParseCIDR(network)
+			if err != nil {
+				return false
+			}
+			rule_plen, _ := rule_net.Mask.Size()
+			if _, ok := source["exact-match"]; ok {
+				return plen == rule_plen && rule_net.IP.Equal(prefix.IP)
+			}
+			return plen >= rule_plen && rule_net.Contains(prefix.IP)
+		})
+}
+
+/*
+ * Compiles an AS path or community regular expression, in which '_'
+ * matches a delimiter or either end of the string
+ */
+func compileFilterRegex(regex string) (*regexp.Regexp, error) {
+	return regexp.Compile(
+		strings.Replace(regex, "_", `(^|[,{}() ]|$)`, -1))
+}
+
+/*
+ * Returns the first rule of the filter entry_map whose regex matches
+ * value. A rule with an invalid regex is reported, as the routing
+ * daemon would not accept it either.
+ */
+func firstRegexMatch(entry_map map[string]interface{}, value string) (FilterMatch, error) {
+	var ret_err error
+
+	result := firstMatch(entry_map, func(rule_map map[string]interface{}) bool {
+		regex, _ := rule_map["regex"].(string)
+		re, err := compileFilterRegex(regex)
+		if err != nil {
+			if ret_err == nil {
+				ret_err = fmt.Errorf("invalid regex %q: %s", regex, err)
+			}
+			return false
+		}
+		return re.MatchString(value)
+	})
+
+	return result, ret_err
+}
+
+/*
+ * Matches the space separated AS path as_path against as-path-list name
+ * of the route policy container route_map
+ */
+func matchAsPathList(route_map map[string]interface{}, name,
+	as_path string) (FilterMatch, error) {
+	return firstRegexMatch(findEntry(route_map, "as-path-list", name),
+		strings.Join(strings.Fields(as_path), " "))
+}
+
+/* How the communities of a standard community-list rule are matched */
+type communityMatch int
+
+const (
+	//The route has all of the communities of the rule
+	COMMUNITY_MATCH_ALL communityMatch = iota
+	//The route has exactly the communities of the rule
+	COMMUNITY_MATCH_EXACT
+	//The route has any of the communities of the rule
+	COMMUNITY_MATCH_ANY
+)
+
+/*
+ * Returns community in the form in which the routing daemon displays it,
+ * so that equivalent communities compare equal: a standard community
+ * with the AS in asplain notation and extended communities prefixed by
+ * RT: or SoO:. Anything unrecognised is returned unchanged.
+ */
+func normalizeCommunity(list, community string) string {
+	switch list {
+	case "community-list":
+		if asn, nn, err := parseCommunity(community); err == nil {
+			return fmt.Sprintf("%d:%d", asn, nn)
+		}
+	case "extcommunity-list":
+		fields := strings.SplitN(community, ":", 2)
+		if len(fields) != 2 {
+			break
+		}
+		switch strings.ToLower(fields[0]) {
+		case "rt":
+			return "RT:" + fields[1]
+		case "soo":
+			return "SoO:" + fields[1]
+		}
+	}
+	return community
+}
+
+/*
+ * Returns the communities of a standard community-list rule
+ */
+func ruleCommunities(list string, rule_map map[string]interface{}) []string {
+	var communities []string
+
+	if list == "extcommunity-list" {
+		for _, key := range [...]string{"rt", "soo"} {
+			value, _ := rule_map[key].(string)
+			for _, community := range strings.Fields(value) {
+				communities = append(communities,
+					normalizeCommunity(list, key+":"+community))
+			}
+		}
+		return communities
+	}
+
+	value, _ := rule_map["community"].(string)
+	for _, community := range strings.Fields(value) {
+		communities = append(communities, normalizeCommunity(list, community))
+	}
+	return communities
+}
+
+/*
+ * Matches communities against the community-list, extcommunity-list or
+ * large-community-list name of the route policy container route_map.
+ * Standard list rules are matched according to how, while expanded list
+ * rules match their regex against the space separated communities.
+ */
+func matchCommunityList(route_map map[string]interface{}, list, name string,
+	communities []string, how communityMatch) (FilterMatch, error) {
+	list_map, _ := route_map[list].(map[string]interface{})
+
+	have := make(map[string]bool)
+	normalized := make([]string, 0, len(communities))
+	for _, community := range communities {
+		community = normalizeCommunity(list, community)
+		have[community] = true
+		normalized = append(normalized, community)
+	}
+
+	if entry_map := findEntry(list_map, "expanded", name); entry_map != nil {
+		return firstRegexMatch(entry_map, strings.Join(normalized, " "))
+	}
+
+	return firstMatch(findEntry(list_map, "standard", name),
+		func(rule_map map[string]interface{}) bool {
+			rule_communities := ruleCommunities(list, rule_map)
+			found := 0
+			for _, community := range rule_communities {
+				if have[community] {
+					found++
+				}
+			}
+			switch how {
+			case COMMUNITY_MATCH_EXACT:
+				return found == len(rule_communities) &&
+					found == len(have)
+			case COMMUNITY_MATCH_ANY:
+				return found > 0
+			}
+			return found == len(rule_communities)
+		}), nil
+}
+
+/*
+ * Returns the first rule of prefix-list name of the route policy
+ * configuration frontend_map which matches prefix. The rules of
+ * prefix-list6 name are matched for an IPv6 prefix.
+ */
+func MatchPrefixList(frontend_map map[string]interface{}, name,
+	prefix string) (*FilterMatch, error) {
+	_, ip_net, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid prefix", prefix)
+	}
+
+	list := "prefix-list"
+	if ip_net.IP.To4() == nil {
+		list = "prefix-list6"
+	}
+	route_map := policyRoute(frontend_map)
+	if findEntry(route_map, list, name) == nil {
+		return nil, fmt.Errorf("%s %s does not exist", list, name)
+	}
+
+	result := matchPrefixList(route_map, list, name, ip_net)
+	return &result, nil
+}
+
+/*
+ * Returns the first rule of access-list name of the route policy
+ * configuration frontend_map which matches the address pair source and
+ * destination. The destination is required by an extended access-list
+ * and ignored by a standard one. The rules of access-list6 name are
+ * matched for an IPv6 source, which has no destination.
+ */
+func MatchAccessList(frontend_map map[string]interface{}, name, source,
+	destination string) (*FilterMatch, error) {
+	route_map := policyRoute(frontend_map)
+
+	src := net.ParseIP(source)
+	if src == nil {
+		return nil, fmt.Errorf("%s is not a valid address", source)
+	}
+
+	if src.To4() == nil {
+		if findEntry(route_map, "access-list6", name) == nil {
+			return nil, fmt.Errorf("access-list6 %s does not exist", name)
+		}
+		if destination != "" {
+			return nil, fmt.Errorf("access-list6 %s does not match a "+
+				"destination", name)
+		}
+		host := &net.IPNet{IP: src, Mask: net.CIDRMask(128, 128)}
+		result := matchAccessList6(route_map, name, host)
+		return &result, nil
+	}
+
+	if findEntry(route_map, "access-list", name) == nil {
+		return nil, fmt.Errorf("access-list %s does not exist", name)
+	}
+	var dest net.IP
+	if isExtendedAccessList(name) {
+		if destination == "" {
+			return nil, fmt.Errorf("access-list %s is an extended "+
+				"access-list; a destination is required", name)
+		}
+		dest = net.ParseIP(destination)
+		if dest == nil || dest.To4() == nil {
+			return nil, fmt.Errorf("%s is not a valid IPv4 address",
+				destination)
+		}
+	}
+
+	result := matchAccessList(route_map, name, src, dest)
+	return &result, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/policy"
+	"testing"
+)
+
+const testFilters = `{
+	"prefix-list" : [ {
+		"tagnode" : "PL",
+		"rule" : [
+			{ "tagnode" : 5, "action" : "deny", "prefix" : "10.1.1.0/24" },
+			{ "tagnode" : 10, "action" : "permit",
+				"prefix" : "10.0.0.0/8", "le" : 24 },
+			{ "tagnode" : 100, "action" : "permit",
+				"prefix" : "0.0.0.0/0", "ge" : 30 },
+			{ "tagnode" : 20, "action" : "permit",
+				"prefix" : "192.168.0.0/16", "ge" : 24, "le" : 28 }
+		]
+	} ],
+	"prefix-list6" : [ {
+		"tagnode" : "PL",
+		"rule" : [ { "tagnode" : 10, "action" : "permit",
+			"prefix" : "2001:db8::/32" } ]
+	} ],
+	"access-list" : [ {
+		"tagnode" : 1,
+		"rule" : [
+			{ "tagnode" : 10, "action" : "deny",
+				"source" : { "host" : "10.1.1.1" } },
+			{ "tagnode" : 20, "action" : "permit",
+				"source" : { "network" : "10.0.0.0",
+					"inverse-mask" : "0.255.255.255" } },
+			{ "tagnode" : 30, "action" : "permit",
+				"source" : { "network" : "172.16.0.1",
+					"inverse-mask" : "0.0.255.0" } }
+		]
+	}, {
+		"tagnode" : 100,
+		"rule" : [
+			{ "tagnode" : 10, "action" : "permit",
+				"source" : { "network" : "10.0.0.0",
+					"inverse-mask" : "0.0.0.255" },
+				"destination" : { "host" : "192.168.1.1" } },
+			{ "tagnode" : 20, "action" : "deny",
+				"source" : { "any" : null },
+				"destination" : { "any" : null } }
+		]
+	} ],
+	"access-list6" : [ {
+		"tagnode" : "AL6",
+		"rule" : [
+			{ "tagnode" : 10, "action" : "deny",
+				"source" : { "network" : "2001:db8::1/128" } },
+			{ "tagnode" : 20, "action" : "permit",
+				"source" : { "network" : "2001:db8::/32" } }
+		]
+	} ]
+}`
+
+func filterConfig(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	var cfg map[string]interface{}
+	err := json.Unmarshal(policyConfig(testFilters), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMatchPrefixList(t *testing.T) {
+	cfg := filterConfig(t)
+
+	tests := []struct {
+		name   string
+		prefix string
+		result string
+		err    string
+	}{
+		{name: "PL", prefix: "10.1.1.0/24", result: "deny by rule 5"},
+		{name: "PL", prefix: "10.1.0.0/16", result: "permit by rule 10"},
+		{name: "PL", prefix: "10.1.1.0/25", result: "no rule matched"},
+		{name: "PL", prefix: "10.1.1.0/30", result: "permit by rule 100"},
+		{name: "PL", prefix: "192.168.1.0/24", result: "permit by rule 20"},
+		{name: "PL", prefix: "192.168.0.0/16", result: "no rule matched"},
+		{name: "PL", prefix: "2001:db8::/32", result: "permit by rule 10"},
+		{name: "PL", prefix: "2001:db8::/48", result: "no rule matched"},
+		{name: "NONE", prefix: "10.0.0.0/8",
+			err: "prefix-list NONE does not exist"},
+		{name: "PL", prefix: "10.0.0.0", err: "10.0.0.0 is not a valid prefix"},
+	}
+
+	for _, test := range tests {
+		result, err := policy.MatchPrefixList(cfg, test.name, test.prefix)
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %s: expected error %q, got %v", test.name,
+					test.prefix, test.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s %s: unexpected error: %s", test.name, test.prefix, err)
+		case result.String() != test.result:
+			t.Errorf("%s %s: expected %s, got %s", test.name, test.prefix,
+				test.result, result)
+		}
+	}
+}
+
+func TestMatchAccessList(t *testing.T) {
+	cfg := filterConfig(t)
+
+	tests := []struct {
+		name   string
+		source string
+		dest   string
+		result string
+		err    string
+	}{
+		{name: "1", source: "10.1.1.1", result: "deny by rule 10"},
+		{name: "1", source: "10.1.1.2", dest: "1.1.1.1",
+			result: "permit by rule 20"},
+		{name: "1", source: "172.16.9.1", result: "permit by rule 30"},
+		{name: "1", source: "172.16.9.2", result: "no rule matched"},
+		{name: "100", source: "10.0.0.7", dest: "192.168.1.1",
+			result: "permit by rule 10"},
+		{name: "100", source: "10.0.1.7", dest: "192.168.1.1",
+			result: "deny by rule 20"},
+		{name: "AL6", source: "2001:db8::1", result: "deny by rule 10"},
+		{name: "AL6", source: "2001:db8::2", result: "permit by rule 20"},
+		{name: "AL6", source: "2001:db9::1", result: "no rule matched"},
+		{name: "100", source: "10.0.0.7",
+			err: "access-list 100 is an extended access-list; a destination is required"},
+		{name: "100", source: "10.0.0.7", dest: "2001::1",
+			err: "2001::1 is not a valid IPv4 address"},
+		{name: "AL6", source: "2001:db8::1", dest: "2001:db8::2",
+			err: "access-list6 AL6 does not match a destination"},
+		{name: "2", source: "10.0.0.1", err: "access-list 2 does not exist"},
+		{name: "1", source: "10.0.0", err: "10.0.0 is not a valid address"},
+	}
+
+	for _, test := range tests {
+		result, err := policy.MatchAccessList(cfg, test.name, test.source,
+			test.dest)
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %s: expected error %q, got %v", test.name,
+					test.source, test.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s %s: unexpected error: %s", test.name, test.source, err)
+		case result.String() != test.result:
+			t.Errorf("%s %s: expected %s, got %s", test.name, test.source,
+				test.result, result)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* Kinds of policy object, named by their list in the route policy container */
+var policyObjectLists = []string{
+	"access-list", "access-list6", "as-path-list", "prefix-list",
+	"prefix-list6", "route-map",
+}
+
+/* Kinds of policy object which have standard and expanded lists */
+var policyCommunityLists = []string{
+	"community-list", "extcommunity-list", "large-community-list",
+}
+
+/*
+ * Kind of policy object referenced by a leaf of a protocol configuration,
+ * by the name of the leaf. A prefix-list or access-list referenced from
+ * the configuration of an IPv6 protocol or address-family is an IPv6
+ * list.
+ */
+var protocolReferenceKinds = map[string]string{
+	"route-map":    "route-map",
+	"prefix-list":  "prefix-list",
+	"prefix-list6": "prefix-list6",
+	"access-list":  "access-list",
+	"access-list6": "access-list6",
+	"filter-list":  "as-path-list",
+	"as-path-list": "as-path-list",
+}
+
+/* Top level containers in which protocol configurations are found */
+var protocolRoots = []string{"protocols", "routing"}
+
+/* Protocols which only route IPv6 */
+var ipv6Protocols = map[string]bool{
+	"ripng":  true,
+	"ospfv3": true,
+}
+
+/*
+ * Returns whether the configuration below key, within parent, is for
+ * IPv6, given whether that of parent is
+ */
+func isIpv6Config(parent, key string, ipv6 bool) bool {
+	if ipv6Protocols[key] {
+		return true
+	}
+	if parent == "address-family" {
+		return strings.HasPrefix(key, "ipv6")
+	}
+	return ipv6
+}
+
+/* A route-map, or a list used by route-maps */
+type PolicyObject struct {
+	Kind string
+	Name string
+}
+
+func (o PolicyObject) String() string {
+	return o.Kind + " " + o.Name
+}
+
+/* A reference to a policy object by the leaf at config path Path */
+type PolicyReference struct {
+	Path   string
+	Object PolicyObject
+}
+
+/*
+ * PolicyGraph records the policy objects of a configuration, with their
+ * config paths, and the references to them, both from route-maps and
+ * from protocols.
+ */
+type PolicyGraph struct {
+	objects map[PolicyObject]string
+	refs    []PolicyReference
+}
+
+func (g *PolicyGraph) addRef(path, kind string, name interface{}) {
+	if name == nil {
+		return
+	}
+	g.refs = append(g.refs, PolicyReference{
+		Path:   path,
+		Object: PolicyObject{Kind: kind, Name: fmt.Sprint(name)},
+	})
+}
+
+/*
+ * Adds the references of the access-list or prefix-list leaves of
+ * container addr_map of a route-map match ip or ipv6 container
+ */
+func (g *PolicyGraph) addAddressRefs(addr_map map[string]interface{},
+	path, suffix string) {
+	for _, list := range [...]string{"access-list", "prefix-list"} {
+		if name, ok := addr_map[list]; ok {
+			g.addRef(path+" "+list, list+suffix, name)
+		}
+	}
+}
+
+/*
+ * Adds the references of route-map rule rule_map, at path
+ */
+func (g *PolicyGraph) addRuleRefs(rule_map map[string]interface{}, path string) {
+	if match_map, ok := rule_map["match"].(map[string]interface{}); ok {
+		match_path := path + " match"
+
+		for family, suffix := range map[string]string{"ip": "", "ipv6": "6"} {
+			ip_map, _ := match_map[family].(map[string]interface{})
+			ip_path := match_path + " " + family
+			for _, key := range [...]string{"address", "nexthop", "peer"} {
+				if addr_map, ok := ip_map[key].(map[string]interface{}); ok {
+					g.addAddressRefs(addr_map, ip_path+" "+key, suffix)
+				}
+			}
+		}
+
+		for _, list := range policyCommunityLists {
+			match := strings.TrimSuffix(list, "-list")
+			comm_map, _ := match_map[match].(map[string]interface{})
+			if name, ok := comm_map[list]; ok {
+				g.addRef(match_path+" "+match+" "+list, list, name)
+			}
+		}
+
+		if name, ok := match_map["as-path"]; ok {
+			g.addRef(match_path+" as-path", "as-path-list", name)
+		}
+	}
+
+	if set_map, ok := rule_map["set"].(map[string]interface{}); ok {
+		for _, list := range policyCommunityLists {
+			key := "delete-" + strings.TrimSuffix(list, "-list")
+			if name, ok := set_map[key]; ok {
+				g.addRef(path+" set "+key, list, name)
+			}
+		}
+	}
+}
+
+/*
+ * Returns the key of list entry entry_map, used in its config path
+ */
+func entryKey(entry_map map[string]interface{}) interface{} {
+	if tagnode, ok := entry_map["tagnode"]; ok {
+		return tagnode
+	}
+	for key, value := range entry_map {
+		if key == "name" || strings.HasSuffix(key, "-name") {
+			return value
+		}
+	}
+	return nil
+}
+
+/*
+ * Adds the references to policy objects found below value, the child
+ * parent of a protocol configuration at path. A reference is a leaf
+ * named after the kind of object, or a leaf within a container of that
+ * name, such as:
+ *
+ *     redistribute static route-map RM
+ *     route-map import RM
+ *
+ * ipv6 is whether value is the configuration of an IPv6 protocol or
+ * address-family.
+ */
+func (g *PolicyGraph) addProtocolRefs(value interface{}, path, parent string,
+	ipv6 bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			child_path := strings.TrimSpace(path + " " + key)
+			kind, ok := protocolReferenceKinds[key]
+			if !ok {
+				g.addProtocolRefs(child, child_path, key,
+					isIpv6Config(parent, key, ipv6))
+				continue
+			}
+			if (kind == "prefix-list" || kind == "access-list") && ipv6 {
+				kind += "6"
+			}
+
+			switch c := child.(type) {
+			case string, float64:
+				g.addRef(child_path, kind, c)
+			case map[string]interface{}:
+				for dir, name := range c {
+					switch name.(type) {
+					case string, float64:
+						g.addRef(child_path+" "+dir, kind, name)
+					}
+				}
+			}
+		}
+	case []interface{}:
+		for _, entry := range v {
+			entry_map, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			entry_path := path
+			entry_ipv6 := ipv6
+			if key := entryKey(entry_map); key != nil {
+				entry_path = fmt.Sprintf("%s %v", path, key)
+				entry_ipv6 = isIpv6Config(parent, fmt.Sprint(key), ipv6)
+			}
+			g.addProtocolRefs(entry_map, entry_path, parent, entry_ipv6)
+		}
+	}
+}
+
+/*
+ * Builds the policy graph of the route policy configuration in
+ * frontend_map. Protocol references are found in the protocols and
+ * routing containers of frontend_map and of each of protocol_maps, the
+ * configurations of the models of the protocols, so are only known for
+ * protocol configuration present.
+ */
+func NewPolicyGraph(frontend_map map[string]interface{},
+	protocol_maps ...map[string]interface{}) *PolicyGraph {
+	g := &PolicyGraph{objects: make(map[PolicyObject]string)}
+	route_map := policyRoute(frontend_map)
+
+	for _, list := range policyObjectLists {
+		walkList(route_map, list,
+			func(name string, _ map[string]interface{}) {
+				g.objects[PolicyObject{Kind: list, Name: name}] =
+					entryPath(POLICY_ROUTE_PATH, list, name)
+			})
+	}
+	for _, list := range policyCommunityLists {
+		list_map, _ := route_map[list].(map[string]interface{})
+		list_path := POLICY_ROUTE_PATH + " " + list
+		for _, typ := range [...]string{"standard", "expanded"} {
+			walkList(list_map, typ,
+				func(name string, _ map[string]interface{}) {
+					g.objects[PolicyObject{Kind: list, Name: name}] =
+						entryPath(list_path, typ, name)
+				})
+		}
+	}
+
+	walkList(route_map, "route-map",
+		func(name string, rmap_map map[string]interface{}) {
+			rmap_path := entryPath(POLICY_ROUTE_PATH, "route-map", name)
+			walkList(rmap_map, "rule",
+				func(rule string, rule_map map[string]interface{}) {
+					g.addRuleRefs(rule_map, entryPath(rmap_path, "rule", rule))
+				})
+		})
+
+	for _, cfg_map := range append([]map[string]interface{}{frontend_map},
+		protocol_maps...) {
+		for _, root := range protocolRoots {
+			if root_map, ok := cfg_map[root]; ok {
+				g.addProtocolRefs(root_map, root, root, false)
+			}
+		}
+	}
+
+	sort.Slice(g.refs, func(i, j int) bool {
+		return g.refs[i].Path < g.refs[j].Path
+	})
+	return g
+}
+
+/* Returns whether the policy object exists */
+func (g *PolicyGraph) Exists(obj PolicyObject) bool {
+	_, ok := g.objects[obj]
+	return ok
+}
+
+/* Returns the config path of the policy object, if it exists */
+func (g *PolicyGraph) Path(obj PolicyObject) string {
+	return g.objects[obj]
+}
+
+/* Returns the config paths of the references to the policy object */
+func (g *PolicyGraph) ReferencedBy(obj PolicyObject) []string {
+	var paths []string
+	for _, ref := range g.refs {
+		if ref.Object == obj {
+			paths = append(paths, ref.Path)
+		}
+	}
+	return paths
+}
+
+/* Returns the references to policy objects which do not exist */
+func (g *PolicyGraph) Dangling() []PolicyReference {
+	var dangling []PolicyReference
+	for _, ref := range g.refs {
+		if !g.Exists(ref.Object) {
+			dangling = append(dangling, ref)
+		}
+	}
+	return dangling
+}
+
+/* Returns the policy objects which are not referenced, in path order */
+func (g *PolicyGraph) Unused() []PolicyObject {
+	used := make(map[PolicyObject]bool)
+	for _, ref := range g.refs {
+		used[ref.Object] = true
+	}
+
+	var unused []PolicyObject
+	for obj, _ := range g.objects {
+		if !used[obj] {
+			unused = append(unused, obj)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		return g.objects[unused[i]] < g.objects[unused[j]]
+	})
+	return unused
+}
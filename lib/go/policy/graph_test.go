@@ -0,0 +1,267 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/policy"
+	"reflect"
+	"testing"
+)
+
+const testGraph = `{
+	"policy" : { "route" : {
+		"prefix-list" : [ { "tagnode" : "PL" }, { "tagnode" : "UNUSED" } ],
+		"prefix-list6" : [ { "tagnode" : "PL" } ],
+		"access-list" : [ { "tagnode" : 1 } ],
+		"as-path-list" : [ { "tagnode" : "AS" } ],
+		"community-list" : {
+			"standard" : [ { "tagnode" : 1 } ],
+			"expanded" : [ { "tagnode" : 100 } ]
+		},
+		"route-map" : [ {
+			"tagnode" : "IMPORT",
+			"rule" : [ {
+				"tagnode" : 10,
+				"action" : "permit",
+				"match" : {
+					"ip" : {
+						"address" : { "prefix-list" : "PL" },
+						"nexthop" : { "access-list" : 1 }
+					},
+					"community" : { "community-list" : 1 },
+					"as-path" : "AS"
+				}
+			}, {
+				"tagnode" : 20,
+				"action" : "permit",
+				"set" : { "delete-community" : 100 }
+			} ]
+		}, {
+			"tagnode" : "EXPORT",
+			"rule" : [ {
+				"tagnode" : 10,
+				"action" : "deny",
+				"match" : {
+					"ipv6" : { "address" : { "prefix-list" : "PL" } }
+				}
+			} ]
+		}, {
+			"tagnode" : "SPARE"
+		} ]
+	} },
+	"protocols" : {
+		"bgp" : [ {
+			"tagnode" : 100,
+			"neighbor" : [ {
+				"tagnode" : "2001:db8::1",
+				"address-family" : {
+					"ipv6-unicast" : {
+						"route-map" : { "import" : "IMPORT", "export" : "EXPORT" },
+						"prefix-list" : { "import" : "PL" },
+						"filter-list" : { "export" : "MISSING" }
+					}
+				}
+			} ]
+		} ],
+		"static" : { "route-map" : "IMPORT" }
+	}
+}`
+
+func graphConfig(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	var cfg map[string]interface{}
+	err := json.Unmarshal([]byte(testGraph), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestPolicyGraphReferencedBy(t *testing.T) {
+	graph := policy.NewPolicyGraph(graphConfig(t))
+
+	tests := []struct {
+		obj    policy.PolicyObject
+		exists bool
+		refs   []string
+	}{
+		{
+			obj:    policy.PolicyObject{Kind: "route-map", Name: "IMPORT"},
+			exists: true,
+			refs: []string{
+				"protocols bgp 100 neighbor 2001:db8::1 address-family ipv6-unicast route-map import",
+				"protocols static route-map",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "prefix-list", Name: "PL"},
+			exists: true,
+			refs: []string{
+				"policy route route-map IMPORT rule 10 match ip address prefix-list",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "prefix-list6", Name: "PL"},
+			exists: true,
+			refs: []string{
+				"policy route route-map EXPORT rule 10 match ipv6 address prefix-list",
+				"protocols bgp 100 neighbor 2001:db8::1 address-family ipv6-unicast prefix-list import",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "access-list", Name: "1"},
+			exists: true,
+			refs: []string{
+				"policy route route-map IMPORT rule 10 match ip nexthop access-list",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "community-list", Name: "100"},
+			exists: true,
+			refs: []string{
+				"policy route route-map IMPORT rule 20 set delete-community",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "as-path-list", Name: "MISSING"},
+			exists: false,
+			refs: []string{
+				"protocols bgp 100 neighbor 2001:db8::1 address-family ipv6-unicast filter-list export",
+			},
+		},
+		{
+			obj:    policy.PolicyObject{Kind: "route-map", Name: "SPARE"},
+			exists: true,
+		},
+	}
+
+	for _, test := range tests {
+		if exists := graph.Exists(test.obj); exists != test.exists {
+			t.Errorf("%s: expected exists %v, got %v", test.obj, test.exists,
+				exists)
+		}
+		refs := graph.ReferencedBy(test.obj)
+		if !reflect.DeepEqual(refs, test.refs) {
+			t.Errorf("%s: expected references %q, got %q", test.obj,
+				test.refs, refs)
+		}
+	}
+}
+
+func TestPolicyGraphDangling(t *testing.T) {
+	graph := policy.NewPolicyGraph(graphConfig(t))
+
+	expected := []policy.PolicyReference{
+		{
+			Path:   "protocols bgp 100 neighbor 2001:db8::1 address-family ipv6-unicast filter-list export",
+			Object: policy.PolicyObject{Kind: "as-path-list", Name: "MISSING"},
+		},
+	}
+	if dangling := graph.Dangling(); !reflect.DeepEqual(dangling, expected) {
+		t.Errorf("expected dangling references %v, got %v", expected, dangling)
+	}
+}
+
+func TestPolicyGraphUnused(t *testing.T) {
+	graph := policy.NewPolicyGraph(graphConfig(t))
+
+	expected := []policy.PolicyObject{
+		{Kind: "prefix-list", Name: "UNUSED"},
+		{Kind: "route-map", Name: "SPARE"},
+	}
+	if unused := graph.Unused(); !reflect.DeepEqual(unused, expected) {
+		t.Errorf("expected unused objects %v, got %v", expected, unused)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	expected := []string{
+		"[policy route prefix-list UNUSED]\nprefix-list UNUSED is not used",
+		"[policy route route-map SPARE]\nroute-map SPARE is not used",
+	}
+	if warnings := policy.Warnings(graphConfig(t)); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected warnings %q, got %q", expected, warnings)
+	}
+}
+
+func TestPolicyGraphProtocolModels(t *testing.T) {
+	var policy_map, ripng_map, bgp_map map[string]interface{}
+
+	for cfg, m := range map[string]*map[string]interface{}{
+		`{ "policy" : { "route" : {
+			"prefix-list" : [ { "tagnode" : "PL" } ],
+			"prefix-list6" : [ { "tagnode" : "PL" } ],
+			"route-map" : [ { "tagnode" : "RM" } ]
+		} } }`: &policy_map,
+		`{ "protocols" : {
+			"ripng" : {
+				"distribute-list" : { "prefix-list" : { "in" : "PL" } }
+			},
+			"ospfv3" : {
+				"area" : [ {
+					"tagnode" : "0.0.0.0",
+					"prefix-list" : { "import" : "PL" }
+				} ]
+			}
+		} }`: &ripng_map,
+		`{ "protocols" : { "bgp" : [ {
+			"tagnode" : 100,
+			"peer-group" : [ {
+				"tagnode" : "ipv6",
+				"address-family" : {
+					"ipv4-unicast" : {
+						"prefix-list" : { "import" : "PL" },
+						"route-map" : { "export" : "RM" }
+					}
+				}
+			} ]
+		} ] } }`: &bgp_map,
+	} {
+		err := json.Unmarshal([]byte(cfg), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph := policy.NewPolicyGraph(policy_map, ripng_map, bgp_map)
+
+	tests := []struct {
+		obj  policy.PolicyObject
+		refs []string
+	}{
+		{
+			obj: policy.PolicyObject{Kind: "prefix-list", Name: "PL"},
+			refs: []string{
+				"protocols bgp 100 peer-group ipv6 address-family ipv4-unicast prefix-list import",
+			},
+		},
+		{
+			obj: policy.PolicyObject{Kind: "prefix-list6", Name: "PL"},
+			refs: []string{
+				"protocols ospfv3 area 0.0.0.0 prefix-list import",
+				"protocols ripng distribute-list prefix-list in",
+			},
+		},
+		{
+			obj: policy.PolicyObject{Kind: "route-map", Name: "RM"},
+			refs: []string{
+				"protocols bgp 100 peer-group ipv6 address-family ipv4-unicast route-map export",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		refs := graph.ReferencedBy(test.obj)
+		if !reflect.DeepEqual(refs, test.refs) {
+			t.Errorf("%s: expected references %q, got %q", test.obj,
+				test.refs, refs)
+		}
+	}
+	if unused := graph.Unused(); len(unused) != 0 {
+		t.Errorf("expected no unused objects, got %v", unused)
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	multierr "github.com/hashicorp/go-multierror"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/* Highest route-map rule number */
+const MAX_ROUTE_MAP_RULE = 65535
+
+/*
+ * Time allowed for the routing daemon to apply all the configurations of
+ * an action change plan, and the interval at which its running
+ * configuration is polled to see whether it has applied each
+ */
+var (
+	actionChangeTimeout      = 30 * time.Second
+	actionChangePollInterval = 100 * time.Millisecond
+)
+
+/*
+ * A route-map rule whose action is changed, and the rule used in its
+ * place while it is re-created
+ */
+type actionChange struct {
+	routeMap string
+	rule     string
+	temp     uint32
+	oldRule  map[string]interface{}
+}
+
+/*
+ * Returns a deep copy of the JSON decoded value
+ */
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copy_map := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			copy_map[key] = copyValue(child)
+		}
+		return copy_map
+	case []interface{}:
+		copy_arr := make([]interface{}, len(v))
+		for i, child := range v {
+			copy_arr[i] = copyValue(child)
+		}
+		return copy_arr
+	}
+	return value
+}
+
+/*
+ * Returns the rules of route-map rmap_map which are continued to
+ */
+func continueTargets(rmap_map map[string]interface{}) map[uint32]bool {
+	targets := make(map[uint32]bool)
+	walkList(rmap_map, "rule",
+		func(_ string, rule_map map[string]interface{}) {
+			if cont, ok := jsonUint(rule_map["continue"]); ok {
+				targets[cont] = true
+			}
+		})
+	return targets
+}
+
+/*
+ * Returns the route-map rules whose action differs between the running
+ * configuration old_map and the candidate frontend_map, each with the
+ * rule following it as its temporary rule.
+ *
+ * The temporary rule must be free in both configurations, so that the
+ * routing daemon does not see its action change, and must not be
+ * continued to, so that it is only reached where the rule being changed
+ * would be.
+ */
+func findActionChanges(frontend_map, old_map map[string]interface{}) ([]actionChange, error) {
+	ret_err := protocols.NewMultiError()
+	var changes []actionChange
+
+	walkList(policyRoute(frontend_map), "route-map",
+		func(name string, rmap_map map[string]interface{}) {
+			old_rmap_map := findEntry(policyRoute(old_map), "route-map", name)
+			if old_rmap_map == nil {
+				return
+			}
+			rmap_path := entryPath(POLICY_ROUTE_PATH, "route-map", name)
+			targets := continueTargets(rmap_map)
+			for target, _ := range continueTargets(old_rmap_map) {
+				targets[target] = true
+			}
+
+			walkList(rmap_map, "rule",
+				func(rule string, rule_map map[string]interface{}) {
+					old_rule_map := findEntry(old_rmap_map, "rule", rule)
+					if old_rule_map == nil ||
+						old_rule_map["action"] == rule_map["action"] {
+						return
+					}
+
+					path := entryPath(rmap_path, "rule", rule) + " action"
+					n, _ := jsonUint(rule_map["tagnode"])
+					temp := n + 1
+					if temp > MAX_ROUTE_MAP_RULE ||
+						findEntry(rmap_map, "rule", fmt.Sprint(temp)) != nil ||
+						findEntry(old_rmap_map, "rule", fmt.Sprint(temp)) != nil ||
+						targets[temp] {
+						ret_err = multierr.Append(ret_err, pathError(path,
+							"Cannot change action from %v to %v; rule %d "+
+								"must be free, and not continued to, to make "+
+								"the change without disrupting routing",
+							old_rule_map["action"], rule_map["action"], temp))
+						return
+					}
+
+					changes = append(changes, actionChange{
+						routeMap: name,
+						rule:     rule,
+						temp:     temp,
+						oldRule:  old_rule_map,
+					})
+				})
+		})
+
+	return changes, ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns a copy of frontend_map in which each changed rule is followed
+ * by its temporary rule, a copy of the rule from frontend_map. The
+ * changed rule itself is replaced by its running configuration if
+ * keep_old, kept if keep_new, and otherwise removed.
+ */
+func planStep(frontend_map map[string]interface{}, changes []actionChange,
+	keep_old, keep_new bool) map[string]interface{} {
+	step_map := copyValue(frontend_map).(map[string]interface{})
+
+	for _, change := range changes {
+		rmap_map := findEntry(policyRoute(step_map), "route-map",
+			change.routeMap)
+		arr, _ := rmap_map["rule"].([]interface{})
+
+		rules := make([]interface{}, 0, len(arr)+1)
+		for _, entry := range arr {
+			entry_map, ok := entry.(map[string]interface{})
+			if !ok || fmt.Sprint(entry_map["tagnode"]) != change.rule {
+				rules = append(rules, entry)
+				continue
+			}
+
+			temp_map := copyValue(entry_map).(map[string]interface{})
+			temp_map["tagnode"] = float64(change.temp)
+
+			if keep_old {
+				rules = append(rules, copyValue(change.oldRule))
+			} else if keep_new {
+				rules = append(rules, entry_map)
+			}
+			rules = append(rules, temp_map)
+		}
+		rmap_map["rule"] = rules
+	}
+
+	return step_map
+}
+
+/*
+ * Plans the change from the running configuration old_map to the
+ * candidate frontend_map as a sequence of configurations, to be applied
+ * in order, ending with frontend_map. frontend_map is not modified.
+ *
+ * The routing daemon discards the whole route-map when the action of
+ * one of its rules changes. Instead each such rule N is copied, with
+ * its new action, to a temporary rule N+1 which takes over when rule N
+ * is deleted, and is itself deleted once rule N has been re-created:
+ *
+ *     1. rule N (old action), rule N+1 (new action)
+ *     2. rule N+1 (new action)
+ *     3. rule N (new action), rule N+1 (new action)
+ *     4. rule N (new action)
+ *
+ * Routes are matched with the old action until the second configuration
+ * is applied, and with the new action from then on. An error is
+ * returned for each changed rule which cannot be planned.
+ */
+func PlanActionChanges(frontend_map, old_map map[string]interface{}) ([]map[string]interface{}, error) {
+	steps, _, err := planActionChanges(frontend_map, old_map)
+	return steps, err
+}
+
+/*
+ * Returns the plan of PlanActionChanges, and the changes it makes
+ */
+func planActionChanges(frontend_map, old_map map[string]interface{}) ([]map[string]interface{}, []actionChange, error) {
+	changes, err := findActionChanges(frontend_map, old_map)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) == 0 {
+		return []map[string]interface{}{frontend_map}, nil, nil
+	}
+
+	return []map[string]interface{}{
+		planStep(frontend_map, changes, true, false),
+		planStep(frontend_map, changes, false, false),
+		planStep(frontend_map, changes, false, true),
+		frontend_map,
+	}, changes, nil
+}
+
+/*
+ * Returns whether the routing daemon, whose running configuration is
+ * running_cfg, has applied the route-maps names of the configuration
+ * step_map: each must have the same rules, with the same actions.
+ */
+func RouteMapsApplied(running_cfg string, step_map map[string]interface{},
+	names []string) bool {
+	expected := make(map[string]bool)
+	applied := make(map[string]bool)
+	wanted := make(map[string]bool)
+
+	for _, name := range names {
+		wanted[name] = true
+		rmap_map := findEntry(policyRoute(step_map), "route-map", name)
+		walkList(rmap_map, "rule",
+			func(rule string, rule_map map[string]interface{}) {
+				expected[fmt.Sprintf("route-map %s %v %s", name,
+					rule_map["action"], rule)] = true
+			})
+	}
+
+	//Rules are shown as route-map <name> <action> <rule>
+	for _, line := range strings.Split(running_cfg, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[0] == "route-map" && wanted[fields[1]] {
+			applied[strings.Join(fields, " ")] = true
+		}
+	}
+
+	return reflect.DeepEqual(expected, applied)
+}
+
+/*
+ * Waits until deadline for the routing daemon, whose running
+ * configuration is returned by running, to apply the route-maps names of
+ * the configuration step_map, returning whether it has
+ */
+func waitRouteMapsApplied(step_map map[string]interface{}, names []string,
+	running func() string, deadline time.Time) bool {
+	for {
+		if RouteMapsApplied(running(), step_map, names) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(actionChangePollInterval)
+	}
+}
+
+/*
+ * Returns the route-maps whose rules are changed, in order
+ */
+func changedRouteMaps(changes []actionChange) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, change := range changes {
+		if !seen[change.routeMap] {
+			seen[change.routeMap] = true
+			names = append(names, change.routeMap)
+		}
+	}
+
+	return names
+}
+
+/*
+ * Writes each configuration of the plan steps in turn with write, once
+ * the routing daemon has applied the changed route-maps names of the
+ * previous one, returning the number of configurations written. The
+ * daemon is allowed timeout to apply all of them.
+ */
+func applySteps(steps []map[string]interface{}, names []string,
+	write func([]byte) error, running func() string,
+	timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+
+	for i, step := range steps {
+		if i > 0 && !waitRouteMapsApplied(steps[i-1], names, running, deadline) {
+			return i, fmt.Errorf("Routing daemon did not apply route-maps %s "+
+				"within %s", strings.Join(names, ", "), timeout)
+		}
+
+		step_cfg, err := json.Marshal(step)
+		if err != nil {
+			return i, err
+		}
+		err = write(step_cfg)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(steps), nil
+}
+
+/*
+ * Changes the configuration of the routing daemon from old_map to the
+ * candidate frontend_map, writing each configuration planned by
+ * PlanActionChanges with write once the daemon's running configuration,
+ * as returned by running, shows that it has applied the changed
+ * route-maps of the previous one. The daemon is allowed timeout to apply
+ * the whole plan. Nothing is written if the change cannot be planned.
+ *
+ * If a configuration cannot be written or applied, the previous
+ * configuration old_map is restored by writing those already written in
+ * reverse order, in the same way and allowing timeout again, so that the
+ * daemon is not left part way through the plan.
+ */
+func ApplyActionChanges(frontend_map, old_map map[string]interface{},
+	write func([]byte) error, running func() string,
+	timeout time.Duration) error {
+	steps, changes, err := planActionChanges(frontend_map, old_map)
+	if err != nil {
+		return err
+	}
+	names := changedRouteMaps(changes)
+
+	written, err := applySteps(steps, names, write, running, timeout)
+	if err == nil || written == 0 {
+		return err
+	}
+	log.Errorln("Restoring previous route policy configuration: " +
+		err.Error())
+
+	ret_err := protocols.NewMultiError()
+	ret_err = multierr.Append(ret_err, err)
+
+	var restore []map[string]interface{}
+	for i := written - 2; i >= 0; i-- {
+		restore = append(restore, steps[i])
+	}
+	restore = append(restore, old_map)
+
+	_, err = applySteps(restore, names, write, running, timeout)
+	if err != nil {
+		ret_err = multierr.Append(ret_err, fmt.Errorf(
+			"Failed to restore previous route policy configuration: %s", err))
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the internal JSON of the cached system configuration of pmc,
+ * which is empty if none has been committed
+ */
+func loadSystemConfig(pmc *protocols.ProtocolsModelComponent) (map[string]interface{}, error) {
+	cfg_map := make(map[string]interface{})
+
+	sys_cfg, err := pmc.GetSystemConfig()
+	if os.IsNotExist(err) {
+		return cfg_map, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg_json, err := protocols.ConvertConfigToInternalJson(sys_cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(cfg_json, &cfg_map)
+	return cfg_map, err
+}
+
+/*
+ * Returns the internal JSON of the configuration last written to the
+ * daemon configuration file of pmc, which is empty if none has been
+ * written. Unlike the cached system configuration, this is the previous
+ * configuration restored by a set which fails.
+ */
+func loadDaemonConfig(pmc *protocols.ProtocolsModelComponent) (map[string]interface{}, error) {
+	cfg_map := make(map[string]interface{})
+
+	cfg, err := ioutil.ReadFile(pmc.GetDaemonConfigFilePath())
+	if os.IsNotExist(err) {
+		return cfg_map, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(cfg, &cfg_map)
+	return cfg_map, err
+}
+
+/*
+ * ProtocolsModelComponent set function writing the route policy
+ * configuration to the daemon configuration file, with AS numbers
+ * converted to asplain notation by TranslateAsdot.
+ *
+ * When route-map rule actions are changed, each configuration planned
+ * by PlanActionChanges is written in turn by ApplyActionChanges, polling
+ * the routing daemon's running configuration with vtysh until it shows
+ * that it has applied the changed route-maps of the previous one. A
+ * commit may so block for up to actionChangeTimeout (30s), and as long
+ * again if the previous configuration must be restored.
+ * A component with its own set function should do the same with its
+ * translated configuration.
+ */
+func Set(pmc *protocols.ProtocolsModelComponent, cfg []byte) error {
+	var frontend_map map[string]interface{}
+
+	err := json.Unmarshal(cfg, &frontend_map)
+	if err != nil {
+		return err
+	}
+	TranslateAsdot(frontend_map)
+
+	old_map, err := loadDaemonConfig(pmc)
+	if err != nil {
+		return fmt.Errorf("Cannot plan route-map action changes: %s", err)
+	}
+
+	return ApplyActionChanges(frontend_map, old_map,
+		func(step_cfg []byte) error {
+			return pmc.WriteDaemonConfig(protocols.FormatJson(step_cfg))
+		},
+		func() string {
+			return string(protocols.CallVtysh("show running-config"))
+		},
+		actionChangeTimeout)
+}
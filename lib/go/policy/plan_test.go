@@ -0,0 +1,457 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/policy"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func planConfig(t *testing.T, route string) map[string]interface{} {
+	t.Helper()
+
+	var cfg map[string]interface{}
+	err := json.Unmarshal(policyConfig(route), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+/*
+ * Returns the rules of each route-map of a planned configuration as
+ * "RM 10:permit 20:deny", in configuration order
+ */
+func planRules(cfg map[string]interface{}) string {
+	var maps []string
+
+	route_map := cfg["policy"].(map[string]interface{})["route"].(map[string]interface{})
+	for _, rmap := range route_map["route-map"].([]interface{}) {
+		rmap_map := rmap.(map[string]interface{})
+		words := []string{fmt.Sprint(rmap_map["tagnode"])}
+		rules, _ := rmap_map["rule"].([]interface{})
+		for _, rule := range rules {
+			rule_map := rule.(map[string]interface{})
+			words = append(words, fmt.Sprintf("%v:%v", rule_map["tagnode"],
+				rule_map["action"]))
+		}
+		maps = append(maps, strings.Join(words, " "))
+	}
+
+	return strings.Join(maps, ", ")
+}
+
+func TestPlanActionChanges(t *testing.T) {
+	old := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "permit",
+					"set" : { "metric" : "10" } },
+				{ "tagnode" : 20, "action" : "deny" }
+			]
+		}, {
+			"tagnode" : "RM2",
+			"rule" : [ { "tagnode" : 5, "action" : "deny" } ]
+		} ]
+	}`)
+
+	tests := []struct {
+		name  string
+		route string
+		steps []string
+	}{
+		{
+			name: "unchanged",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [
+						{ "tagnode" : 10, "action" : "permit" },
+						{ "tagnode" : 30, "action" : "deny" }
+					]
+				}, {
+					"tagnode" : "RM3",
+					"rule" : [ { "tagnode" : 10, "action" : "deny" } ]
+				} ]
+			}`,
+			steps: []string{"RM 10:permit 30:deny, RM3 10:deny"},
+		},
+		{
+			name: "actions changed",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [
+						{ "tagnode" : 10, "action" : "deny" },
+						{ "tagnode" : 20, "action" : "deny" }
+					]
+				}, {
+					"tagnode" : "RM2",
+					"rule" : [ { "tagnode" : 5, "action" : "permit" } ]
+				} ]
+			}`,
+			steps: []string{
+				"RM 10:permit 11:deny 20:deny, RM2 5:deny 6:permit",
+				"RM 11:deny 20:deny, RM2 6:permit",
+				"RM 10:deny 11:deny 20:deny, RM2 5:permit 6:permit",
+				"RM 10:deny 20:deny, RM2 5:permit",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		cfg := planConfig(t, test.route)
+		steps, err := policy.PlanActionChanges(cfg, old)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		var rules []string
+		for _, step := range steps {
+			rules = append(rules, planRules(step))
+		}
+		if !reflect.DeepEqual(rules, test.steps) {
+			t.Errorf("%s: expected steps:\n%s\ngot:\n%s", test.name,
+				strings.Join(test.steps, "\n"), strings.Join(rules, "\n"))
+		}
+		if !reflect.DeepEqual(steps[len(steps)-1], planConfig(t, test.route)) {
+			t.Errorf("%s: last step is not the candidate configuration",
+				test.name)
+		}
+	}
+}
+
+func TestPlanActionChangesRules(t *testing.T) {
+	old := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [ { "tagnode" : 10, "action" : "permit",
+				"set" : { "metric" : "10" } } ]
+		} ]
+	}`)
+	cfg := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [ { "tagnode" : 10, "action" : "deny",
+				"set" : { "metric" : "20" } } ]
+		} ]
+	}`)
+
+	steps, err := policy.PlanActionChanges(cfg, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := func(step, rule int) interface{} {
+		route_map := steps[step]["policy"].(map[string]interface{})["route"].(map[string]interface{})
+		rmap_map := route_map["route-map"].([]interface{})[0].(map[string]interface{})
+		rule_map := rmap_map["rule"].([]interface{})[rule].(map[string]interface{})
+		return rule_map["set"].(map[string]interface{})["metric"]
+	}
+
+	//The re-created rule keeps its running configuration until deleted,
+	//while the temporary rule has the candidate configuration throughout
+	if m := metric(0, 0); m != "10" {
+		t.Errorf("rule 10 of step 1: expected metric 10, got %v", m)
+	}
+	for step, rule := range [...]int{1, 0, 1} {
+		if m := metric(step, rule); m != "20" {
+			t.Errorf("rule 11 of step %d: expected metric 20, got %v",
+				step+1, m)
+		}
+	}
+}
+
+func TestPlanActionChangesErrors(t *testing.T) {
+	old := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 1, "action" : "permit", "continue" : 3 },
+				{ "tagnode" : 2, "action" : "permit" },
+				{ "tagnode" : 65535, "action" : "permit" }
+			]
+		}, {
+			"tagnode" : "RM2",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "permit" },
+				{ "tagnode" : 11, "action" : "permit" }
+			]
+		} ]
+	}`)
+	cfg := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 1, "action" : "deny" },
+				{ "tagnode" : 2, "action" : "deny" },
+				{ "tagnode" : 65535, "action" : "deny" }
+			]
+		}, {
+			"tagnode" : "RM2",
+			"rule" : [ { "tagnode" : 10, "action" : "deny" } ]
+		} ]
+	}`)
+
+	_, err := policy.PlanActionChanges(cfg, old)
+	checkErrors(t, "no free rule", err, []string{
+		"[policy route route-map RM rule 1 action]\n" +
+			"Cannot change action from permit to deny; rule 2 must be free",
+		"[policy route route-map RM rule 2 action]\n" +
+			"Cannot change action from permit to deny; rule 3 must be free",
+		"[policy route route-map RM rule 65535 action]\n" +
+			"Cannot change action from permit to deny; rule 65536 must be free",
+		"[policy route route-map RM2 rule 10 action]\n" +
+			"Cannot change action from permit to deny; rule 11 must be free",
+	})
+}
+
+func TestRouteMapsApplied(t *testing.T) {
+	cfg := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "permit" },
+				{ "tagnode" : 11, "action" : "deny" }
+			]
+		} ]
+	}`)
+
+	tests := []struct {
+		name    string
+		running string
+		applied bool
+	}{
+		{
+			name: "applied",
+			running: "route-map OTHER permit 5\n" +
+				"route-map RM permit 10\n match tag 1\n!\n" +
+				"route-map RM deny 11\n!\n",
+			applied: true,
+		},
+		{
+			name:    "old action",
+			running: "route-map RM permit 10\n!\nroute-map RM permit 11\n!\n",
+		},
+		{
+			name:    "rule missing",
+			running: "route-map RM permit 10\n!\n",
+		},
+		{
+			name: "extra rule",
+			running: "route-map RM permit 10\n!\nroute-map RM deny 11\n!\n" +
+				"route-map RM deny 12\n!\n",
+		},
+	}
+
+	for _, test := range tests {
+		applied := policy.RouteMapsApplied(test.running, cfg, []string{"RM"})
+		if applied != test.applied {
+			t.Errorf("%s: expected %v, got %v", test.name, test.applied,
+				applied)
+		}
+	}
+}
+
+/*
+ * A routing daemon which applies each configuration written, except
+ * those listed in stall, once its running config has been polled delay
+ * times, showing its rules as in its running config
+ */
+type fakeDaemon struct {
+	t       *testing.T
+	written []string
+	running string
+	pending string
+	polls   int
+	delay   int
+	stall   map[int]bool
+}
+
+func (d *fakeDaemon) runningConfig() string {
+	if d.polls++; d.polls > d.delay && d.pending != "" {
+		d.running, d.pending = d.pending, ""
+	}
+	return d.running
+}
+
+func (d *fakeDaemon) write(cfg []byte) error {
+	var cfg_map map[string]interface{}
+	if err := json.Unmarshal(cfg, &cfg_map); err != nil {
+		d.t.Fatal(err)
+	}
+	d.written = append(d.written, planRules(cfg_map))
+	if d.stall[len(d.written)] {
+		return nil
+	}
+
+	var lines []string
+	for _, rmap := range strings.Split(planRules(cfg_map), ", ") {
+		words := strings.Fields(rmap)
+		for _, rule := range words[1:] {
+			fields := strings.Split(rule, ":")
+			lines = append(lines, fmt.Sprintf("route-map %s %s %s\n!",
+				words[0], fields[1], fields[0]))
+		}
+	}
+	d.pending = strings.Join(lines, "\n")
+	d.polls = 0
+	return nil
+}
+
+func TestApplyActionChanges(t *testing.T) {
+	old := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "permit" },
+				{ "tagnode" : 20, "action" : "deny" }
+			]
+		} ]
+	}`)
+	cfg := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "deny" },
+				{ "tagnode" : 20, "action" : "deny" }
+			]
+		} ]
+	}`)
+
+	tests := []struct {
+		name    string
+		stall   []int
+		delay   int
+		timeout time.Duration
+		written []string
+		errors  []string
+	}{
+		{
+			name: "applied",
+			written: []string{
+				"RM 10:permit 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:deny 11:deny 20:deny",
+				"RM 10:deny 20:deny",
+			},
+		},
+		{
+			//Each configuration is applied within the timeout, but not
+			//the whole plan
+			name:    "timeout for plan",
+			delay:   2,
+			timeout: 250 * time.Millisecond,
+			written: []string{
+				"RM 10:permit 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:permit 11:deny 20:deny",
+				"RM 10:permit 20:deny",
+			},
+			errors: []string{
+				"Routing daemon did not apply route-maps RM within 250ms",
+			},
+		},
+		{
+			name:  "timeout with rule deleted",
+			stall: []int{2},
+			written: []string{
+				"RM 10:permit 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:permit 11:deny 20:deny",
+				"RM 10:permit 20:deny",
+			},
+			errors: []string{
+				"Routing daemon did not apply route-maps RM within 10ms",
+			},
+		},
+		{
+			name:  "timeout with rule re-created",
+			stall: []int{3},
+			written: []string{
+				"RM 10:permit 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:deny 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:permit 11:deny 20:deny",
+				"RM 10:permit 20:deny",
+			},
+			errors: []string{
+				"Routing daemon did not apply route-maps RM within 10ms",
+			},
+		},
+		{
+			name:  "timeout restoring",
+			stall: []int{3, 5},
+			written: []string{
+				"RM 10:permit 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:deny 11:deny 20:deny",
+				"RM 11:deny 20:deny",
+				"RM 10:permit 11:deny 20:deny",
+			},
+			errors: []string{
+				"Routing daemon did not apply route-maps RM within 10ms",
+				"Failed to restore previous route policy configuration: " +
+					"Routing daemon did not apply route-maps RM within 10ms",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		d := &fakeDaemon{t: t, delay: test.delay, stall: make(map[int]bool)}
+		for _, n := range test.stall {
+			d.stall[n] = true
+		}
+		if test.timeout == 0 {
+			test.timeout = 10 * time.Millisecond
+		}
+
+		err := policy.ApplyActionChanges(cfg, old, d.write,
+			d.runningConfig, test.timeout)
+		checkErrors(t, test.name, err, test.errors)
+		if !reflect.DeepEqual(d.written, test.written) {
+			t.Errorf("%s: expected configurations:\n%s\ngot:\n%s", test.name,
+				strings.Join(test.written, "\n"), strings.Join(d.written, "\n"))
+		}
+	}
+}
+
+func TestApplyActionChangesPlanError(t *testing.T) {
+	old := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [ { "tagnode" : 10, "action" : "permit" } ]
+		} ]
+	}`)
+	cfg := planConfig(t, `{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "deny" },
+				{ "tagnode" : 11, "action" : "deny" }
+			]
+		} ]
+	}`)
+
+	//Nothing is written rather than the route-map being discarded
+	d := &fakeDaemon{t: t}
+	err := policy.ApplyActionChanges(cfg, old, d.write, d.runningConfig,
+		10*time.Millisecond)
+	checkErrors(t, "plan error", err, []string{
+		"[policy route route-map RM rule 10 action]\n" +
+			"Cannot change action from permit to deny; rule 11 must be free",
+	})
+	if len(d.written) > 0 {
+		t.Errorf("Unexpected configurations written: %s", d.written)
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * Package policy validates the route policy configuration of the
+ * vyatta-policy-route-v1 model, in the internal JSON format passed to
+ * ProtocolsModelComponent check functions.
+ *
+ * A VCI component which consumes the policy configuration should
+ * register the check and set functions, and name the VCI models of the
+ * protocols which reference policy objects, with:
+ *
+ *     pmc.SetCheckFunction(policy.Check)
+ *     pmc.SetSetFunction(policy.Set)
+ *     policy.SetProtocolModels(<protocol model>...)
+ *
+ * No component in this repository does so yet, so the configd syntax and
+ * validate scripts of the model, which check communities, community-list
+ * types and AS path prepend strings, are kept until the components
+ * which consume the policy configuration register policy.Check.
+ *
+ * Problems which do not prevent a commit, such as a policy object which
+ * is not used, are returned by Warnings. VCI check functions cannot
+ * return warnings, so they are shown by the vyatta-policy-validate
+ * configd validate script of the model instead.
+ */
+package policy
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	multierr "github.com/hashicorp/go-multierror"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Config path of the route policy container */
+const POLICY_ROUTE_PATH = "policy route"
+
+/* VCI models of the protocols which reference policy objects */
+var protocolModels []string
+
+/*
+ * Sets the VCI models of the protocols which reference policy objects.
+ * The running configuration of each is searched for references by Check
+ * and by the get-policy-references RPC.
+ */
+func SetProtocolModels(models ...string) {
+	protocolModels = models
+}
+
+func pathError(path string, format string, a ...interface{}) error {
+	return protocols.AddErrorContext(fmt.Errorf(format, a...), path)
+}
+
+/*
+ * Returns the config path of the list entry with the given key under path
+ */
+func entryPath(path, list string, key interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %v", path, list, key))
+}
+
+/*
+ * Returns the route policy container of frontend_map, or nil if absent
+ */
+func policyRoute(frontend_map map[string]interface{}) map[string]interface{} {
+	policy_map, _ := frontend_map["policy"].(map[string]interface{})
+	route_map, _ := policy_map["route"].(map[string]interface{})
+	return route_map
+}
+
+/*
+ * Sorts tagnodes, numerically where both are numbers so that rules are
+ * visited in the order the routing daemon applies them
+ */
+func sortTagnodes(tagnodes []string) {
+	sort.Slice(tagnodes, func(i, j int) bool {
+		a, err_a := strconv.ParseUint(tagnodes[i], 10, 64)
+		b, err_b := strconv.ParseUint(tagnodes[j], 10, 64)
+		if err_a == nil && err_b == nil {
+			return a < b
+		}
+		return tagnodes[i] < tagnodes[j]
+	})
+}
+
+/*
+ * Calls fn with each entry of list key in pmap, keyed by its tagnode,
+ * in tagnode order
+ */
+func walkList(pmap map[string]interface{}, key string,
+	fn func(tagnode string, entry_map map[string]interface{})) {
+	arr, _ := pmap[key].([]interface{})
+
+	entries := make(map[string]map[string]interface{})
+	tagnodes := make([]string, 0, len(arr))
+	for _, entry := range arr {
+		entry_map, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tagnode := fmt.Sprint(entry_map["tagnode"])
+		entries[tagnode] = entry_map
+		tagnodes = append(tagnodes, tagnode)
+	}
+	sortTagnodes(tagnodes)
+
+	for _, tagnode := range tagnodes {
+		fn(tagnode, entries[tagnode])
+	}
+}
+
+/*
+ * Returns the entry of list key in pmap with the given tagnode, or nil
+ * if there is none
+ */
+func findEntry(pmap map[string]interface{}, key, tagnode string) map[string]interface{} {
+	arr, _ := pmap[key].([]interface{})
+	for _, entry := range arr {
+		entry_map, ok := entry.(map[string]interface{})
+		if ok && fmt.Sprint(entry_map["tagnode"]) == tagnode {
+			return entry_map
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns the value of a uint32 leaf decoded from JSON, and whether it
+ * is present
+ */
+func jsonUint(value interface{}) (uint32, bool) {
+	switch v := value.(type) {
+	case float64:
+		return uint32(v), true
+	case string:
+		n, err := strconv.ParseUint(v, 10, 32)
+		return uint32(n), err == nil
+	}
+	return 0, false
+}
+
+/*
+ * Validates the extended communities of each of the leaves keys of
+ * ext_map, found at path
+ */
+func validateExtCommunityLeaves(ext_map map[string]interface{},
+	path string, keys ...string) error {
+	ret_err := protocols.NewMultiError()
+
+	for _, key := range keys {
+		value, ok := ext_map[key].(string)
+		if !ok {
+			continue
+		}
+		err := ValidateExtCommunities(value)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" "+key, "%s", err))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the community, extcommunity and large-community lists of the
+ * route policy container route_map. A list may not be both standard and
+ * expanded, and the communities of standard list rules must be valid.
+ */
+func validateCommunityLists(route_map map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+
+	for _, list := range [...]string{"community-list", "extcommunity-list",
+		"large-community-list"} {
+		list_map, _ := route_map[list].(map[string]interface{})
+		list_path := POLICY_ROUTE_PATH + " " + list
+
+		expanded := make(map[string]bool)
+		walkList(list_map, "expanded",
+			func(tagnode string, _ map[string]interface{}) {
+				expanded[tagnode] = true
+			})
+
+		walkList(list_map, "standard",
+			func(tagnode string, std_map map[string]interface{}) {
+				std_path := entryPath(list_path, "standard", tagnode)
+				if expanded[tagnode] {
+					ret_err = multierr.Append(ret_err, pathError(std_path,
+						"Cannot configure %s %s as both standard and expanded",
+						list, tagnode))
+				}
+				walkList(std_map, "rule",
+					func(rule string, rule_map map[string]interface{}) {
+						rule_path := entryPath(std_path, "rule", rule)
+						if list == "extcommunity-list" {
+							ret_err = multierr.Append(ret_err,
+								validateExtCommunityLeaves(rule_map,
+									rule_path, "rt", "soo"))
+							return
+						}
+
+						community, ok := rule_map["community"].(string)
+						if !ok {
+							return
+						}
+						var err error
+						if list == "large-community-list" {
+							err = ValidateLargeCommunities(community, false)
+						} else {
+							err = ValidateCommunities(community, false)
+						}
+						if err != nil {
+							ret_err = multierr.Append(ret_err, pathError(
+								rule_path+" community", "%s", err))
+						}
+					})
+			})
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the set actions of a route-map rule
+ */
+func validateRouteMapSet(set_map map[string]interface{}, path string) error {
+	ret_err := protocols.NewMultiError()
+
+	if community, ok := set_map["community"].(string); ok {
+		err := ValidateCommunities(community, true)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" community", "%s", err))
+		}
+	}
+	if community, ok := set_map["add-community"].(string); ok {
+		err := ValidateCommunities(community, false)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" add-community", "%s", err))
+		}
+	}
+
+	if community, ok := set_map["large-community"].(string); ok {
+		err := ValidateLargeCommunities(community, true)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" large-community", "%s", err))
+		}
+	}
+	if community, ok := set_map["add-large-community"].(string); ok {
+		err := ValidateLargeCommunities(community, false)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" add-large-community", "%s", err))
+		}
+	}
+
+	if ext_map, ok := set_map["extcommunity"].(map[string]interface{}); ok {
+		ret_err = multierr.Append(ret_err, validateExtCommunityLeaves(ext_map,
+			path+" extcommunity", "rt", "soo"))
+	}
+	if ext_map, ok := set_map["add-extcommunity"].(map[string]interface{}); ok {
+		ret_err = multierr.Append(ret_err, validateExtCommunityLeaves(ext_map,
+			path+" add-extcommunity", "rt"))
+	}
+
+	if prepend, ok := set_map["as-path-prepend"].(string); ok {
+		err := ValidateAsPathPrepend(prepend)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				pathError(path+" as-path-prepend", "%s", err))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the route-maps of the route policy container route_map
+ */
+func validateRouteMaps(route_map map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+
+	walkList(route_map, "route-map",
+		func(name string, rmap_map map[string]interface{}) {
+			rmap_path := entryPath(POLICY_ROUTE_PATH, "route-map", name)
+			walkList(rmap_map, "rule",
+				func(rule string, rule_map map[string]interface{}) {
+					set_map, ok := rule_map["set"].(map[string]interface{})
+					if !ok {
+						return
+					}
+					set_path := entryPath(rmap_path, "rule", rule) + " set"
+					ret_err = multierr.Append(ret_err,
+						validateRouteMapSet(set_map, set_path))
+				})
+		})
+
+	return ret_err.ErrorOrNil()
+}
+
+/* Checks that each policy object referenced by a route-map exists */
+func validateReferences(frontend_map map[string]interface{},
+	protocol_maps []map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+	graph := NewPolicyGraph(frontend_map, protocol_maps...)
+
+	for _, ref := range graph.Dangling() {
+		if !strings.HasPrefix(ref.Path, POLICY_ROUTE_PATH+" ") {
+			continue
+		}
+		ret_err = multierr.Append(ret_err,
+			pathError(ref.Path, "%s does not exist", ref.Object))
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns a warning, prefixed by its config path, for each policy object
+ * of the route policy configuration in frontend_map which is not
+ * referenced by a route-map or by the protocol configurations
+ * protocol_maps
+ */
+func Warnings(frontend_map map[string]interface{},
+	protocol_maps ...map[string]interface{}) []string {
+	var warnings []string
+	graph := NewPolicyGraph(frontend_map, protocol_maps...)
+
+	for _, obj := range graph.Unused() {
+		warnings = append(warnings,
+			fmt.Sprintf("[%s]\n%s is not used", graph.Path(obj), obj))
+	}
+
+	return warnings
+}
+
+/*
+ * Performs semantic validation of the route policy configuration in
+ * frontend_map, given the configurations protocol_maps of the protocols
+ * which may reference it. frontend_map is not modified.
+ *
+ * Any problems found are aggregated into the returned error, each
+ * prefixed by the config path at which it was found. Warnings are
+ * returned by Warnings.
+ */
+func Validate(frontend_map map[string]interface{},
+	protocol_maps ...map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+	route_map := policyRoute(frontend_map)
+
+	ret_err = multierr.Append(ret_err, validateCommunityLists(route_map))
+	ret_err = multierr.Append(ret_err, validateRouteMaps(route_map))
+	ret_err = multierr.Append(ret_err,
+		validateReferences(frontend_map, protocol_maps))
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Checks that each change to the action of an existing route-map rule
+ * between the running configuration old_map and the candidate
+ * frontend_map can be made without the routing daemon discarding the
+ * whole route-map, as planned by PlanActionChanges
+ */
+func ValidateActionChanges(old_map, frontend_map map[string]interface{}) error {
+	_, err := PlanActionChanges(frontend_map, old_map)
+	return err
+}
+
+/*
+ * Checks that each policy object referenced by the protocol
+ * configurations protocol_maps exists in the candidate frontend_map,
+ * reporting a reference to an object of the running configuration
+ * old_map as its deletion. Where protocol_maps are running rather than
+ * candidate configurations, a reference must be removed in an earlier
+ * commit than the object it references.
+ */
+func ValidateInUse(old_map, frontend_map map[string]interface{},
+	protocol_maps ...map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+	old_graph := NewPolicyGraph(old_map)
+	graph := NewPolicyGraph(frontend_map, protocol_maps...)
+
+	for _, ref := range graph.Dangling() {
+		if strings.HasPrefix(ref.Path, POLICY_ROUTE_PATH+" ") {
+			continue
+		}
+		if !old_graph.Exists(ref.Object) {
+			ret_err = multierr.Append(ret_err,
+				pathError(ref.Path, "%s does not exist", ref.Object))
+			continue
+		}
+		ret_err = multierr.Append(ret_err,
+			pathError(old_graph.Path(ref.Object),
+				"Cannot delete %s; it is used by %s", ref.Object, ref.Path))
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the candidate route policy configuration contained in the
+ * internal JSON cfg against the running configuration old_cfg, which
+ * may be empty, and the internal JSON protocol_cfgs of the protocols
+ * which may reference it.
+ */
+func ValidateJson(cfg, old_cfg []byte, protocol_cfgs ...[]byte) error {
+	var frontend_map, old_map map[string]interface{}
+
+	err := json.Unmarshal(cfg, &frontend_map)
+	if err != nil {
+		return err
+	}
+	if len(old_cfg) > 0 {
+		err = json.Unmarshal(old_cfg, &old_map)
+		if err != nil {
+			return err
+		}
+	}
+
+	protocol_maps, err := unmarshalConfigs(protocol_cfgs)
+	if err != nil {
+		return err
+	}
+
+	ret_err := protocols.NewMultiError()
+	ret_err = multierr.Append(ret_err, Validate(frontend_map, protocol_maps...))
+	ret_err = multierr.Append(ret_err, ValidateActionChanges(old_map, frontend_map))
+	ret_err = multierr.Append(ret_err,
+		ValidateInUse(old_map, frontend_map, protocol_maps...))
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the decoded internal JSON configurations cfgs
+ */
+func unmarshalConfigs(cfgs [][]byte) ([]map[string]interface{}, error) {
+	cfg_maps := make([]map[string]interface{}, 0, len(cfgs))
+
+	for _, cfg := range cfgs {
+		var cfg_map map[string]interface{}
+		err := json.Unmarshal(cfg, &cfg_map)
+		if err != nil {
+			return nil, err
+		}
+		cfg_maps = append(cfg_maps, cfg_map)
+	}
+
+	return cfg_maps, nil
+}
+
+/*
+ * Returns the internal JSON of the running configuration of each of the
+ * protocol models set by SetProtocolModels
+ */
+func loadProtocolConfigs(pmc *protocols.ProtocolsModelComponent) ([][]byte, error) {
+	protocol_cfgs := make([][]byte, 0, len(protocolModels))
+
+	for _, model := range protocolModels {
+		protocol_cfg, err := pmc.GetModelConfig(model)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", model, err)
+		}
+		protocol_cfgs = append(protocol_cfgs, protocol_cfg)
+	}
+
+	return protocol_cfgs, nil
+}
+
+/*
+ * Validates the route policy configuration cfg, comparing it with the
+ * configuration last written to the daemon configuration file by Set and
+ * the internal JSON protocol_cfgs of the protocols which may reference
+ * it.
+ *
+ * A component which also receives the candidate configurations of the
+ * protocols, such as one implementing their models as well, should call
+ * this from its check function with them, so that a reference and the
+ * policy object it references may be removed in the same commit.
+ */
+func CheckWithProtocols(pmc *protocols.ProtocolsModelComponent, cfg []byte,
+	protocol_cfgs ...[]byte) error {
+	old_cfg, err := ioutil.ReadFile(pmc.GetDaemonConfigFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Warningln("Not checking route-map action changes: " + err.Error())
+		old_cfg = nil
+	}
+
+	return ValidateJson(cfg, old_cfg, protocol_cfgs...)
+}
+
+/*
+ * ProtocolsModelComponent check function validating the route policy
+ * configuration by CheckWithProtocols, against the running configuration
+ * of the protocol models
+ */
+func Check(pmc *protocols.ProtocolsModelComponent, cfg []byte) error {
+	protocol_cfgs, err := loadProtocolConfigs(pmc)
+	if err != nil {
+		log.Warningln("Not checking protocol references: " + err.Error())
+		protocol_cfgs = nil
+	}
+
+	return CheckWithProtocols(pmc, cfg, protocol_cfgs...)
+}
@@ -0,0 +1,393 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"eng.vyatta.net/protocols/policy"
+	"strings"
+	"testing"
+)
+
+func policyConfig(route string) []byte {
+	return []byte(`{ "policy" : { "route" : ` + route + ` } }`)
+}
+
+func checkErrors(t *testing.T, name string, err error, errors []string) {
+	t.Helper()
+
+	if len(errors) == 0 {
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Errorf("%s: expected error", name)
+		return
+	}
+	for _, expected := range errors {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: error does not contain %q:\n%s", name, expected, err)
+		}
+	}
+	if n := strings.Count(err.Error(), "\n\n") + 1; n != len(errors) {
+		t.Errorf("%s: expected %d errors, got %d:\n%s", name, len(errors), n, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		errors []string
+	}{
+		{
+			name: "valid",
+			route: `{
+				"community-list" : {
+					"standard" : [ {
+						"tagnode" : 1,
+						"rule" : [ { "tagnode" : 10, "action" : "permit",
+							"community" : "100:1 no-export" } ]
+					} ],
+					"expanded" : [ { "tagnode" : 100 } ]
+				},
+				"extcommunity-list" : {
+					"standard" : [ {
+						"tagnode" : 1,
+						"rule" : [ { "tagnode" : 10, "action" : "permit",
+							"rt" : "1.10:100", "soo" : "10.0.0.1:1" } ]
+					} ]
+				},
+				"large-community-list" : {
+					"standard" : [ {
+						"tagnode" : "LCL",
+						"rule" : [ { "tagnode" : 10, "action" : "permit",
+							"community" : "4200000000:1:2 65536:0:4294967295" } ]
+					} ],
+					"expanded" : [ { "tagnode" : 100 } ]
+				},
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [ {
+						"tagnode" : 10,
+						"action" : "permit",
+						"set" : {
+							"community" : "none",
+							"large-community" : "none",
+							"as-path-prepend" : "100 70000 1.10"
+						}
+					}, {
+						"tagnode" : 20,
+						"action" : "permit",
+						"set" : { "add-large-community" : "70000:1:1" }
+					} ]
+				} ]
+			}`,
+		},
+		{
+			name: "asdot as-path-prepend",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [ {
+						"tagnode" : 10,
+						"action" : "permit",
+						"set" : { "as-path-prepend" : "1.10 65000" }
+					} ]
+				} ]
+			}`,
+		},
+		{
+			name: "community-list both standard and expanded",
+			route: `{
+				"community-list" : {
+					"standard" : [ { "tagnode" : "CL" } ],
+					"expanded" : [ { "tagnode" : "CL" } ]
+				},
+				"extcommunity-list" : {
+					"standard" : [ { "tagnode" : "XL" } ],
+					"expanded" : [ { "tagnode" : "XL" } ]
+				},
+				"large-community-list" : {
+					"standard" : [ { "tagnode" : "LL" } ],
+					"expanded" : [ { "tagnode" : "LL" } ]
+				}
+			}`,
+			errors: []string{
+				"[policy route large-community-list standard LL]\n" +
+					"Cannot configure large-community-list LL as both standard and expanded",
+				"[policy route community-list standard CL]\n" +
+					"Cannot configure community-list CL as both standard and expanded",
+				"[policy route extcommunity-list standard XL]\n" +
+					"Cannot configure extcommunity-list XL as both standard and expanded",
+			},
+		},
+		{
+			name: "bad community-list community",
+			route: `{
+				"community-list" : {
+					"standard" : [ {
+						"tagnode" : 1,
+						"rule" : [ { "tagnode" : 10, "action" : "permit",
+							"community" : "none" } ]
+					} ]
+				}
+			}`,
+			errors: []string{
+				"[policy route community-list standard 1 rule 10 community]\n" +
+					"none unknown community value",
+			},
+		},
+		{
+			name: "bad large-community-list community",
+			route: `{
+				"large-community-list" : {
+					"standard" : [ {
+						"tagnode" : 1,
+						"rule" : [
+							{ "tagnode" : 10, "action" : "permit",
+								"community" : "100:1" },
+							{ "tagnode" : 20, "action" : "permit",
+								"community" : "none" }
+						]
+					} ]
+				}
+			}`,
+			errors: []string{
+				"[policy route large-community-list standard 1 rule 10 community]\n" +
+					"100:1 is not a large community in ASN:X:Y format",
+				"[policy route large-community-list standard 1 rule 20 community]\n" +
+					"none is not a large community in ASN:X:Y format",
+			},
+		},
+		{
+			name: "bad route-map set",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [ {
+						"tagnode" : 10,
+						"action" : "permit",
+						"set" : {
+							"community" : "none 1:1",
+							"large-community" : "1:1:1 none",
+							"as-path-prepend" : "100 0"
+						}
+					}, {
+						"tagnode" : 20,
+						"action" : "deny",
+						"set" : {
+							"add-community" : "65535:1",
+							"extcommunity" : {
+								"rt" : "70000:70000",
+								"soo" : "70000:100"
+							},
+							"add-extcommunity" : { "rt" : "1" },
+							"add-large-community" : "1.10:1:1"
+						}
+					} ]
+				} ]
+			}`,
+			errors: []string{
+				"[policy route route-map RM rule 10 set community]\n" +
+					"cannot configure 'none' with other attributes",
+				"[policy route route-map RM rule 10 set as-path-prepend]\n" +
+					"invalid AS path string: AS 0 is reserved",
+				"[policy route route-map RM rule 20 set add-community]\n" +
+					"65535:1: AS 65535 is reserved for well-known communities",
+				"[policy route route-map RM rule 20 set extcommunity rt]\n" +
+					"70000:70000: value must be between 0 and 65535 with a 4-byte AS",
+				"[policy route route-map RM rule 20 set add-extcommunity rt]\n" +
+					"1 is not an extended community in AA:NN or IPAddr:NN format",
+				"[policy route route-map RM rule 10 set large-community]\n" +
+					"cannot configure 'none' with other attributes",
+				"[policy route route-map RM rule 20 set add-large-community]\n" +
+					"1.10:1:1: AS 1.10 must be in asplain notation",
+			},
+		},
+		{
+			name: "dangling references",
+			route: `{
+				"prefix-list" : [ { "tagnode" : "PL" } ],
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [ {
+						"tagnode" : 10,
+						"action" : "permit",
+						"match" : {
+							"ip" : { "address" : { "prefix-list" : "PL" } },
+							"ipv6" : { "address" : { "prefix-list" : "PL" } },
+							"community" : { "community-list" : 5 }
+						}
+					} ]
+				} ]
+			}`,
+			errors: []string{
+				"[policy route route-map RM rule 10 match community community-list]\n" +
+					"community-list 5 does not exist",
+				"[policy route route-map RM rule 10 match ipv6 address prefix-list]\n" +
+					"prefix-list6 PL does not exist",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		checkErrors(t, test.name,
+			policy.ValidateJson(policyConfig(test.route), nil), test.errors)
+	}
+}
+
+func TestValidateActionChanges(t *testing.T) {
+	old := policyConfig(`{
+		"route-map" : [ {
+			"tagnode" : "RM",
+			"rule" : [
+				{ "tagnode" : 10, "action" : "permit" },
+				{ "tagnode" : 20, "action" : "deny" }
+			]
+		} ]
+	}`)
+
+	tests := []struct {
+		name   string
+		route  string
+		errors []string
+	}{
+		{
+			name: "unchanged with added rule and route-map",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [
+						{ "tagnode" : 10, "action" : "permit" },
+						{ "tagnode" : 20, "action" : "deny" },
+						{ "tagnode" : 30, "action" : "deny" }
+					]
+				}, {
+					"tagnode" : "RM2",
+					"rule" : [ { "tagnode" : 10, "action" : "deny" } ]
+				} ]
+			}`,
+		},
+		{
+			name: "rule deleted",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [ { "tagnode" : 10, "action" : "permit" } ]
+				} ]
+			}`,
+		},
+		{
+			name: "action changed",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [
+						{ "tagnode" : 10, "action" : "deny" },
+						{ "tagnode" : 20, "action" : "deny" }
+					]
+				} ]
+			}`,
+		},
+		{
+			name: "action changed without a free rule",
+			route: `{
+				"route-map" : [ {
+					"tagnode" : "RM",
+					"rule" : [
+						{ "tagnode" : 10, "action" : "deny" },
+						{ "tagnode" : 11, "action" : "deny" },
+						{ "tagnode" : 20, "action" : "permit", "continue" : 21 }
+					]
+				} ]
+			}`,
+			errors: []string{
+				"[policy route route-map RM rule 10 action]\n" +
+					"Cannot change action from permit to deny; rule 11 must be free",
+				"[policy route route-map RM rule 20 action]\n" +
+					"Cannot change action from deny to permit; rule 21 must be free",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		checkErrors(t, test.name,
+			policy.ValidateJson(policyConfig(test.route), old), test.errors)
+	}
+}
+
+func TestValidateInUse(t *testing.T) {
+	old := policyConfig(`{
+		"prefix-list" : [ { "tagnode" : "PL" } ],
+		"route-map" : [ { "tagnode" : "RM" }, { "tagnode" : "SPARE" } ]
+	}`)
+	bgp := []byte(`{ "protocols" : { "bgp" : [ {
+		"tagnode" : 100,
+		"neighbor" : [ {
+			"tagnode" : "192.0.2.1",
+			"address-family" : {
+				"ipv4-unicast" : {
+					"route-map" : { "import" : "RM" },
+					"prefix-list" : { "export" : "PL" },
+					"filter-list" : { "export" : "MISSING" }
+				}
+			}
+		} ]
+	} ] } }`)
+
+	missing := "[protocols bgp 100 neighbor 192.0.2.1 address-family " +
+		"ipv4-unicast filter-list export]\n" +
+		"as-path-list MISSING does not exist"
+
+	//The candidate configuration of bgp, with the neighbor removed
+	bgp_candidate := []byte(`{ "protocols" : { "bgp" : [ { "tagnode" : 100 } ] } }`)
+
+	tests := []struct {
+		name   string
+		route  string
+		bgp    []byte
+		errors []string
+	}{
+		{
+			name: "unused route-map deleted",
+			route: `{
+				"prefix-list" : [ { "tagnode" : "PL" } ],
+				"route-map" : [ { "tagnode" : "RM" } ]
+			}`,
+			errors: []string{missing},
+		},
+		{
+			name:  "used route-map and prefix-list deleted",
+			route: `{ "route-map" : [ { "tagnode" : "SPARE" } ] }`,
+			errors: []string{
+				missing,
+				"[policy route prefix-list PL]\n" +
+					"Cannot delete prefix-list PL; it is used by protocols bgp 100 " +
+					"neighbor 192.0.2.1 address-family ipv4-unicast prefix-list export",
+				"[policy route route-map RM]\n" +
+					"Cannot delete route-map RM; it is used by protocols bgp 100 " +
+					"neighbor 192.0.2.1 address-family ipv4-unicast route-map import",
+			},
+		},
+		{
+			name:  "route-map and prefix-list deleted with their references",
+			route: `{ "route-map" : [ { "tagnode" : "SPARE" } ] }`,
+			bgp:   bgp_candidate,
+		},
+	}
+
+	for _, test := range tests {
+		protocol_cfg := bgp
+		if test.bgp != nil {
+			protocol_cfg = test.bgp
+		}
+		checkErrors(t, test.name,
+			policy.ValidateJson(policyConfig(test.route), old, protocol_cfg),
+			test.errors)
+	}
+}
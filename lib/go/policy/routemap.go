@@ -0,0 +1,555 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * The attributes of a route to be evaluated by a route-map. Communities
+ * are in AA:NN or well-known form, extended communities in RT:AA:NN or
+ * SoO:AA:NN form and large communities in ASN:X:Y form. The AS path is
+ * space separated, with the most recently added AS first.
+ */
+type Route struct {
+	Prefix           string   `rfc7951:"prefix"`
+	NextHop          string   `rfc7951:"next-hop,omitempty"`
+	Communities      []string `rfc7951:"community,omitempty"`
+	ExtCommunities   []string `rfc7951:"extcommunity,omitempty"`
+	LargeCommunities []string `rfc7951:"large-community,omitempty"`
+	AsPath           string   `rfc7951:"as-path,omitempty"`
+	Origin           string   `rfc7951:"origin,omitempty"`
+	Tag              uint32   `rfc7951:"tag,omitempty"`
+	Metric           uint32   `rfc7951:"metric,omitempty"`
+	LocalPreference  uint32   `rfc7951:"local-preference,omitempty"`
+	Weight           uint32   `rfc7951:"weight,omitempty"`
+	SourceProtocol   string   `rfc7951:"source-protocol,omitempty"`
+}
+
+func (r Route) copy() Route {
+	r.Communities = append([]string(nil), r.Communities...)
+	r.ExtCommunities = append([]string(nil), r.ExtCommunities...)
+	r.LargeCommunities = append([]string(nil), r.LargeCommunities...)
+	return r
+}
+
+/*
+ * How a single route-map rule was applied to the route. A rule which
+ * did not match gives the reason; one which did lists the set actions
+ * applied, and any rule it continued to.
+ */
+type RuleTrace struct {
+	Rule     uint32   `rfc7951:"rule"`
+	Matched  bool     `rfc7951:"matched"`
+	Action   string   `rfc7951:"action"`
+	Reason   string   `rfc7951:"reason,omitempty"`
+	Set      []string `rfc7951:"set,omitempty"`
+	Continue uint32   `rfc7951:"continue,omitempty"`
+}
+
+/*
+ * The result of evaluating a route-map: whether the route is permitted,
+ * its attributes after the set actions of each matching permit rule, and
+ * the rules which were evaluated in order.
+ */
+type RouteMapResult struct {
+	Result string      `rfc7951:"result"`
+	Route  Route       `rfc7951:"route"`
+	Rules  []RuleTrace `rfc7951:"rule,omitempty"`
+}
+
+/* The parsed route being evaluated, with its prefix and next-hop */
+type routeEval struct {
+	route    Route
+	prefix   *net.IPNet
+	next_hop net.IP
+}
+
+func (e *routeEval) isIPv4() bool {
+	return e.prefix.IP.To4() != nil
+}
+
+/*
+ * Matches the access-list or prefix-list of the address or nexthop
+ * container addr_map of the ip or ipv6 match container
+ */
+func (e *routeEval) matchAddress(route_map, addr_map map[string]interface{},
+	family, what string, prefix *net.IPNet) (bool, string) {
+	var result FilterMatch
+	var clause string
+
+	if name, ok := addr_map["prefix-list"]; ok {
+		list := "prefix-list"
+		if family == "ipv6" {
+			list = "prefix-list6"
+		}
+		clause = fmt.Sprintf("%s %s %s %v", family, what, list, name)
+		result = matchPrefixList(route_map, list, fmt.Sprint(name), prefix)
+	} else if name, ok := addr_map["access-list"]; ok {
+		clause = fmt.Sprintf("%s %s access-list %v", family, what, name)
+		if family == "ipv6" {
+			result = matchAccessList6(route_map, fmt.Sprint(name), prefix)
+		} else {
+			result = matchAccessListPrefix(route_map, fmt.Sprint(name), prefix)
+		}
+	} else {
+		return true, ""
+	}
+
+	if result.Action != PERMIT {
+		return false, fmt.Sprintf("match %s: %s", clause, result)
+	}
+	return true, ""
+}
+
+/*
+ * Evaluates the ip or ipv6 match container ip_map
+ */
+func (e *routeEval) matchIp(route_map, ip_map map[string]interface{},
+	family string) (bool, string) {
+	if (family == "ip") != e.isIPv4() {
+		version := "IPv4"
+		if family == "ipv6" {
+			version = "IPv6"
+		}
+		return false, fmt.Sprintf("match %s: route is not an %s route",
+			family, version)
+	}
+
+	if addr_map, ok := ip_map["address"].(map[string]interface{}); ok {
+		if ok, reason := e.matchAddress(route_map, addr_map, family,
+			"address", e.prefix); !ok {
+			return false, reason
+		}
+	}
+
+	if addr_map, ok := ip_map["nexthop"].(map[string]interface{}); ok {
+		if e.next_hop == nil {
+			return false, fmt.Sprintf("match %s nexthop: route has no next-hop",
+				family)
+		}
+		bits := 8 * len(e.next_hop)
+		host := &net.IPNet{IP: e.next_hop, Mask: net.CIDRMask(bits, bits)}
+		if ok, reason := e.matchAddress(route_map, addr_map, family,
+			"nexthop", host); !ok {
+			return false, reason
+		}
+	}
+
+	if _, ok := ip_map["peer"]; ok {
+		return false, fmt.Sprintf("match %s peer: the route has no peer", family)
+	}
+
+	if proto, ok := ip_map["source-protocol"].(string); ok &&
+		proto != e.route.SourceProtocol {
+		return false, fmt.Sprintf("match %s source-protocol %s: route is from %s",
+			family, proto, orNone(e.route.SourceProtocol))
+	}
+
+	return true, ""
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+/*
+ * Evaluates the community, extcommunity or large-community match
+ * container comm_map, whose list leaf is of type list
+ */
+func (e *routeEval) matchCommunity(route_map, comm_map map[string]interface{},
+	match, list string, communities []string) (bool, string) {
+	name, ok := comm_map[list]
+	if !ok {
+		return true, ""
+	}
+
+	how := COMMUNITY_MATCH_ALL
+	if _, ok := comm_map["exact-match"]; ok {
+		how = COMMUNITY_MATCH_EXACT
+	}
+
+	result, err := matchCommunityList(route_map, list, fmt.Sprint(name),
+		communities, how)
+	if err != nil {
+		return false, fmt.Sprintf("match %s %s %v: %s", match, list, name, err)
+	}
+	if result.Action != PERMIT {
+		return false, fmt.Sprintf("match %s %s %v: %s", match, list, name,
+			result)
+	}
+	return true, ""
+}
+
+/*
+ * Evaluates the match container match_map of a route-map rule. All of
+ * the match conditions must be met; the reason the first one which is
+ * not met is returned.
+ */
+func (e *routeEval) match(route_map, match_map map[string]interface{}) (bool, string) {
+	if tag, ok := jsonUint(match_map["tag"]); ok && tag != e.route.Tag {
+		return false, fmt.Sprintf("match tag %d: route has tag %d",
+			tag, e.route.Tag)
+	}
+	if metric, ok := jsonUint(match_map["metric"]); ok && metric != e.route.Metric {
+		return false, fmt.Sprintf("match metric %d: route has metric %d",
+			metric, e.route.Metric)
+	}
+	if origin, ok := match_map["origin"].(string); ok && origin != e.route.Origin {
+		return false, fmt.Sprintf("match origin %s: route has origin %s",
+			origin, orNone(e.route.Origin))
+	}
+	if _, ok := match_map["interface"]; ok {
+		return false, "match interface: the route has no interface"
+	}
+
+	for _, family := range [...]string{"ip", "ipv6"} {
+		if ip_map, ok := match_map[family].(map[string]interface{}); ok {
+			if ok, reason := e.matchIp(route_map, ip_map, family); !ok {
+				return false, reason
+			}
+		}
+	}
+
+	communities := []struct {
+		match, list string
+		values      []string
+	}{
+		{"community", "community-list", e.route.Communities},
+		{"extcommunity", "extcommunity-list", e.route.ExtCommunities},
+		{"large-community", "large-community-list", e.route.LargeCommunities},
+	}
+	for _, c := range communities {
+		if comm_map, ok := match_map[c.match].(map[string]interface{}); ok {
+			if ok, reason := e.matchCommunity(route_map, comm_map,
+				c.match, c.list, c.values); !ok {
+				return false, reason
+			}
+		}
+	}
+
+	if name, ok := match_map["as-path"]; ok {
+		result, err := matchAsPathList(route_map, fmt.Sprint(name),
+			e.route.AsPath)
+		if err != nil {
+			return false, fmt.Sprintf("match as-path %v: %s", name, err)
+		}
+		if result.Action != PERMIT {
+			return false, fmt.Sprintf("match as-path %v: %s", name, result)
+		}
+	}
+
+	return true, ""
+}
+
+/*
+ * Returns communities with those in value added, without duplicates
+ */
+func addCommunities(list string, communities []string, value string) []string {
+	have := make(map[string]bool)
+	for _, community := range communities {
+		have[normalizeCommunity(list, community)] = true
+	}
+	for _, community := range strings.Fields(value) {
+		community = normalizeCommunity(list, community)
+		if !have[community] {
+			communities = append(communities, community)
+			have[community] = true
+		}
+	}
+	return communities
+}
+
+/*
+ * Returns communities without those permitted by community-list name
+ */
+func deleteCommunities(route_map map[string]interface{}, list, name string,
+	communities []string) []string {
+	var kept []string
+	for _, community := range communities {
+		result, _ := matchCommunityList(route_map, list, name,
+			[]string{community}, COMMUNITY_MATCH_ANY)
+		if result.Action != PERMIT {
+			kept = append(kept, community)
+		}
+	}
+	return kept
+}
+
+/*
+ * Returns metric after applying the set metric value, which is either
+ * absolute or relative when prefixed by + or -
+ */
+func setMetric(metric uint32, value string) uint32 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return metric
+	}
+	if value[0] == '+' || value[0] == '-' {
+		n += int64(metric)
+	}
+	if n < 0 {
+		return 0
+	}
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+/*
+ * Applies the set container set_map of a matching permit rule to the
+ * route, returning a description of each action in the order applied.
+ * Actions on attributes which the route does not have are listed as not
+ * applied.
+ */
+func (e *routeEval) set(route_map, set_map map[string]interface{}) []string {
+	var applied []string
+	r := &e.route
+	done := make(map[string]bool)
+
+	apply := func(key string, fn func(value interface{}) string) {
+		value, ok := set_map[key]
+		if !ok {
+			return
+		}
+		done[key] = true
+		if desc := fn(value); desc != "" {
+			applied = append(applied, key+" "+desc)
+		}
+	}
+
+	apply("tag", func(v interface{}) string {
+		r.Tag, _ = jsonUint(v)
+		return fmt.Sprint(r.Tag)
+	})
+	apply("local-preference", func(v interface{}) string {
+		r.LocalPreference, _ = jsonUint(v)
+		return fmt.Sprint(r.LocalPreference)
+	})
+	apply("weight", func(v interface{}) string {
+		r.Weight, _ = jsonUint(v)
+		return fmt.Sprint(r.Weight)
+	})
+	apply("origin", func(v interface{}) string {
+		r.Origin = fmt.Sprint(v)
+		return r.Origin
+	})
+	apply("metric", func(v interface{}) string {
+		r.Metric = setMetric(r.Metric, fmt.Sprint(v))
+		return fmt.Sprintf("%v: metric is %d", v, r.Metric)
+	})
+	apply("ip-next-hop", func(v interface{}) string {
+		if !e.isIPv4() {
+			return fmt.Sprintf("%v: not applied to an IPv6 route", v)
+		}
+		r.NextHop = fmt.Sprint(v)
+		return r.NextHop
+	})
+	apply("ipv6-next-hop", func(v interface{}) string {
+		nh_map, _ := v.(map[string]interface{})
+		global, ok := nh_map["global"].(string)
+		switch {
+		case e.isIPv4():
+			return "not applied to an IPv4 route"
+		case !ok:
+			return "local: the route has no link-local next-hop"
+		}
+		r.NextHop = global
+		return "global " + global
+	})
+
+	apply("community", func(v interface{}) string {
+		if value := fmt.Sprint(v); value != "none" {
+			r.Communities = addCommunities("community-list", nil, value)
+		} else {
+			r.Communities = nil
+		}
+		return fmt.Sprint(v)
+	})
+	apply("add-community", func(v interface{}) string {
+		r.Communities = addCommunities("community-list", r.Communities,
+			fmt.Sprint(v))
+		return fmt.Sprint(v)
+	})
+	apply("delete-community", func(v interface{}) string {
+		r.Communities = deleteCommunities(route_map, "community-list",
+			fmt.Sprint(v), r.Communities)
+		return fmt.Sprint(v)
+	})
+
+	extcommunity := func(v interface{}, communities []string) ([]string, string) {
+		ext_map, _ := v.(map[string]interface{})
+		var desc []string
+		for _, key := range [...]string{"rt", "soo"} {
+			value, ok := ext_map[key].(string)
+			if !ok {
+				continue
+			}
+			for _, community := range strings.Fields(value) {
+				communities = addCommunities("extcommunity-list",
+					communities, key+":"+community)
+			}
+			desc = append(desc, key+" "+value)
+		}
+		return communities, strings.Join(desc, " ")
+	}
+	apply("extcommunity", func(v interface{}) string {
+		var desc string
+		r.ExtCommunities, desc = extcommunity(v, nil)
+		return desc
+	})
+	apply("add-extcommunity", func(v interface{}) string {
+		var desc string
+		r.ExtCommunities, desc = extcommunity(v, r.ExtCommunities)
+		return desc
+	})
+	apply("delete-extcommunity", func(v interface{}) string {
+		r.ExtCommunities = deleteCommunities(route_map, "extcommunity-list",
+			fmt.Sprint(v), r.ExtCommunities)
+		return fmt.Sprint(v)
+	})
+
+	apply("large-community", func(v interface{}) string {
+		if value := fmt.Sprint(v); value != "none" {
+			r.LargeCommunities = addCommunities("large-community-list",
+				nil, value)
+		} else {
+			r.LargeCommunities = nil
+		}
+		return fmt.Sprint(v)
+	})
+	apply("add-large-community", func(v interface{}) string {
+		r.LargeCommunities = addCommunities("large-community-list",
+			r.LargeCommunities, fmt.Sprint(v))
+		return fmt.Sprint(v)
+	})
+	apply("delete-large-community", func(v interface{}) string {
+		r.LargeCommunities = deleteCommunities(route_map,
+			"large-community-list", fmt.Sprint(v), r.LargeCommunities)
+		return fmt.Sprint(v)
+	})
+
+	apply("as-path-prepend", func(v interface{}) string {
+		r.AsPath = strings.TrimSpace(fmt.Sprint(v) + " " + r.AsPath)
+		return fmt.Sprint(v)
+	})
+	apply("prepend-as", func(v interface{}) string {
+		prepend_map, _ := v.(map[string]interface{})
+		if _, ok := prepend_map["own-as"]; ok {
+			return "own-as: not applied, as the local AS is not known"
+		}
+		count, ok := jsonUint(prepend_map["last-as"])
+		if !ok {
+			return ""
+		}
+		path := strings.Fields(r.AsPath)
+		if len(path) == 0 {
+			return fmt.Sprintf("last-as %d: not applied to an empty AS path",
+				count)
+		}
+		for i := uint32(0); i < count; i++ {
+			path = append([]string{path[0]}, path...)
+		}
+		r.AsPath = strings.Join(path, " ")
+		return fmt.Sprintf("last-as %d", count)
+	})
+
+	//Attributes which are not part of the route being evaluated
+	keys := make([]string, 0)
+	for key, _ := range set_map {
+		if !done[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		applied = append(applied, key+": not applied to the test route")
+	}
+
+	return applied
+}
+
+/*
+ * Evaluates route-map name of the route policy configuration frontend_map
+ * against route, as the routing daemon would.
+ *
+ * The rules are evaluated in order until one matches. A matching deny
+ * rule denies the route. A matching permit rule applies its set actions
+ * and permits the route, unless it continues to a later rule, in which
+ * case evaluation resumes from there; the route remains permitted if no
+ * later rule matches. A route matched by no rule is denied.
+ *
+ * Match conditions which depend on the peer or interface a route was
+ * received on never match, as the route has neither.
+ */
+func EvaluateRouteMap(frontend_map map[string]interface{}, name string,
+	route Route) (*RouteMapResult, error) {
+	route_map := policyRoute(frontend_map)
+	rmap_map := findEntry(route_map, "route-map", name)
+	if rmap_map == nil {
+		return nil, fmt.Errorf("route-map %s does not exist", name)
+	}
+
+	_, prefix, err := net.ParseCIDR(route.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid prefix", route.Prefix)
+	}
+	e := &routeEval{route: route.copy(), prefix: prefix}
+	if route.NextHop != "" {
+		e.next_hop = net.ParseIP(route.NextHop)
+		if e.next_hop == nil {
+			return nil, fmt.Errorf("%s is not a valid next-hop", route.NextHop)
+		}
+		if ip4 := e.next_hop.To4(); ip4 != nil {
+			e.next_hop = ip4
+		}
+	}
+	e.route.Prefix = prefix.String()
+
+	result := &RouteMapResult{Result: DENY}
+	var next uint32
+	walkList(rmap_map, "rule",
+		func(tagnode string, rule_map map[string]interface{}) {
+			rule, _ := jsonUint(rule_map["tagnode"])
+			if rule < next || next == math.MaxUint32 {
+				return
+			}
+
+			trace := RuleTrace{Rule: rule}
+			trace.Action, _ = rule_map["action"].(string)
+			match_map, _ := rule_map["match"].(map[string]interface{})
+			trace.Matched, trace.Reason = e.match(route_map, match_map)
+			if !trace.Matched {
+				result.Rules = append(result.Rules, trace)
+				return
+			}
+
+			result.Result = trace.Action
+			next = math.MaxUint32
+			if trace.Action == PERMIT {
+				set_map, _ := rule_map["set"].(map[string]interface{})
+				trace.Set = e.set(route_map, set_map)
+				if cont, ok := jsonUint(rule_map["continue"]); ok {
+					trace.Continue = cont
+					next = cont
+				}
+			}
+			result.Rules = append(result.Rules, trace)
+		})
+
+	result.Route = e.route
+	return result, nil
+}
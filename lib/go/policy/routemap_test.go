@@ -0,0 +1,381 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy_test
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols/policy"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `{
+	"prefix-list" : [ {
+		"tagnode" : "PL",
+		"rule" : [
+			{ "tagnode" : 10, "action" : "permit",
+				"prefix" : "10.0.0.0/8", "le" : 24 },
+			{ "tagnode" : 20, "action" : "deny",
+				"prefix" : "0.0.0.0/0", "le" : 32 }
+		]
+	} ],
+	"prefix-list6" : [ {
+		"tagnode" : "PL6",
+		"rule" : [ { "tagnode" : 10, "action" : "permit",
+			"prefix" : "2001:db8::/32", "ge" : 48, "le" : 64 } ]
+	} ],
+	"access-list" : [ {
+		"tagnode" : 10,
+		"rule" : [
+			{ "tagnode" : 10, "action" : "deny",
+				"source" : { "host" : "10.1.1.0" } },
+			{ "tagnode" : 20, "action" : "permit",
+				"source" : { "network" : "10.0.0.0",
+					"inverse-mask" : "0.255.255.255" } }
+		]
+	}, {
+		"tagnode" : 100,
+		"rule" : [ { "tagnode" : 10, "action" : "permit",
+			"source" : { "any" : null },
+			"destination" : { "host" : "255.255.255.0" } } ]
+	} ],
+	"community-list" : {
+		"standard" : [ {
+			"tagnode" : "CL",
+			"rule" : [ { "tagnode" : 10, "action" : "permit",
+				"community" : "100:1 no-export" } ]
+		} ],
+		"expanded" : [ {
+			"tagnode" : 100,
+			"rule" : [ { "tagnode" : 10, "action" : "permit",
+				"regex" : "^65000:" } ]
+		} ]
+	},
+	"large-community-list" : {
+		"standard" : [ {
+			"tagnode" : "LL",
+			"rule" : [ { "tagnode" : 10, "action" : "permit",
+				"community" : "65536:1:1" } ]
+		} ]
+	},
+	"as-path-list" : [ {
+		"tagnode" : "AP",
+		"rule" : [
+			{ "tagnode" : 10, "action" : "deny", "regex" : "_666_" },
+			{ "tagnode" : 20, "action" : "permit", "regex" : "^100_" }
+		]
+	} ],
+	"route-map" : [ {
+		"tagnode" : "RM",
+		"rule" : [ {
+			"tagnode" : 10,
+			"action" : "permit",
+			"match" : {
+				"tag" : 5,
+				"ip" : { "address" : { "prefix-list" : "PL" } }
+			},
+			"set" : { "local-preference" : 200, "add-community" : "100:2" },
+			"continue" : 30
+		}, {
+			"tagnode" : 20,
+			"action" : "deny",
+			"match" : { "origin" : "egp" }
+		}, {
+			"tagnode" : 30,
+			"action" : "permit",
+			"match" : { "as-path" : "AP" },
+			"set" : { "as-path-prepend" : "65000 65000", "metric" : "+10" }
+		}, {
+			"tagnode" : 40,
+			"action" : "permit",
+			"match" : { "community" : { "community-list" : "CL" } },
+			"set" : { "community" : "none" }
+		}, {
+			"tagnode" : 50,
+			"action" : "deny"
+		} ]
+	}, {
+		"tagnode" : "ACL",
+		"rule" : [ {
+			"tagnode" : 10,
+			"action" : "permit",
+			"match" : { "ip" : { "address" : { "access-list" : 10 } } }
+		}, {
+			"tagnode" : 20,
+			"action" : "permit",
+			"match" : { "ip" : { "address" : { "access-list" : 100 } } },
+			"set" : { "tag" : 100 }
+		} ]
+	}, {
+		"tagnode" : "V6",
+		"rule" : [ {
+			"tagnode" : 10,
+			"action" : "permit",
+			"match" : { "ipv6" : { "address" : { "prefix-list" : "PL6" } } },
+			"set" : { "ipv6-next-hop" : { "global" : "2001:db8::1" } }
+		} ]
+	}, {
+		"tagnode" : "COMM",
+		"rule" : [ {
+			"tagnode" : 10,
+			"action" : "permit",
+			"match" : { "large-community" : {
+				"large-community-list" : "LL", "exact-match" : null } },
+			"set" : { "delete-community" : 100 }
+		}, {
+			"tagnode" : 20,
+			"action" : "permit",
+			"match" : { "community" : { "community-list" : 100 } },
+			"set" : {
+				"extcommunity" : { "rt" : "100:1 100:2" },
+				"add-large-community" : "1:2:3",
+				"originator-id" : "1.1.1.1"
+			}
+		} ]
+	} ]
+}`
+
+/* Summarises the trace of a rule as "rule matched [reason] [set] [continue]" */
+func traceString(trace policy.RuleTrace) string {
+	s := fmt.Sprintf("%d %v", trace.Rule, trace.Matched)
+	if trace.Reason != "" {
+		s += " " + trace.Reason
+	}
+	for _, set := range trace.Set {
+		s += "; " + set
+	}
+	if trace.Continue != 0 {
+		s += fmt.Sprintf("; continue %d", trace.Continue)
+	}
+	return s
+}
+
+func TestEvaluateRouteMap(t *testing.T) {
+	var cfg map[string]interface{}
+	err := json.Unmarshal(policyConfig(testPolicy), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		routemap string
+		route    policy.Route
+		result   string
+		final    policy.Route
+		rules    []string
+	}{
+		{
+			name:     "permit and continue",
+			routemap: "RM",
+			route: policy.Route{Prefix: "10.1.0.0/16", Tag: 5,
+				AsPath: "100 200", Metric: 5},
+			result: "permit",
+			final: policy.Route{Prefix: "10.1.0.0/16", Tag: 5,
+				AsPath: "65000 65000 100 200", Metric: 15,
+				LocalPreference: 200, Communities: []string{"100:2"}},
+			rules: []string{
+				"10 true; local-preference 200; add-community 100:2; continue 30",
+				"30 true; metric +10: metric is 15; as-path-prepend 65000 65000",
+			},
+		},
+		{
+			name:     "deny after continue",
+			routemap: "RM",
+			route: policy.Route{Prefix: "10.1.0.0/16", Tag: 5,
+				AsPath: "100 666 200"},
+			result: "deny",
+			final: policy.Route{Prefix: "10.1.0.0/16", Tag: 5,
+				AsPath: "100 666 200", LocalPreference: 200,
+				Communities: []string{"100:2"}},
+			rules: []string{
+				"10 true; local-preference 200; add-community 100:2; continue 30",
+				"30 false match as-path AP: deny by rule 10",
+				"40 false match community community-list CL: no rule matched",
+				"50 true",
+			},
+		},
+		{
+			name:     "standard community-list",
+			routemap: "RM",
+			route: policy.Route{Prefix: "192.168.0.0/16", Tag: 5,
+				Origin: "igp", Communities: []string{"7:7", "no-export", "0.100:1"}},
+			result: "permit",
+			final: policy.Route{Prefix: "192.168.0.0/16", Tag: 5,
+				Origin: "igp"},
+			rules: []string{
+				"10 false match ip address prefix-list PL: deny by rule 20",
+				"20 false match origin egp: route has origin igp",
+				"30 false match as-path AP: no rule matched",
+				"40 true; community none",
+			},
+		},
+		{
+			name:     "prefix length outside prefix-list",
+			routemap: "RM",
+			route:    policy.Route{Prefix: "10.1.1.0/25", Tag: 5},
+			result:   "deny",
+			final:    policy.Route{Prefix: "10.1.1.0/25", Tag: 5},
+			rules: []string{
+				"10 false match ip address prefix-list PL: deny by rule 20",
+				"20 false match origin egp: route has origin none",
+				"30 false match as-path AP: no rule matched",
+				"40 false match community community-list CL: no rule matched",
+				"50 true",
+			},
+		},
+		{
+			name:     "standard access-list",
+			routemap: "ACL",
+			route:    policy.Route{Prefix: "10.2.0.0/16"},
+			result:   "permit",
+			final:    policy.Route{Prefix: "10.2.0.0/16"},
+			rules:    []string{"10 true"},
+		},
+		{
+			name:     "extended access-list",
+			routemap: "ACL",
+			route:    policy.Route{Prefix: "10.1.1.0/24"},
+			result:   "permit",
+			final:    policy.Route{Prefix: "10.1.1.0/24", Tag: 100},
+			rules: []string{
+				"10 false match ip address access-list 10: deny by rule 10",
+				"20 true; tag 100",
+			},
+		},
+		{
+			name:     "no access-list match",
+			routemap: "ACL",
+			route:    policy.Route{Prefix: "192.168.0.0/16"},
+			result:   "deny",
+			final:    policy.Route{Prefix: "192.168.0.0/16"},
+			rules: []string{
+				"10 false match ip address access-list 10: no rule matched",
+				"20 false match ip address access-list 100: no rule matched",
+			},
+		},
+		{
+			name:     "IPv6 prefix-list",
+			routemap: "V6",
+			route:    policy.Route{Prefix: "2001:db8:1::/48", NextHop: "fe80::1"},
+			result:   "permit",
+			final:    policy.Route{Prefix: "2001:db8:1::/48", NextHop: "2001:db8::1"},
+			rules:    []string{"10 true; ipv6-next-hop global 2001:db8::1"},
+		},
+		{
+			name:     "IPv6 prefix-list with IPv4 route",
+			routemap: "V6",
+			route:    policy.Route{Prefix: "10.0.0.0/8"},
+			result:   "deny",
+			final:    policy.Route{Prefix: "10.0.0.0/8"},
+			rules:    []string{"10 false match ipv6: route is not an IPv6 route"},
+		},
+		{
+			name:     "large community exact-match",
+			routemap: "COMM",
+			route: policy.Route{Prefix: "10.0.0.0/8",
+				Communities:      []string{"65000:1", "100:1"},
+				LargeCommunities: []string{"65536:1:1"}},
+			result: "permit",
+			final: policy.Route{Prefix: "10.0.0.0/8",
+				Communities:      []string{"100:1"},
+				LargeCommunities: []string{"65536:1:1"}},
+			rules: []string{"10 true; delete-community 100"},
+		},
+		{
+			name:     "expanded community-list",
+			routemap: "COMM",
+			route: policy.Route{Prefix: "10.0.0.0/8",
+				Communities:      []string{"65000:1"},
+				ExtCommunities:   []string{"soo:1:1"},
+				LargeCommunities: []string{"65536:1:1", "1:2:3"}},
+			result: "permit",
+			final: policy.Route{Prefix: "10.0.0.0/8",
+				Communities:      []string{"65000:1"},
+				ExtCommunities:   []string{"RT:100:1", "RT:100:2"},
+				LargeCommunities: []string{"65536:1:1", "1:2:3"}},
+			rules: []string{
+				"10 false match large-community large-community-list LL: " +
+					"no rule matched",
+				"20 true; extcommunity rt 100:1 100:2; " +
+					"add-large-community 1:2:3; " +
+					"originator-id: not applied to the test route",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := policy.EvaluateRouteMap(cfg, test.routemap, test.route)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if result.Result != test.result {
+			t.Errorf("%s: expected %s, got %s", test.name, test.result,
+				result.Result)
+		}
+		if !reflect.DeepEqual(normalizeRoute(result.Route),
+			normalizeRoute(test.final)) {
+			t.Errorf("%s: expected route:\n%+v\ngot:\n%+v", test.name,
+				test.final, result.Route)
+		}
+
+		rules := make([]string, 0, len(result.Rules))
+		for _, trace := range result.Rules {
+			rules = append(rules, traceString(trace))
+		}
+		if !reflect.DeepEqual(rules, test.rules) {
+			t.Errorf("%s: expected rules:\n%s\ngot:\n%s", test.name,
+				strings.Join(test.rules, "\n"), strings.Join(rules, "\n"))
+		}
+	}
+}
+
+/* Treats empty and nil community slices alike */
+func normalizeRoute(r policy.Route) policy.Route {
+	if len(r.Communities) == 0 {
+		r.Communities = nil
+	}
+	if len(r.ExtCommunities) == 0 {
+		r.ExtCommunities = nil
+	}
+	if len(r.LargeCommunities) == 0 {
+		r.LargeCommunities = nil
+	}
+	return r
+}
+
+func TestEvaluateRouteMapErrors(t *testing.T) {
+	var cfg map[string]interface{}
+	err := json.Unmarshal(policyConfig(testPolicy), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		routemap string
+		route    policy.Route
+		err      string
+	}{
+		{routemap: "NONE", route: policy.Route{Prefix: "10.0.0.0/8"},
+			err: "route-map NONE does not exist"},
+		{routemap: "RM", route: policy.Route{Prefix: "10.0.0.0"},
+			err: "10.0.0.0 is not a valid prefix"},
+		{routemap: "RM",
+			route: policy.Route{Prefix: "10.0.0.0/8", NextHop: "x"},
+			err:   "x is not a valid next-hop"},
+	}
+
+	for _, test := range tests {
+		_, err := policy.EvaluateRouteMap(cfg, test.routemap, test.route)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.routemap,
+				test.err, err)
+		}
+	}
+}
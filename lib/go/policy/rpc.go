@@ -0,0 +1,183 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package policy
+
+import (
+	"eng.vyatta.net/protocols"
+	log "github.com/Sirupsen/logrus"
+)
+
+/* Name of the YANG module defining the policy RPCs */
+const RPC_MODULE_NAME = "vyatta-policy-route-v1"
+
+/*
+ * PolicyRPC implements the RPCs of the vyatta-policy-route-v1 model.
+ *
+ * A VCI component which consumes the policy configuration should
+ * register an instance with:
+ *
+ *     pmc.SetRPC(policy.RPC_MODULE_NAME, policy.NewPolicyRPC(pmc))
+ */
+type PolicyRPC struct {
+	pmc *protocols.ProtocolsModelComponent
+}
+
+func NewPolicyRPC(pmc *protocols.ProtocolsModelComponent) *PolicyRPC {
+	return &PolicyRPC{pmc: pmc}
+}
+
+/*
+ * Returns the internal JSON of the running policy configuration, which
+ * is empty if none has been committed
+ */
+func (r *PolicyRPC) loadConfig() (map[string]interface{}, error) {
+	return loadSystemConfig(r.pmc)
+}
+
+type TestRouteMapInput struct {
+	RouteMap string `rfc7951:"vyatta-policy-route-v1:route-map"`
+	Route    Route  `rfc7951:"vyatta-policy-route-v1:route"`
+}
+
+type TestRouteMapOutput struct {
+	Result string      `rfc7951:"vyatta-policy-route-v1:result"`
+	Route  Route       `rfc7951:"vyatta-policy-route-v1:route"`
+	Rules  []RuleTrace `rfc7951:"vyatta-policy-route-v1:rule,omitempty"`
+}
+
+/*
+ * test-route-map RPC
+ *
+ * Evaluates a route-map of the running configuration against a route,
+ * returning whether it is permitted, its resulting attributes and how
+ * each rule was applied
+ */
+func (r *PolicyRPC) TestRouteMap(in *TestRouteMapInput) (*TestRouteMapOutput, error) {
+	cfg_map, err := r.loadConfig()
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	result, err := EvaluateRouteMap(cfg_map, in.RouteMap, in.Route)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TestRouteMapOutput{
+		Result: result.Result,
+		Route:  result.Route,
+		Rules:  result.Rules,
+	}, nil
+}
+
+type MatchPrefixListInput struct {
+	PrefixList string `rfc7951:"vyatta-policy-route-v1:prefix-list"`
+	Prefix     string `rfc7951:"vyatta-policy-route-v1:prefix"`
+}
+
+type MatchPrefixListOutput struct {
+	Action string `rfc7951:"vyatta-policy-route-v1:action"`
+	Rule   uint32 `rfc7951:"vyatta-policy-route-v1:rule,omitempty"`
+}
+
+/*
+ * match-prefix-list RPC
+ *
+ * Returns the first rule of a prefix-list, or prefix-list6, of the
+ * running configuration which matches a prefix
+ */
+func (r *PolicyRPC) MatchPrefixList(in *MatchPrefixListInput) (*MatchPrefixListOutput, error) {
+	cfg_map, err := r.loadConfig()
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	result, err := MatchPrefixList(cfg_map, in.PrefixList, in.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MatchPrefixListOutput{Action: result.Action, Rule: result.Rule}, nil
+}
+
+type MatchAccessListInput struct {
+	AccessList  string `rfc7951:"vyatta-policy-route-v1:access-list"`
+	Source      string `rfc7951:"vyatta-policy-route-v1:source"`
+	Destination string `rfc7951:"vyatta-policy-route-v1:destination,omitempty"`
+}
+
+type MatchAccessListOutput struct {
+	Action string `rfc7951:"vyatta-policy-route-v1:action"`
+	Rule   uint32 `rfc7951:"vyatta-policy-route-v1:rule,omitempty"`
+}
+
+/*
+ * match-access-list RPC
+ *
+ * Returns the first rule of an access-list, or access-list6, of the
+ * running configuration which matches a source and destination address
+ */
+func (r *PolicyRPC) MatchAccessList(in *MatchAccessListInput) (*MatchAccessListOutput, error) {
+	cfg_map, err := r.loadConfig()
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	result, err := MatchAccessList(cfg_map, in.AccessList, in.Source,
+		in.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MatchAccessListOutput{Action: result.Action, Rule: result.Rule}, nil
+}
+
+type GetPolicyReferencesInput struct {
+	Kind string `rfc7951:"vyatta-policy-route-v1:kind"`
+	Name string `rfc7951:"vyatta-policy-route-v1:name"`
+}
+
+type GetPolicyReferencesOutput struct {
+	Exists       bool     `rfc7951:"vyatta-policy-route-v1:exists"`
+	ReferencedBy []string `rfc7951:"vyatta-policy-route-v1:referenced-by,omitempty"`
+}
+
+/*
+ * get-policy-references RPC
+ *
+ * Returns whether a route-map, or a list used by route-maps, exists in
+ * the running configuration and the config paths which reference it,
+ * including those of the protocol models set by SetProtocolModels
+ */
+func (r *PolicyRPC) GetPolicyReferences(in *GetPolicyReferencesInput) (*GetPolicyReferencesOutput, error) {
+	cfg_map, err := r.loadConfig()
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	protocol_cfgs, err := loadProtocolConfigs(r.pmc)
+	if err != nil {
+		log.Errorln("Failed to load protocol configuration: " + err.Error())
+		return nil, err
+	}
+
+	protocol_maps, err := unmarshalConfigs(protocol_cfgs)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := NewPolicyGraph(cfg_map, protocol_maps...)
+	obj := PolicyObject{Kind: in.Kind, Name: in.Name}
+
+	return &GetPolicyReferencesOutput{
+		Exists:       graph.Exists(obj),
+		ReferencedBy: graph.ReferencedBy(obj),
+	}, nil
+}
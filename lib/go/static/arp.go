@@ -0,0 +1,689 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* Record of when the static neighbour reconciler last installed each entry */
+const neighRepairFile = "/run/routing/static-neigh-repairs.json"
+
+/*
+ * A static ARP or IPv6 neighbour entry of a routing-instance
+ */
+type ArpEntry struct {
+	RoutingInstance string
+	IP              net.IP
+	HwAddr          net.HardwareAddr
+	Interface       string
+}
+
+/*
+ * Returns the kernel neighbour table key of the entry. The kernel table
+ * is keyed on address and interface, ignoring the routing-instance.
+ */
+func (e *ArpEntry) key() string {
+	return e.IP.String() + "," + e.Interface
+}
+
+/*
+ * A change in the state of a link
+ */
+type LinkEvent struct {
+	Name    string
+	Index   int
+	Up      bool
+	Deleted bool
+}
+
+/*
+ * A change in the kernel neighbour table
+ */
+type NeighEvent struct {
+	Neigh   netlink.Neigh
+	Deleted bool
+}
+
+/*
+ * The subset of netlink operations used to maintain static neighbours
+ */
+type NeighHandle interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	NeighSet(neigh *netlink.Neigh) error
+	NeighDel(neigh *netlink.Neigh) error
+	// Delivers link and neighbour events until done is closed
+	Subscribe(links chan<- LinkEvent, neighs chan<- NeighEvent,
+		done <-chan struct{}) error
+}
+
+type netlinkNeighHandle struct {
+	netlink.Handle
+}
+
+func (h *netlinkNeighHandle) Subscribe(links chan<- LinkEvent,
+	neighs chan<- NeighEvent, done <-chan struct{}) error {
+	link_updates := make(chan netlink.LinkUpdate)
+	neigh_updates := make(chan netlink.NeighUpdate)
+
+	err := netlink.LinkSubscribe(link_updates, done)
+	if err != nil {
+		return err
+	}
+	err = netlink.NeighSubscribe(neigh_updates, done)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for update := range link_updates {
+			attrs := update.Link.Attrs()
+			select {
+			case links <- LinkEvent{
+				Name:    attrs.Name,
+				Index:   attrs.Index,
+				Up:      attrs.Flags&net.FlagUp != 0,
+				Deleted: update.Header.Type == syscall.RTM_DELLINK,
+			}:
+			case <-done:
+			}
+		}
+	}()
+	go func() {
+		for update := range neigh_updates {
+			select {
+			case neighs <- NeighEvent{
+				Neigh:   update.Neigh,
+				Deleted: update.Type == syscall.RTM_DELNEIGH,
+			}:
+			case <-done:
+			}
+		}
+	}()
+
+	return nil
+}
+
+/* Static container lists holding neighbours, with their address family */
+var neighLists = [...]struct {
+	key    string
+	family int
+}{
+	{"arp", netlink.FAMILY_V4},
+	{"neighbor6", netlink.FAMILY_V6},
+}
+
+/*
+ * Returns the family of address ip
+ */
+func neighFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+/*
+ * Returns the static ARP and IPv6 neighbour entries of the translated
+ * configuration cfg, those of the default routing-instance first.
+ * Invalid entries are omitted.
+ */
+func neighConfig(cfg map[string]interface{}) ([]ArpEntry, error) {
+	ret_err := protocols.NewMultiError()
+	var entries []ArpEntry
+
+	addEntries := func(proto_if interface{}, ri string) {
+		proto_map, _ := proto_if.(map[string]interface{})
+		static_map, _ := proto_map["static"].(map[string]interface{})
+		path := StaticPath(ri)
+
+		for _, list := range neighLists {
+			neigh_arr, _ := static_map[list.key].([]interface{})
+			for _, neigh_entry := range neigh_arr {
+				neigh_map, ok := neigh_entry.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				neigh_path := EntryPath(path, list.key, neigh_map["tagnode"])
+
+				ip := net.ParseIP(fmt.Sprint(neigh_map["tagnode"]))
+				hwaddr, err := net.ParseMAC(fmt.Sprint(neigh_map["hwaddr"]))
+				ifname, _ := neigh_map["interface"].(string)
+				switch {
+				case ip == nil || neighFamily(ip) != list.family:
+					ret_err = multierr.Append(ret_err,
+						pathError(neigh_path, "Invalid address"))
+				case err != nil:
+					ret_err = multierr.Append(ret_err,
+						pathError(neigh_path, "Invalid hwaddr: %s", err))
+				case ifname == "":
+					ret_err = multierr.Append(ret_err,
+						pathError(neigh_path, "Missing interface"))
+				default:
+					entries = append(entries,
+						ArpEntry{ri, ip, hwaddr, ifname})
+				}
+			}
+		}
+	}
+
+	addEntries(cfg["protocols"], "default")
+
+	routing_map, _ := cfg["routing"].(map[string]interface{})
+	ri_arr, _ := routing_map["routing-instance"].([]interface{})
+	for _, ri_entry := range ri_arr {
+		ri_map, _ := ri_entry.(map[string]interface{})
+		if ri, ok := ri_map["instance-name"].(string); ok {
+			addEntries(ri_map["protocols"], ri)
+		}
+	}
+
+	return entries, ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the static ARP and IPv6 neighbour entries of the translated
+ * configuration cfg, keyed on address and interface. Where the same
+ * address and interface is configured in several routing-instances the
+ * last one takes effect, with the default routing-instance first.
+ */
+func ArpConfig(cfg map[string]interface{}) (map[string]ArpEntry, error) {
+	entries := make(map[string]ArpEntry)
+
+	entry_arr, err := neighConfig(cfg)
+	for _, entry := range entry_arr {
+		entries[entry.key()] = entry
+	}
+
+	return entries, err
+}
+
+/*
+ * Returns the time at which the static neighbour reconciler last
+ * installed each entry, keyed as ArpEntry.key(), as recorded in path
+ */
+func ReadNeighRepairs(path string) (map[string]time.Time, error) {
+	repairs := make(map[string]time.Time)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return repairs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &repairs)
+	return repairs, err
+}
+
+/*
+ * Returns the names of the routing-instances in containers, in the order
+ * in which ArpConfig() applies their entries
+ */
+func neighRoutingInstances(containers map[string]map[string]interface{}) []string {
+	ri_names := make([]string, 0, len(containers))
+	for ri, _ := range containers {
+		if ri != "default" {
+			ri_names = append(ri_names, ri)
+		}
+	}
+	sort.Strings(ri_names)
+
+	return append([]string{"default"}, ri_names...)
+}
+
+/*
+ * Calls fn with the config path and entry of each static ARP and IPv6
+ * neighbour of routing-instance ri in containers
+ */
+func forEachNeigh(containers map[string]map[string]interface{}, ri string,
+	fn func(neigh_path string, neigh_map map[string]interface{})) {
+	path := StaticPath(ri)
+	for _, list := range neighLists {
+		neigh_arr, _ := containers[ri][list.key].([]interface{})
+		for _, neigh_entry := range neigh_arr {
+			neigh_map, ok := neigh_entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fn(EntryPath(path, list.key, neigh_map["tagnode"]), neigh_map)
+		}
+	}
+}
+
+/*
+ * Checks the static ARP and IPv6 neighbours of all routing-instances in
+ * frontend_map. The kernel keys neighbours on address and interface only,
+ * so the same address and interface must not be configured with different
+ * hwaddrs in different routing-instances.
+ *
+ * Problems with the structure of the configuration are left to Validate().
+ */
+func ValidateNeighbours(frontend_map map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+	containers, _ := staticContainers(frontend_map)
+
+	type configuredNeigh struct {
+		path   string
+		hwaddr string
+	}
+	configured := make(map[string]configuredNeigh)
+
+	for _, ri := range neighRoutingInstances(containers) {
+		forEachNeigh(containers, ri,
+			func(neigh_path string, neigh_map map[string]interface{}) {
+				ifname, _ := neigh_map["interface"].(string)
+				ip := net.ParseIP(fmt.Sprint(neigh_map["tagnode"]))
+				hwaddr, err := net.ParseMAC(fmt.Sprint(neigh_map["hwaddr"]))
+				if ip == nil || err != nil || ifname == "" {
+					return
+				}
+
+				entry := ArpEntry{ri, ip, hwaddr, ifname}
+				prev, ok := configured[entry.key()]
+				if !ok {
+					configured[entry.key()] = configuredNeigh{
+						neigh_path, hwaddr.String()}
+					return
+				}
+				if prev.hwaddr != hwaddr.String() {
+					ret_err = multierr.Append(ret_err, pathError(neigh_path,
+						"Conflicts with [%s]: %s on interface %s "+
+							"has hwaddr %s", prev.path, ip, ifname,
+						prev.hwaddr))
+				}
+			})
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Checks that the interface of each static ARP and IPv6 neighbour in
+ * frontend_map belongs to its routing-instance, given the routing-instance
+ * of each interface of a routing-instance as returned by
+ * ConfiguredInterfaceRoutingInstances(). Other interfaces belong to the
+ * default routing-instance.
+ */
+func ValidateNeighbourInterfaces(frontend_map map[string]interface{},
+	if_ri map[string]string) error {
+	ret_err := protocols.NewMultiError()
+	containers, _ := staticContainers(frontend_map)
+
+	for _, ri := range neighRoutingInstances(containers) {
+		forEachNeigh(containers, ri,
+			func(neigh_path string, neigh_map map[string]interface{}) {
+				ifname, _ := neigh_map["interface"].(string)
+				if_ri_name, ok := if_ri[ifname]
+				if !ok {
+					if_ri_name = "default"
+				}
+				if if_ri_name != ri {
+					ret_err = multierr.Append(ret_err, pathError(neigh_path,
+						"Interface %s does not belong to routing-instance %s",
+						ifname, ri))
+				}
+			})
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * StaticArp maintains the static ARP and IPv6 neighbour entries of the
+ * configuration as permanent entries in the kernel neighbour table.
+ *
+ * Set() reconciles the kernel table with the configured entries in bulk,
+ * replacing entries which differ and removing the entries it installed
+ * which are no longer configured. Permanent entries installed by others
+ * are left alone. Run() then watches link and neighbour events,
+ * restoring the entries of an interface when it comes up or is
+ * re-created, and any entry deleted while its interface is up.
+ * Entries for interfaces which do not exist are installed once they do.
+ *
+ * The time each entry was last installed is recorded in the repair file,
+ * if any, for status reporting. The entries recorded there are those
+ * which are removed once no longer configured, including by a later
+ * StaticArp after the service restarts.
+ */
+type StaticArp struct {
+	lock        sync.Mutex
+	handle      NeighHandle
+	entries     map[string]ArpEntry
+	repairs     map[string]time.Time
+	repair_file string
+	stop        chan struct{}
+}
+
+func NewStaticArp() *StaticArp {
+	return NewStaticArpWithHandle(&netlinkNeighHandle{}, neighRepairFile)
+}
+
+func NewStaticArpWithHandle(handle NeighHandle, repair_file string) *StaticArp {
+	repairs := make(map[string]time.Time)
+	if repair_file != "" {
+		var err error
+		repairs, err = ReadNeighRepairs(repair_file)
+		if err != nil {
+			log.Warningf("Failed to read neighbour repairs: %s", err)
+			repairs = make(map[string]time.Time)
+		}
+	}
+
+	return &StaticArp{
+		handle:      handle,
+		entries:     make(map[string]ArpEntry),
+		repairs:     repairs,
+		repair_file: repair_file,
+		stop:        make(chan struct{}),
+	}
+}
+
+/*
+ * Sets the configured entries, as returned by ArpConfig(),
+ * and reconciles the kernel table with them
+ */
+func (a *StaticArp) Set(entries map[string]ArpEntry) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.entries = entries
+	return a.reconcile("")
+}
+
+/*
+ * Writes the repair times of the entries to the repair file
+ */
+func (a *StaticArp) saveRepairs() error {
+	if a.repair_file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(a.repairs)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(a.repair_file), 0755)
+	if err != nil {
+		return err
+	}
+
+	//Replace the file so that readers never see a partial write
+	tmp := a.repair_file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.repair_file)
+}
+
+/*
+ * Returns the time at which each entry was last installed
+ */
+func (a *StaticArp) Repairs() map[string]time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	repairs := make(map[string]time.Time)
+	for key, t := range a.repairs {
+		repairs[key] = t
+	}
+	return repairs
+}
+
+/*
+ * Returns whether there are any configured entries
+ */
+func (a *StaticArp) HasEntries() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return len(a.entries) > 0
+}
+
+/*
+ * Returns the neighbour to install in the kernel for entry,
+ * or nil if its interface does not exist
+ */
+func (a *StaticArp) neigh(entry ArpEntry) *netlink.Neigh {
+	link, err := a.handle.LinkByName(entry.Interface)
+	if err != nil {
+		return nil
+	}
+
+	return &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       neighFamily(entry.IP),
+		State:        netlink.NUD_PERMANENT,
+		IP:           entry.IP,
+		HardwareAddr: entry.HwAddr,
+	}
+}
+
+/*
+ * A permanent entry in the kernel neighbour table
+ */
+type kernelNeigh struct {
+	neigh  netlink.Neigh
+	ifname string
+}
+
+/*
+ * Returns the entries in the kernel neighbour table, or only the
+ * permanent ones if permanent is set, keyed as ArpEntry.key()
+ */
+func kernelNeighs(handle NeighHandle, permanent bool) (map[string]kernelNeigh, error) {
+	var neighs []netlink.Neigh
+	for _, list := range neighLists {
+		family_neighs, err := handle.NeighList(0, list.family)
+		if err != nil {
+			return nil, err
+		}
+		neighs = append(neighs, family_neighs...)
+	}
+
+	names := make(map[int]string)
+	installed := make(map[string]kernelNeigh)
+	for _, neigh := range neighs {
+		if permanent && neigh.State&netlink.NUD_PERMANENT == 0 {
+			continue
+		}
+		if _, ok := names[neigh.LinkIndex]; !ok {
+			link, err := handle.LinkByIndex(neigh.LinkIndex)
+			if err != nil {
+				continue
+			}
+			names[neigh.LinkIndex] = link.Attrs().Name
+		}
+		entry := ArpEntry{IP: neigh.IP, Interface: names[neigh.LinkIndex]}
+		installed[entry.key()] = kernelNeigh{neigh, entry.Interface}
+	}
+
+	return installed, nil
+}
+
+/*
+ * Reconciles the kernel table with the configured entries, restricted
+ * to those of interface ifname unless it is empty. Only entries which
+ * were installed by the reconciler, as recorded in the repairs, are
+ * removed.
+ */
+func (a *StaticArp) reconcile(ifname string) error {
+	installed, err := kernelNeighs(a.handle, true)
+	if err != nil {
+		return fmt.Errorf("Failed to list neighbours: %s", err)
+	}
+
+	ret_err := protocols.NewMultiError()
+	changed := false
+
+	keys := make([]string, 0, len(a.repairs))
+	for key, _ := range a.repairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, configured := a.entries[key]; configured {
+			continue
+		}
+		kn, ok := installed[key]
+		if !ok {
+			//Already gone, such as with its interface
+			if ifname == "" {
+				delete(a.repairs, key)
+				changed = true
+			}
+			continue
+		}
+		if ifname != "" && kn.ifname != ifname {
+			continue
+		}
+
+		log.Infof("Removing neighbour %s", key)
+		err := a.handle.NeighDel(&kn.neigh)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				fmt.Errorf("Failed to remove neighbour %s: %s", key, err))
+			continue
+		}
+		delete(a.repairs, key)
+		changed = true
+	}
+
+	keys = keys[:0]
+	for key, _ := range a.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := a.entries[key]
+		if ifname != "" && entry.Interface != ifname {
+			continue
+		}
+		kn, ok := installed[key]
+		if ok && kn.neigh.HardwareAddr.String() == entry.HwAddr.String() {
+			continue
+		}
+
+		new_neigh := a.neigh(entry)
+		if new_neigh == nil {
+			log.Debugf("Interface %s of neighbour %s does not exist",
+				entry.Interface, key)
+			continue
+		}
+		log.Infof("Setting neighbour %s lladdr %s", key, entry.HwAddr)
+		err := a.handle.NeighSet(new_neigh)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				fmt.Errorf("Failed to set neighbour %s: %s", key, err))
+			continue
+		}
+		a.repairs[key] = time.Now()
+		changed = true
+	}
+
+	if changed {
+		err := a.saveRepairs()
+		if err != nil {
+			log.Warningf("Failed to record neighbour repairs: %s", err)
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Handles link and neighbour events until Stop() is called
+ */
+func (a *StaticArp) Run() error {
+	links := make(chan LinkEvent, 64)
+	neighs := make(chan NeighEvent, 64)
+
+	err := a.handle.Subscribe(links, neighs, a.stop)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var err error
+
+		select {
+		case <-a.stop:
+			return nil
+		case event := <-links:
+			err = a.linkEvent(event)
+		case event := <-neighs:
+			err = a.neighEvent(event)
+		}
+
+		if err != nil {
+			log.Errorln("Static ARP: " + err.Error())
+		}
+	}
+}
+
+func (a *StaticArp) linkEvent(event LinkEvent) error {
+	if event.Deleted || !event.Up {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, entry := range a.entries {
+		if entry.Interface == event.Name {
+			log.Debugf("Restoring neighbours of %s", event.Name)
+			return a.reconcile(event.Name)
+		}
+	}
+
+	return nil
+}
+
+func (a *StaticArp) neighEvent(event NeighEvent) error {
+	if !event.Deleted || event.Neigh.IP == nil {
+		return nil
+	}
+
+	link, err := a.handle.LinkByIndex(event.Neigh.LinkIndex)
+	if err != nil || link.Attrs().Flags&net.FlagUp == 0 {
+		//Restored when the interface comes up
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	entry := ArpEntry{IP: event.Neigh.IP, Interface: link.Attrs().Name}
+	if _, configured := a.entries[entry.key()]; !configured {
+		return nil
+	}
+
+	log.Infof("Restoring deleted neighbour %s", entry.key())
+	return a.reconcile(entry.Interface)
+}
+
+func (a *StaticArp) Stop() {
+	close(a.stop)
+}
@@ -0,0 +1,470 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * A fake netlink layer holding links and a neighbour table
+ */
+type fakeNeighHandle struct {
+	lock       sync.Mutex
+	links      map[string]*netlink.Dummy
+	neighs     map[string]netlink.Neigh
+	linkCh     chan<- static.LinkEvent
+	neighCh    chan<- static.NeighEvent
+	subscribed chan struct{}
+}
+
+func newFakeNeighHandle() *fakeNeighHandle {
+	return &fakeNeighHandle{
+		links:      make(map[string]*netlink.Dummy),
+		neighs:     make(map[string]netlink.Neigh),
+		subscribed: make(chan struct{}),
+	}
+}
+
+func (h *fakeNeighHandle) addLink(name string, index int, up bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name, Index: index}}
+	if up {
+		link.Flags = net.FlagUp
+	}
+	h.links[name] = link
+}
+
+func (h *fakeNeighHandle) delLink(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	index := h.links[name].Index
+	delete(h.links, name)
+	for key, neigh := range h.neighs {
+		if neigh.LinkIndex == index {
+			delete(h.neighs, key)
+		}
+	}
+}
+
+func neighKey(neigh *netlink.Neigh) string {
+	return fmt.Sprintf("%s,%d", neigh.IP, neigh.LinkIndex)
+}
+
+func (h *fakeNeighHandle) LinkByName(name string) (netlink.Link, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if link, ok := h.links[name]; ok {
+		return link, nil
+	}
+	return nil, fmt.Errorf("Link not found")
+}
+
+func (h *fakeNeighHandle) LinkByIndex(index int) (netlink.Link, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, link := range h.links {
+		if link.Index == index {
+			return link, nil
+		}
+	}
+	return nil, fmt.Errorf("Link not found")
+}
+
+func (h *fakeNeighHandle) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var neighs []netlink.Neigh
+	for _, neigh := range h.neighs {
+		if (neigh.IP.To4() != nil) == (family == netlink.FAMILY_V4) {
+			neighs = append(neighs, neigh)
+		}
+	}
+	return neighs, nil
+}
+
+func (h *fakeNeighHandle) NeighSet(neigh *netlink.Neigh) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.neighs[neighKey(neigh)] = *neigh
+	return nil
+}
+
+func (h *fakeNeighHandle) NeighDel(neigh *netlink.Neigh) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.neighs, neighKey(neigh))
+	return nil
+}
+
+func (h *fakeNeighHandle) Subscribe(links chan<- static.LinkEvent,
+	neighs chan<- static.NeighEvent, done <-chan struct{}) error {
+	h.linkCh = links
+	h.neighCh = neighs
+	close(h.subscribed)
+	return nil
+}
+
+/*
+ * Waits for neighbour key to be installed
+ */
+func (h *fakeNeighHandle) waitFor(t *testing.T, key string) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		h.lock.Lock()
+		_, ok := h.neighs[key]
+		h.lock.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Neighbour %s not installed", key)
+}
+
+/*
+ * Returns the neighbour table as "ip,ifindex hwaddr state" entries
+ */
+func (h *fakeNeighHandle) String() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var entries []string
+	for key, neigh := range h.neighs {
+		entries = append(entries, fmt.Sprintf("%s %s %d",
+			key, neigh.HardwareAddr, neigh.State))
+	}
+	sort.Strings(entries)
+	return fmt.Sprint(entries)
+}
+
+func checkNeighs(t *testing.T, h *fakeNeighHandle, expected string) {
+	t.Helper()
+
+	if got := h.String(); got != expected {
+		t.Errorf("Unexpected neighbours\nexpected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestArpConfig(t *testing.T) {
+	cfg := map[string]interface{}{
+		"protocols": map[string]interface{}{
+			"static": map[string]interface{}{
+				"arp": []interface{}{
+					map[string]interface{}{
+						"tagnode":   "10.0.0.1",
+						"hwaddr":    "00:00:00:00:00:01",
+						"interface": "dp0s1",
+					},
+					map[string]interface{}{
+						"tagnode":   "10.0.0.2",
+						"hwaddr":    "00:00:00:00:00:02",
+						"interface": "dp0s1",
+					},
+				},
+			},
+		},
+		"routing": map[string]interface{}{
+			"routing-instance": []interface{}{
+				map[string]interface{}{
+					"instance-name": "RED",
+					"protocols": map[string]interface{}{
+						"static": map[string]interface{}{
+							"arp": []interface{}{
+								map[string]interface{}{
+									"tagnode":   "10.0.0.1",
+									"hwaddr":    "00:00:00:00:00:03",
+									"interface": "dp0s1",
+								},
+							},
+							"neighbor6": []interface{}{
+								map[string]interface{}{
+									"tagnode":   "fe80::1",
+									"hwaddr":    "00:00:00:00:00:04",
+									"interface": "dp0s1",
+								},
+								map[string]interface{}{
+									"tagnode":   "10.0.0.3",
+									"hwaddr":    "00:00:00:00:00:05",
+									"interface": "dp0s1",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	entries, err := static.ArpConfig(cfg)
+	expected_err := "[routing routing-instance RED protocols static neighbor6 10.0.0.3]\n" +
+		"Invalid address"
+	if err == nil || !strings.Contains(err.Error(), expected_err) {
+		t.Errorf("Expected error %s, got %v", expected_err, err)
+	}
+
+	expected := map[string]string{
+		"10.0.0.1,dp0s1": "RED 00:00:00:00:00:03",
+		"10.0.0.2,dp0s1": "default 00:00:00:00:00:02",
+		"fe80::1,dp0s1":  "RED 00:00:00:00:00:04",
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+	for key, exp := range expected {
+		entry := entries[key]
+		if got := entry.RoutingInstance + " " + entry.HwAddr.String(); got != exp {
+			t.Errorf("Unexpected entry for %s: %s", key, got)
+		}
+	}
+}
+
+func arpEntry(ip, hwaddr, ifname string) static.ArpEntry {
+	mac, _ := net.ParseMAC(hwaddr)
+	return static.ArpEntry{"default", net.ParseIP(ip), mac, ifname}
+}
+
+func TestStaticArpReconcile(t *testing.T) {
+	h := newFakeNeighHandle()
+	h.addLink("dp0s1", 1, true)
+
+	stale, _ := net.ParseMAC("00:00:00:00:00:09")
+	for _, neigh := range []netlink.Neigh{
+		//Permanent entry installed by others left alone
+		{LinkIndex: 1, IP: net.ParseIP("10.0.0.9"), HardwareAddr: stale,
+			State: netlink.NUD_PERMANENT},
+		//Dynamic entry left alone
+		{LinkIndex: 1, IP: net.ParseIP("10.0.0.8"), HardwareAddr: stale,
+			State: netlink.NUD_REACHABLE},
+		//Configured with a different hwaddr
+		{LinkIndex: 1, IP: net.ParseIP("10.0.0.1"), HardwareAddr: stale,
+			State: netlink.NUD_PERMANENT},
+		//as is an IPv6 one
+		{LinkIndex: 1, IP: net.ParseIP("2001::9"), HardwareAddr: stale,
+			State: netlink.NUD_PERMANENT},
+	} {
+		h.NeighSet(&neigh)
+	}
+
+	entries := make(map[string]static.ArpEntry)
+	for _, entry := range []static.ArpEntry{
+		arpEntry("10.0.0.1", "00:00:00:00:00:01", "dp0s1"),
+		arpEntry("10.0.0.2", "00:00:00:00:00:02", "dp0s2"),
+		arpEntry("2001::1", "00:00:00:00:00:06", "dp0s1"),
+	} {
+		entries[entry.IP.String()+","+entry.Interface] = entry
+	}
+
+	arp := static.NewStaticArpWithHandle(h, "")
+	if err := arp.Set(entries); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	checkNeighs(t, h, "[10.0.0.1,1 00:00:00:00:00:01 128"+
+		" 10.0.0.8,1 00:00:00:00:00:09 2 10.0.0.9,1 00:00:00:00:00:09 128"+
+		" 2001::1,1 00:00:00:00:00:06 128 2001::9,1 00:00:00:00:00:09 128]")
+
+	done := make(chan error)
+	go func() { done <- arp.Run() }()
+	<-h.subscribed
+	links, neighs := h.linkCh, h.neighCh
+
+	//The entry is installed once its interface is created and up
+	h.addLink("dp0s2", 2, false)
+	links <- static.LinkEvent{Name: "dp0s2", Index: 2}
+	h.addLink("dp0s2", 2, true)
+	links <- static.LinkEvent{Name: "dp0s2", Index: 2, Up: true}
+	h.waitFor(t, "10.0.0.2,2")
+
+	//and restored when it is re-created
+	h.delLink("dp0s2")
+	links <- static.LinkEvent{Name: "dp0s2", Index: 2, Deleted: true}
+	h.addLink("dp0s2", 3, true)
+	links <- static.LinkEvent{Name: "dp0s2", Index: 3, Up: true}
+	h.waitFor(t, "10.0.0.2,3")
+
+	//A deleted entry is restored
+	for _, ip := range []string{"10.0.0.1", "2001::1"} {
+		neigh := netlink.Neigh{LinkIndex: 1, IP: net.ParseIP(ip)}
+		h.NeighDel(&neigh)
+		neighs <- static.NeighEvent{Neigh: neigh, Deleted: true}
+		h.waitFor(t, ip+",1")
+	}
+
+	//as are all entries of an interface after a flap
+	h.delLink("dp0s1")
+	h.addLink("dp0s1", 1, true)
+	links <- static.LinkEvent{Name: "dp0s1", Index: 1, Up: true}
+	h.waitFor(t, "2001::1,1")
+
+	arp.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	checkNeighs(t, h, "[10.0.0.1,1 00:00:00:00:00:01 128"+
+		" 10.0.0.2,3 00:00:00:00:00:02 128 2001::1,1 00:00:00:00:00:06 128]")
+
+	//Only entries which were installed are removed once unconfigured
+	h.NeighSet(&netlink.Neigh{LinkIndex: 1, IP: net.ParseIP("2001::9"),
+		HardwareAddr: stale, State: netlink.NUD_PERMANENT})
+	delete(entries, "2001::1,dp0s1")
+	delete(entries, "10.0.0.2,dp0s2")
+	if err := arp.Set(entries); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	checkNeighs(t, h, "[10.0.0.1,1 00:00:00:00:00:01 128"+
+		" 2001::9,1 00:00:00:00:00:09 128]")
+}
+
+func TestStaticArpRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arp")
+	if err != nil {
+		t.Fatalf("Failed to create repair dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	repair_file := filepath.Join(dir, "repairs.json")
+
+	h := newFakeNeighHandle()
+	h.addLink("dp0s1", 1, true)
+
+	entry := arpEntry("2001::1", "00:00:00:00:00:06", "dp0s1")
+	arp := static.NewStaticArpWithHandle(h, repair_file)
+	arp.Set(map[string]static.ArpEntry{"2001::1,dp0s1": entry})
+	checkNeighs(t, h, "[2001::1,1 00:00:00:00:00:06 128]")
+
+	//A restarted reconciler removes the entries its predecessor installed
+	arp = static.NewStaticArpWithHandle(h, repair_file)
+	if err := arp.Set(map[string]static.ArpEntry{}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	checkNeighs(t, h, "[]")
+
+	repairs, err := static.ReadNeighRepairs(repair_file)
+	if err != nil || len(repairs) != 0 {
+		t.Errorf("Unexpected repairs %v: %v", repairs, err)
+	}
+}
+
+func TestValidateNeighbours(t *testing.T) {
+	//As received by the static component, without the interfaces of
+	//each routing-instance
+	cfg := unmarshalConfig(t, []byte(`{
+		"protocols" : {
+			"static" : {
+				"arp" : [
+					{ "tagnode" : "10.0.0.1", "hwaddr" : "00:00:00:00:00:01",
+					  "interface" : "dp0s1" },
+					{ "tagnode" : "10.0.0.2", "hwaddr" : "00:00:00:00:00:02",
+					  "interface" : "dp0s2" }
+				]
+			}
+		},
+		"routing" : {
+			"routing-instance" : [
+				{
+					"instance-name" : "BLUE",
+					"protocols" : { "static" : {
+						"neighbor6" : [
+							{ "tagnode" : "fe80::1",
+							  "hwaddr" : "00:00:00:00:00:03",
+							  "interface" : "dp0s2" },
+							{ "tagnode" : "fe80::2",
+							  "hwaddr" : "00:00:00:00:00:05",
+							  "interface" : "dp0s3" }
+						]
+					} }
+				},
+				{
+					"instance-name" : "RED",
+					"protocols" : { "static" : {
+						"arp" : [
+							{ "tagnode" : "10.0.0.1",
+							  "hwaddr" : "00:00:00:00:00:01",
+							  "interface" : "dp0s1" },
+							{ "tagnode" : "10.0.0.2",
+							  "hwaddr" : "00:00:00:00:00:04",
+							  "interface" : "dp0s2" }
+						]
+					} }
+				}
+			]
+		}
+	}`))
+
+	err := static.ValidateNeighbours(cfg)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	conflict := "[routing routing-instance RED protocols static arp 10.0.0.2]\n" +
+		"Conflicts with [protocols static arp 10.0.0.2]: 10.0.0.2 on " +
+		"interface dp0s2 has hwaddr 00:00:00:00:00:02"
+	if !strings.Contains(err.Error(), conflict) {
+		t.Errorf("Error does not contain %q:\n%s", conflict, err)
+	}
+	if strings.Contains(err.Error(), "Conflicts with [protocols static arp 10.0.0.1]") {
+		t.Errorf("Same hwaddr reported as a conflict:\n%s", err)
+	}
+	if strings.Contains(err.Error(), "does not belong") {
+		t.Errorf("Interface checked without its routing-instance:\n%s", err)
+	}
+
+	//The routing-instance interfaces of the candidate configuration
+	if_ri := static.ConfiguredInterfaceRoutingInstances(unmarshalConfig(t,
+		[]byte(`{
+		"routing" : {
+			"routing-instance" : [
+				{
+					"instance-name" : "BLUE",
+					"interface" : [
+						{ "name" : "dp0s2" },
+						{ "name" : "dp0s3" }
+					]
+				},
+				{ "instance-name" : "RED" }
+			]
+		}
+	}`)))
+
+	expected := []string{
+		"[protocols static arp 10.0.0.2]\n" +
+			"Interface dp0s2 does not belong to routing-instance default",
+		"[routing routing-instance RED protocols static arp 10.0.0.1]\n" +
+			"Interface dp0s1 does not belong to routing-instance RED",
+		"[routing routing-instance RED protocols static arp 10.0.0.2]\n" +
+			"Interface dp0s2 does not belong to routing-instance RED",
+	}
+
+	err = static.ValidateNeighbourInterfaces(cfg, if_ri)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	for _, exp := range expected {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("Error does not contain %q:\n%s", exp, err)
+		}
+	}
+	if strings.Contains(err.Error(), "BLUE") {
+		t.Errorf("Unexpected error for BLUE:\n%s", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"github.com/vishvananda/netlink"
+	"net"
+	"time"
+)
+
+/* States of a static neighbour with respect to the kernel */
+const (
+	NEIGH_PROGRAMMED     = "programmed"
+	NEIGH_MISSING        = "missing"
+	NEIGH_MISMATCHED_MAC = "mismatched-mac"
+	NEIGH_INTERFACE_DOWN = "interface-down"
+)
+
+/* Names of the kernel neighbour states */
+var neighStateNames = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_PERMANENT, "permanent"},
+	{netlink.NUD_NOARP, "noarp"},
+	{netlink.NUD_REACHABLE, "reachable"},
+	{netlink.NUD_STALE, "stale"},
+	{netlink.NUD_DELAY, "delay"},
+	{netlink.NUD_PROBE, "probe"},
+	{netlink.NUD_INCOMPLETE, "incomplete"},
+	{netlink.NUD_FAILED, "failed"},
+}
+
+func neighStateName(state int) string {
+	for _, s := range neighStateNames {
+		if state&s.state != 0 {
+			return s.name
+		}
+	}
+	return "none"
+}
+
+/*
+ * The state of a configured static ARP or IPv6 neighbour entry
+ * compared with the kernel neighbour table
+ */
+type NeighStatus struct {
+	RoutingInstance string `rfc7951:"routing-instance"`
+	Address         string `rfc7951:"address"`
+	Interface       string `rfc7951:"interface"`
+	HwAddr          string `rfc7951:"hwaddr"`
+	State           string `rfc7951:"state"`
+	// The kernel entry for the address and interface, if any
+	KernelHwAddr string `rfc7951:"kernel-hwaddr,omitempty"`
+	KernelState  string `rfc7951:"kernel-state,omitempty"`
+	// When the reconciler last installed the entry, in RFC 3339 format
+	LastRepair string `rfc7951:"last-repair,omitempty"`
+}
+
+/*
+ * Returns the status of each static neighbour of the translated
+ * configuration cfg, given the kernel state available through handle
+ * and the repair times recorded by the reconciler. An entry is
+ * programmed only if the kernel holds it as a permanent entry with the
+ * configured hwaddr, as an entry learned dynamically may be replaced.
+ */
+func GetNeighStatus(cfg map[string]interface{}, handle NeighHandle,
+	repairs map[string]time.Time) ([]NeighStatus, error) {
+	kernel, err := kernelNeighs(handle, false)
+	if err != nil {
+		return nil, err
+	}
+
+	//Invalid entries are rejected at commit
+	entries, _ := neighConfig(cfg)
+
+	statuses := make([]NeighStatus, 0, len(entries))
+	for _, entry := range entries {
+		status := NeighStatus{
+			RoutingInstance: entry.RoutingInstance,
+			Address:         entry.IP.String(),
+			Interface:       entry.Interface,
+			HwAddr:          entry.HwAddr.String(),
+		}
+		if t, ok := repairs[entry.key()]; ok {
+			status.LastRepair = t.Format(time.RFC3339)
+		}
+
+		kn, in_kernel := kernel[entry.key()]
+		if in_kernel {
+			status.KernelHwAddr = kn.neigh.HardwareAddr.String()
+			status.KernelState = neighStateName(kn.neigh.State)
+		}
+
+		link, err := handle.LinkByName(entry.Interface)
+		switch {
+		case err != nil || link.Attrs().Flags&net.FlagUp == 0:
+			status.State = NEIGH_INTERFACE_DOWN
+		case !in_kernel || len(kn.neigh.HardwareAddr) == 0:
+			status.State = NEIGH_MISSING
+		case status.KernelHwAddr != status.HwAddr:
+			status.State = NEIGH_MISMATCHED_MAC
+		case kn.neigh.State&netlink.NUD_PERMANENT == 0:
+			status.State = NEIGH_MISSING
+		default:
+			status.State = NEIGH_PROGRAMMED
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
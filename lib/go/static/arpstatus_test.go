@@ -0,0 +1,113 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetNeighStatus(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+		"protocols" : {
+			"static" : {
+				"arp" : [
+					{ "tagnode" : "10.0.0.1", "hwaddr" : "00:00:00:00:00:01",
+					  "interface" : "dp0s1" },
+					{ "tagnode" : "10.0.0.2", "hwaddr" : "00:00:00:00:00:02",
+					  "interface" : "dp0s1" },
+					{ "tagnode" : "10.0.0.3", "hwaddr" : "00:00:00:00:00:03",
+					  "interface" : "dp0s1" },
+					{ "tagnode" : "10.0.0.4", "hwaddr" : "00:00:00:00:00:04",
+					  "interface" : "dp0s2" }
+				]
+			}
+		},
+		"routing" : {
+			"routing-instance" : [ {
+				"instance-name" : "RED",
+				"protocols" : { "static" : {
+					"neighbor6" : [
+						{ "tagnode" : "2001::1",
+						  "hwaddr" : "00:00:00:00:00:05",
+						  "interface" : "dp0s1" }
+					]
+				} }
+			} ]
+		}
+	}`))
+
+	dir, err := ioutil.TempDir("", "arp")
+	if err != nil {
+		t.Fatalf("Failed to create repair dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	repair_file := filepath.Join(dir, "repairs.json")
+
+	h := newFakeNeighHandle()
+	h.addLink("dp0s1", 1, true)
+	h.addLink("dp0s2", 2, false)
+
+	entries, _ := static.ArpConfig(cfg)
+	delete(entries, "10.0.0.2,dp0s1")
+	delete(entries, "10.0.0.3,dp0s1")
+	arp := static.NewStaticArpWithHandle(h, repair_file)
+	arp.Set(entries)
+
+	//Dynamically learned entry, possibly with another hwaddr
+	for _, neigh := range []netlink.Neigh{
+		{LinkIndex: 1, IP: net.ParseIP("10.0.0.2"), State: netlink.NUD_REACHABLE,
+			HardwareAddr: net.HardwareAddr{0, 0, 0, 0, 0, 2}},
+		{LinkIndex: 1, IP: net.ParseIP("10.0.0.3"), State: netlink.NUD_STALE,
+			HardwareAddr: net.HardwareAddr{0, 0, 0, 0, 0, 9}},
+	} {
+		h.NeighSet(&neigh)
+	}
+
+	repairs, err := static.ReadNeighRepairs(repair_file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(repairs) != 3 || repairs["10.0.0.1,dp0s1"].IsZero() ||
+		!repairs["2001::1,dp0s1"].Equal(arp.Repairs()["2001::1,dp0s1"]) {
+		t.Errorf("Unexpected repairs %v", repairs)
+	}
+
+	statuses, err := static.GetNeighStatus(cfg, h, repairs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []string{
+		"default 10.0.0.1 dp0s1 programmed 00:00:00:00:00:01 permanent true",
+		"default 10.0.0.2 dp0s1 missing 00:00:00:00:00:02 reachable false",
+		"default 10.0.0.3 dp0s1 mismatched-mac 00:00:00:00:00:09 stale false",
+		"default 10.0.0.4 dp0s2 interface-down 00:00:00:00:00:04 permanent true",
+		"RED 2001::1 dp0s1 programmed 00:00:00:00:00:05 permanent true",
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("Unexpected statuses %v", statuses)
+	}
+	for i, status := range statuses {
+		got := fmt.Sprintf("%s %s %s %s %s %s %t", status.RoutingInstance,
+			status.Address, status.Interface, status.State,
+			status.KernelHwAddr, status.KernelState, status.LastRepair != "")
+		if got != expected[i] {
+			t.Errorf("Unexpected status\nexpected: %s\ngot: %s", expected[i], got)
+		}
+		if status.LastRepair != "" {
+			if _, err := time.Parse(time.RFC3339, status.LastRepair); err != nil {
+				t.Errorf("Bad repair time: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+/*
+ * Kernel VRF master devices are named after the routing-instance
+ * with this prefix.
+ */
+const vrfDevicePrefix = "vrf"
+
+type ipLink struct {
+	Ifname   string   `json:"ifname"`
+	Master   string   `json:"master"`
+	Flags    []string `json:"flags"`
+	Linkinfo struct {
+		InfoKind string `json:"info_kind"`
+	} `json:"linkinfo"`
+}
+
+type ipAddr struct {
+	Ifname   string `json:"ifname"`
+	AddrInfo []struct {
+		Local     string `json:"local"`
+		Prefixlen int    `json:"prefixlen"`
+	} `json:"addr_info"`
+}
+
+func runIpJson(obj interface{}, args ...string) error {
+	out, err := exec.Command("/sbin/ip", append([]string{"-j"}, args...)...).Output()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(out, obj)
+}
+
+/*
+ * Returns the routing-instance which the interface with the given
+ * master device belongs to.
+ */
+func masterRoutingInstance(master string) string {
+	if strings.HasPrefix(master, vrfDevicePrefix) {
+		return strings.TrimPrefix(master, vrfDevicePrefix)
+	}
+
+	return "default"
+}
+
+/*
+ * Calls fn for each address of each interface, other than VRF master
+ * devices, with the routing-instance the interface belongs to and whether
+ * the interface is up.
+ */
+func forEachAddress(fn func(ri, ifname string, up bool, ip net.IP,
+	prefixlen int)) error {
+	var links []ipLink
+	var addrs []ipAddr
+
+	err := runIpJson(&links, "-d", "link", "show")
+	if err != nil {
+		return err
+	}
+
+	err = runIpJson(&addrs, "addr", "show")
+	if err != nil {
+		return err
+	}
+
+	link_map := make(map[string]ipLink)
+	for _, link := range links {
+		link_map[link.Ifname] = link
+	}
+
+	for _, addr := range addrs {
+		link, ok := link_map[addr.Ifname]
+		if !ok || link.Linkinfo.InfoKind == "vrf" {
+			continue
+		}
+
+		up := false
+		for _, flag := range link.Flags {
+			if flag == "UP" {
+				up = true
+			}
+		}
+
+		for _, info := range addr.AddrInfo {
+			ip := net.ParseIP(info.Local)
+			if ip == nil {
+				continue
+			}
+			if ip.To4() != nil {
+				ip = ip.To4()
+			}
+			fn(masterRoutingInstance(link.Master), addr.Ifname, up,
+				ip, info.Prefixlen)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns a snapshot of the connected routes of all routing-instances,
+ * derived from the addresses of each interface which is up.
+ */
+func GetConnectedRoutes() ([]ConnectedRoute, error) {
+	var connected []ConnectedRoute
+
+	err := forEachAddress(func(ri, ifname string, up bool, ip net.IP,
+		prefixlen int) {
+		if !up {
+			return
+		}
+
+		bits := 8 * len(ip)
+		prefix := net.IPNet{
+			IP:   ip.Mask(net.CIDRMask(prefixlen, bits)),
+			Mask: net.CIDRMask(prefixlen, bits),
+		}
+		connected = append(connected, ConnectedRoute{
+			RoutingInstance: ri,
+			Prefix:          prefix.String(),
+			Interface:       ifname,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connected, nil
+}
+
+/*
+ * Adds the addresses presently on the interfaces ifnames to local, keyed
+ * by the routing-instance each interface belongs to
+ */
+func AddInterfaceAddresses(local map[string][]net.IP, ifnames []string) error {
+	wanted := make(map[string]bool)
+	for _, ifname := range ifnames {
+		wanted[ifname] = true
+	}
+
+	return forEachAddress(func(ri, ifname string, up bool, ip net.IP,
+		prefixlen int) {
+		if wanted[ifname] {
+			local[ri] = append(local[ri], ip)
+		}
+	})
+}
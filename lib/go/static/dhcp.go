@@ -0,0 +1,247 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"bufio"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Directory holding the lease file of each DHCP client */
+const dhcpLeaseDir = "/var/lib/dhcp"
+
+/* Interval at which lease files are checked for changes */
+const dhcpPollInterval = 2 * time.Second
+
+/* Layout of the expiry time of a lease, which is always in UTC */
+const dhcpExpireLayout = "2006/01/02 15:04:05"
+
+/*
+ * DhcpGatewayWatcher resolves the dhcp-interface next-hops of static
+ * routes to the gateway most recently learned by the DHCP client on
+ * each interface, as recorded in its lease file.
+ *
+ * Before a configuration is translated, Resolve() should be called to
+ * replace each dhcp-interface with a next-hop via its current gateway.
+ * Run() watches the lease files, invoking the changed callback whenever
+ * a gateway is learned, changes or is lost, at which point the
+ * configuration should be resolved, translated and sent to the daemon
+ * again.
+ */
+type DhcpGatewayWatcher struct {
+	lock         sync.Mutex
+	leaseDir     string
+	pollInterval time.Duration
+	changed      func()
+	gateways     map[string]net.IP
+	stop         chan struct{}
+}
+
+func NewDhcpGatewayWatcher(changed func()) *DhcpGatewayWatcher {
+	return NewDhcpGatewayWatcherWithDir(dhcpLeaseDir, dhcpPollInterval,
+		changed)
+}
+
+func NewDhcpGatewayWatcherWithDir(leaseDir string, pollInterval time.Duration,
+	changed func()) *DhcpGatewayWatcher {
+	w := &DhcpGatewayWatcher{
+		leaseDir:     leaseDir,
+		pollInterval: pollInterval,
+		changed:      changed,
+		gateways:     make(map[string]net.IP),
+		stop:         make(chan struct{}),
+	}
+	w.Refresh()
+	return w
+}
+
+/*
+ * Returns the gateway of the current lease in lease file path, along with
+ * the interface it was obtained on, or nil if there is no unexpired lease
+ * with a router.
+ */
+func readDhcpLease(path string, now time.Time) (string, net.IP) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer file.Close()
+
+	var ifname string
+	var gateway net.IP
+	var expired bool
+
+	//The client appends each new lease, so the last one is current
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimSuffix(
+			strings.TrimSpace(scanner.Text()), ";"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "lease":
+			ifname, gateway, expired = "", nil, false
+		case fields[0] == "interface" && len(fields) > 1:
+			ifname = strings.Trim(fields[1], "\"")
+		case fields[0] == "option" && len(fields) > 2 &&
+			fields[1] == "routers":
+			//Multiple routers are comma separated, the first preferred
+			gateway = net.ParseIP(strings.Split(fields[2], ",")[0])
+		case fields[0] == "expire" && len(fields) > 3:
+			expire, err := time.Parse(dhcpExpireLayout,
+				fields[2]+" "+fields[3])
+			expired = err == nil && !expire.After(now)
+		}
+	}
+
+	if expired || gateway.To4() == nil {
+		return ifname, nil
+	}
+	return ifname, gateway
+}
+
+/*
+ * Rereads the lease files, returning whether any gateway has changed
+ */
+func (w *DhcpGatewayWatcher) Refresh() bool {
+	gateways := make(map[string]net.IP)
+
+	leases, _ := filepath.Glob(filepath.Join(w.leaseDir, "dhclient_*.leases"))
+	now := time.Now().UTC()
+	for _, lease := range leases {
+		ifname, gateway := readDhcpLease(lease, now)
+		if ifname == "" {
+			ifname = strings.TrimSuffix(strings.TrimPrefix(
+				filepath.Base(lease), "dhclient_"), ".leases")
+		}
+		if gateway != nil {
+			gateways[ifname] = gateway
+		}
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	changed := len(gateways) != len(w.gateways)
+	for ifname, gateway := range gateways {
+		if !gateway.Equal(w.gateways[ifname]) {
+			log.Infof("DHCP gateway of %s is now %s", ifname, gateway)
+			changed = true
+		}
+	}
+	for ifname, _ := range w.gateways {
+		if gateways[ifname] == nil {
+			log.Infof("DHCP gateway of %s lost", ifname)
+		}
+	}
+
+	w.gateways = gateways
+	return changed
+}
+
+/*
+ * Returns the gateway currently learned by DHCP on interface ifname,
+ * or nil if there is none
+ */
+func (w *DhcpGatewayWatcher) Gateway(ifname string) net.IP {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.gateways[ifname]
+}
+
+/*
+ * Watches the lease files until Stop() is called
+ */
+func (w *DhcpGatewayWatcher) Run() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if w.Refresh() && w.changed != nil {
+			w.changed()
+		}
+	}
+}
+
+func (w *DhcpGatewayWatcher) Stop() {
+	close(w.stop)
+}
+
+/*
+ * Replaces the dhcp-interface list of each route in the untranslated
+ * configuration frontend_map with next-hops via the current gateway of
+ * each enabled interface. Interfaces without a gateway are omitted, as
+ * are those whose gateway is already a configured next-hop of the route.
+ */
+func (w *DhcpGatewayWatcher) Resolve(frontend_map map[string]interface{}) {
+	containers, _ := staticContainers(frontend_map)
+
+	for _, static_map := range containers {
+		route_arr, _ := static_map["route"].([]interface{})
+		for _, route_entry := range route_arr {
+			route_map, ok := route_entry.(map[string]interface{})
+			if !ok || route_map["dhcp-interface"] == nil {
+				continue
+			}
+			w.resolveRoute(route_map)
+		}
+	}
+}
+
+func (w *DhcpGatewayWatcher) resolveRoute(route_map map[string]interface{}) {
+	nh_arr, _ := route_map["next-hop"].([]interface{})
+	configured := make(map[string]bool)
+	for _, nh_entry := range nh_arr {
+		if nh_map, ok := nh_entry.(map[string]interface{}); ok {
+			configured[nexthopKey(nh_map)] = true
+		}
+	}
+
+	dhcp_arr, _ := route_map["dhcp-interface"].([]interface{})
+	for _, dhcp_entry := range dhcp_arr {
+		dhcp_map, ok := dhcp_entry.(map[string]interface{})
+		if !ok || IsNexthopDisabled(dhcp_map) {
+			continue
+		}
+		ifname, _ := dhcp_map["tagnode"].(string)
+		gateway := w.Gateway(ifname)
+		if gateway == nil || configured[gateway.String()] {
+			continue
+		}
+		configured[gateway.String()] = true
+
+		nh_map := map[string]interface{}{
+			"tagnode":   gateway.String(),
+			"interface": ifname,
+		}
+		for _, attr := range [...]string{"distance", "tag", "weight"} {
+			if dhcp_map[attr] != nil {
+				nh_map[attr] = dhcp_map[attr]
+			}
+		}
+		nh_arr = append(nh_arr, nh_map)
+	}
+
+	if len(nh_arr) > 0 {
+		route_map["next-hop"] = nh_arr
+	}
+	delete(route_map, "dhcp-interface")
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLease(t *testing.T, dir, ifname, router, expire string) {
+	lease := `lease {
+  interface "` + ifname + `";
+  fixed-address 192.0.2.10;
+  option subnet-mask 255.255.255.0;
+  option routers 192.0.2.254;
+  expire 1 2001/01/01 00:00:00;
+}
+lease {
+  interface "` + ifname + `";
+  fixed-address 192.0.2.10;
+  option subnet-mask 255.255.255.0;
+  option routers ` + router + `,192.0.2.253;
+  renew 1 2001/01/01 00:00:00;
+  expire ` + expire + `;
+}
+`
+	err := ioutil.WriteFile(filepath.Join(dir, "dhclient_"+ifname+".leases"),
+		[]byte(lease), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write lease: %s", err)
+	}
+}
+
+func TestDhcpGatewayWatcher(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "0.0.0.0/0",
+               "dhcp-interface" : [
+                  { "tagnode" : "dp0p1", "distance" : 5 },
+                  { "tagnode" : "dp0p2" },
+                  { "tagnode" : "dp0p3" },
+                  { "tagnode" : "dp0p4", "disable" : null }
+               ]
+            },
+            {
+               "tagnode" : "10.0.0.0/8",
+               "dhcp-interface" : [ { "tagnode" : "dp0p2" } ]
+            }
+         ]
+      }
+   }
+}`)
+
+	dir, err := ioutil.TempDir("", "dhcp")
+	if err != nil {
+		t.Fatalf("Failed to create lease dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeLease(t, dir, "dp0p1", "192.0.2.1", "never")
+	writeLease(t, dir, "dp0p2", "192.0.2.2", "1 2001/01/01 00:00:00")
+	writeLease(t, dir, "dp0p4", "192.0.2.4", "never")
+
+	changed := make(chan struct{}, 1)
+	w := static.NewDhcpGatewayWatcherWithDir(dir, 5*time.Millisecond,
+		func() { changed <- struct{}{} })
+
+	if gw := w.Gateway("dp0p2"); gw != nil {
+		t.Errorf("Unexpected gateway %s from expired lease", gw)
+	}
+
+	cfg := unmarshalConfig(t, input_json)
+	w.Resolve(cfg)
+	err = static.Translate(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	compareConfig(t, cfg, []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "next-hop" : [
+                  {
+                     "distance" : 5,
+                     "interface" : "dp0p1",
+                     "tagnode" : "192.0.2.1"
+                  }
+               ],
+               "tagnode" : "0.0.0.0/0"
+            }
+         ]
+      }
+   }
+}`))
+
+	if w.Refresh() {
+		t.Error("Unexpected change without lease changes")
+	}
+
+	go w.Run()
+	defer w.Stop()
+
+	writeLease(t, dir, "dp0p1", "192.0.2.5", "never")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for gateway change")
+	}
+
+	if gw := w.Gateway("dp0p1"); gw.String() != "192.0.2.5" {
+		t.Errorf("Unexpected gateway %s", gw)
+	}
+}
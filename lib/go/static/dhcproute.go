@@ -0,0 +1,216 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/* Protocol of the routes installed by DHCP clients, as known to ribd */
+const DHCP_ROUTE_PROTO = "dhcp"
+
+/*
+ * The installation policy of the routes learned by the DHCP client
+ * on an interface, as configured by its dhcp-client entry
+ */
+type DhcpRoutePolicy struct {
+	RoutingInstance string
+	Distance        uint32
+	// Kernel table to install routes in
+	Table          int
+	NoDefaultRoute bool
+}
+
+/*
+ * Returns the kernel table of PBR table pbr_table of routing-instance ri,
+ * or of the routing-instance itself if pbr_table is 0.
+ */
+type DhcpTableLookup func(ri string, pbr_table uint32) (int, error)
+
+/*
+ * Returns the policy for the DHCP client on interface ifname, which
+ * belongs to routing-instance ri, given the translated configuration cfg.
+ * Interfaces without a dhcp-client entry have the default policy.
+ */
+func NewDhcpRoutePolicy(cfg map[string]interface{}, ifname, ri string,
+	lookupTable DhcpTableLookup) (*DhcpRoutePolicy, error) {
+	policy := &DhcpRoutePolicy{RoutingInstance: ri, Distance: 1}
+
+	var client_map map[string]interface{}
+	containers, _ := staticContainers(cfg)
+	client_arr, _ := containers[ri]["dhcp-client"].([]interface{})
+	for _, client_entry := range client_arr {
+		entry_map, ok := client_entry.(map[string]interface{})
+		if ok && entry_map["tagnode"] == ifname {
+			client_map = entry_map
+			break
+		}
+	}
+
+	var pbr_table uint32
+	if client_map != nil {
+		policy.Distance = uintValue(client_map["distance"], 1)
+		pbr_table = uintValue(client_map["table"], 0)
+		_, policy.NoDefaultRoute = client_map["no-default-route"]
+	}
+
+	table, err := lookupTable(ri, pbr_table)
+	if err != nil {
+		return nil, fmt.Errorf("No table for %s: %s", ifname, err)
+	}
+	policy.Table = table
+
+	return policy, nil
+}
+
+/*
+ * Looks up tables in the running system, VRF tables via netlink
+ * and PBR tables via the table registry
+ */
+func systemDhcpTable(ri string, pbr_table uint32) (int, error) {
+	if pbr_table == 0 {
+		return routingInstanceTable(&netlink.Handle{}, ri)
+	}
+
+	kernel_table, err := tableRegistry.KernelTable(ri, pbr_table)
+	return int(kernel_table), err
+}
+
+/*
+ * Returns the routing-instance which interface ifname belongs to
+ */
+func InterfaceRoutingInstance(ifname string) (string, error) {
+	var links []ipLink
+
+	err := runIpJson(&links, "link", "show", "dev", ifname)
+	if err != nil {
+		return "", err
+	}
+	if len(links) == 0 {
+		return "", fmt.Errorf("Interface %s not found", ifname)
+	}
+
+	return masterRoutingInstance(links[0].Master), nil
+}
+
+/*
+ * Returns the policy for the DHCP client on interface ifname in the
+ * running system, given the translated configuration cfg
+ */
+func GetDhcpRoutePolicy(cfg map[string]interface{}, ifname string) (*DhcpRoutePolicy, error) {
+	ri, err := InterfaceRoutingInstance(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDhcpRoutePolicy(cfg, ifname, ri, systemDhcpTable)
+}
+
+/* ip route commands rewritten by a DhcpRoutePolicy */
+var dhcpRouteCommands = map[string]bool{
+	"add":     true,
+	"append":  true,
+	"change":  true,
+	"del":     true,
+	"delete":  true,
+	"replace": true,
+}
+
+/* ip route options overridden by a DhcpRoutePolicy */
+var dhcpRouteOptions = map[string]bool{
+	"metric":     true,
+	"preference": true,
+	"priority":   true,
+	"proto":      true,
+	"protocol":   true,
+	"table":      true,
+}
+
+/*
+ * Returns the index of the route command in the ip arguments args,
+ * or -1 if args are not an ip route command rewritten by a policy
+ */
+func ipRouteCommand(args []string) int {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if arg == "route" && i+1 < len(args) && dhcpRouteCommands[args[i+1]] {
+			return i + 1
+		}
+		return -1
+	}
+
+	return -1
+}
+
+/*
+ * Returns the device of the ip route command in args,
+ * or "" if there is none
+ */
+func IpRouteDevice(args []string) string {
+	cmd := ipRouteCommand(args)
+	if cmd < 0 {
+		return ""
+	}
+
+	for i := cmd + 1; i+1 < len(args); i++ {
+		if args[i] == "dev" || args[i] == "oif" {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+/*
+ * Returns the ip arguments args of a DHCP client's route command with
+ * the protocol, metric and table set according to the policy, or nil if
+ * the route is not to be installed. Arguments other than a route command
+ * are returned unchanged.
+ */
+func (p *DhcpRoutePolicy) RewriteIpArgs(args []string) []string {
+	cmd := ipRouteCommand(args)
+	if cmd < 0 {
+		return args
+	}
+
+	ret := append([]string{}, args[:cmd+1]...)
+	var prefix string
+
+	for i := cmd + 1; i < len(args); i++ {
+		switch {
+		case dhcpRouteOptions[args[i]] && i+1 < len(args):
+			i++
+		case args[i] == "to" && i+1 < len(args) && prefix == "":
+			prefix = args[i+1]
+			ret = append(ret, args[i], args[i+1])
+			i++
+		default:
+			if prefix == "" {
+				prefix = args[i]
+			}
+			ret = append(ret, args[i])
+		}
+	}
+
+	if p.NoDefaultRoute && (prefix == "default" || prefix == "0.0.0.0/0" ||
+		prefix == "0/0") {
+		return nil
+	}
+
+	ret = append(ret, "proto", DHCP_ROUTE_PROTO,
+		"metric", strconv.FormatUint(uint64(p.Distance), 10))
+	if p.Table != 0 && p.Table != syscall.RT_TABLE_MAIN {
+		ret = append(ret, "table", strconv.Itoa(p.Table))
+	}
+
+	return ret
+}
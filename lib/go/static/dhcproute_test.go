@@ -0,0 +1,125 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testDhcpTable(ri string, pbr_table uint32) (int, error) {
+	if pbr_table != 0 {
+		return 300 + int(pbr_table), nil
+	}
+	if ri == "RED" {
+		return 1000, nil
+	}
+	return 254, nil
+}
+
+func TestDhcpRoutePolicy(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "dhcp-client" : [
+            { "tagnode" : "dp0p1", "distance" : 10, "no-default-route" : null },
+            { "tagnode" : "dp0p2", "table" : 5 }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "dhcp-client" : [ { "tagnode" : "dp0p3", "distance" : 20 } ]
+               }
+            }
+         }
+      ]
+   }
+}`))
+
+	tests := []struct {
+		ifname   string
+		ri       string
+		args     string
+		expected string
+	}{
+		{
+			ifname:   "dp0p1",
+			ri:       "default",
+			args:     "-4 route add default via 192.0.2.1 dev dp0p1",
+			expected: "",
+		},
+		{
+			ifname: "dp0p1",
+			ri:     "default",
+			args:   "-4 route add 198.51.100.0/24 via 192.0.2.1 dev dp0p1 metric 5",
+			expected: "-4 route add 198.51.100.0/24 via 192.0.2.1 dev dp0p1" +
+				" proto dhcp metric 10",
+		},
+		{
+			ifname: "dp0p2",
+			ri:     "default",
+			args:   "route add default via 192.0.2.1 dev dp0p2",
+			expected: "route add default via 192.0.2.1 dev dp0p2" +
+				" proto dhcp metric 1 table 305",
+		},
+		{
+			ifname: "dp0p3",
+			ri:     "RED",
+			args:   "-4 route del to default via 192.0.2.1 dev dp0p3",
+			expected: "-4 route del to default via 192.0.2.1 dev dp0p3" +
+				" proto dhcp metric 20 table 1000",
+		},
+		{
+			ifname: "dp0p4",
+			ri:     "default",
+			args:   "-4 route add default via 192.0.2.1 dev dp0p4 table 10",
+			expected: "-4 route add default via 192.0.2.1 dev dp0p4" +
+				" proto dhcp metric 1",
+		},
+		{
+			ifname:   "dp0p4",
+			ri:       "default",
+			args:     "-4 addr add 192.0.2.10/24 dev dp0p4",
+			expected: "-4 addr add 192.0.2.10/24 dev dp0p4",
+		},
+	}
+
+	for _, test := range tests {
+		args := strings.Fields(test.args)
+		if test.expected != test.args {
+			if dev := static.IpRouteDevice(args); dev != test.ifname {
+				t.Errorf("%s: unexpected device %q", test.args, dev)
+			}
+		}
+
+		policy, err := static.NewDhcpRoutePolicy(cfg, test.ifname, test.ri,
+			testDhcpTable)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.args, err)
+			continue
+		}
+
+		got := strings.Join(policy.RewriteIpArgs(args), " ")
+		if got != test.expected {
+			t.Errorf("Unexpected arguments for %s\nexpected: %s\ngot: %s",
+				test.args, test.expected, got)
+		}
+	}
+
+	_, err := static.NewDhcpRoutePolicy(cfg, "dp0p5", "BLUE",
+		func(ri string, pbr_table uint32) (int, error) {
+			return 0, fmt.Errorf("Link not found")
+		})
+	if err == nil || err.Error() != "No table for dp0p5: Link not found" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,521 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"eng.vyatta.net/protocols"
+	"fmt"
+	multierr "github.com/hashicorp/go-multierror"
+	"net"
+	"sort"
+)
+
+/* Effective route types */
+const (
+	FIB_UNICAST     = "unicast"
+	FIB_BLACKHOLE   = "blackhole"
+	FIB_UNREACHABLE = "unreachable"
+	FIB_PROHIBIT    = "prohibit"
+	FIB_THROW       = "throw"
+)
+
+/* Route types configured without next-hops */
+var specialRouteTypes = [...]string{FIB_BLACKHOLE, FIB_UNREACHABLE,
+	FIB_PROHIBIT, FIB_THROW}
+
+/* Maximum depth of recursive next-hop resolution */
+const fibMaxDepth = 8
+
+/*
+ * A connected route present in the kernel of routing-instance
+ * RoutingInstance, as used to resolve static next-hops.
+ */
+type ConnectedRoute struct {
+	RoutingInstance string `json:"routing-instance"`
+	Prefix          string `json:"prefix"`
+	Interface       string `json:"interface"`
+}
+
+/*
+ * A resolved next-hop of an effective static route. RoutingInstance is
+ * only set when the next-hop is leaked from another routing-instance.
+ */
+type FibNexthop struct {
+	Address         string `rfc7951:"address,omitempty"`
+	Interface       string `rfc7951:"interface,omitempty"`
+	RoutingInstance string `rfc7951:"routing-instance,omitempty"`
+	Label           string `rfc7951:"label,omitempty"`
+	Weight          uint32 `rfc7951:"weight,omitempty"`
+}
+
+/*
+ * A static route which would be installed in the given routing-instance
+ * and PBR table, as configured. Table is zero for the main table of the
+ * routing-instance. KernelTable is the kernel table the PBR table maps
+ * to, where known, and is not set by ComputeFib.
+ */
+type FibRoute struct {
+	RoutingInstance string       `rfc7951:"routing-instance"`
+	Table           uint32       `rfc7951:"table,omitempty"`
+	KernelTable     uint32       `rfc7951:"kernel-table,omitempty"`
+	Prefix          string       `rfc7951:"prefix"`
+	Type            string       `rfc7951:"type"`
+	Distance        uint32       `rfc7951:"distance"`
+	Tag             uint32       `rfc7951:"tag,omitempty"`
+	Source          string       `rfc7951:"source,omitempty"`
+	Nexthops        []FibNexthop `rfc7951:"next-hop,omitempty"`
+}
+
+type fibKey struct {
+	ri    string
+	table uint32
+}
+
+/*
+ * A single configured path to a prefix. A nil gateway indicates
+ * an interface route.
+ */
+type fibPath struct {
+	kind      string
+	distance  uint32
+	tag       uint32
+	weight    uint32
+	source    string
+	label     string
+	gateway   net.IP
+	iface     string
+	lookup_ri string
+}
+
+type fibPrefix struct {
+	prefix   *net.IPNet
+	paths    []fibPath
+	visiting bool
+	done     bool
+	result   *FibRoute
+}
+
+type fibTable map[string]*fibPrefix
+
+type fibConnected struct {
+	prefix *net.IPNet
+	iface  string
+}
+
+type fib struct {
+	tables    map[fibKey]fibTable
+	connected map[string][]fibConnected
+	//Number of times resolution has been cut short by a cycle or depth
+	cuts int
+}
+
+/*
+ * Returns v, a JSON number or string, as a uint32 or def if it is absent
+ */
+func uintValue(v interface{}, def uint32) uint32 {
+	switch n := v.(type) {
+	case float64:
+		return uint32(n)
+	case string:
+		var ret uint32
+		if _, err := fmt.Sscan(n, &ret); err == nil {
+			return ret
+		}
+	}
+
+	return def
+}
+
+func (f *fib) table(key fibKey) fibTable {
+	if f.tables[key] == nil {
+		f.tables[key] = make(fibTable)
+	}
+	return f.tables[key]
+}
+
+func (f *fib) addPath(key fibKey, prefix *net.IPNet, path fibPath) {
+	tbl := f.table(key)
+	p := tbl[prefix.String()]
+	if p == nil {
+		p = &fibPrefix{prefix: prefix}
+		tbl[prefix.String()] = p
+	}
+	p.paths = append(p.paths, path)
+}
+
+/*
+ * Adds a path for each enabled next-hop in list info.nexthop of pmap
+ */
+func (f *fib) addNexthops(key fibKey, prefix *net.IPNet,
+	pmap map[string]interface{}, info routeListInfo, lookup_ri, source string) {
+	nh_arr, _ := pmap[info.nexthop].([]interface{})
+
+	for _, nh_entry := range nh_arr {
+		nh_map, ok := nh_entry.(map[string]interface{})
+		if !ok || IsNexthopDisabled(nh_map) {
+			continue
+		}
+
+		path := fibPath{
+			kind:      FIB_UNICAST,
+			distance:  uintValue(nh_map["distance"], 1),
+			tag:       uintValue(nh_map["tag"], 0),
+			weight:    uintValue(nh_map["weight"], 0),
+			source:    source,
+			lookup_ri: lookup_ri,
+		}
+
+		if info.nexthop == "next-hop" {
+			path.gateway = net.ParseIP(nexthopKey(nh_map))
+			if path.gateway == nil {
+				continue
+			}
+			if iface, ok := nh_map["interface"].(string); ok {
+				path.iface = iface
+			}
+			if labels, err := LabelStack(nh_map["label"]); err == nil {
+				path.label = FormatLabelStack(labels)
+			}
+		} else {
+			path.iface = nexthopKey(nh_map)
+		}
+
+		f.addPath(key, prefix, path)
+	}
+}
+
+/*
+ * Adds the routes of each route list found in a static container or table
+ */
+func (f *fib) addRouteLists(key fibKey, pmap map[string]interface{},
+	path string) error {
+	ret_err := protocols.NewMultiError()
+
+	for _, info := range routeLists {
+		route_arr, err := getList(pmap, info.key, path)
+		ret_err = multierr.Append(ret_err, err)
+
+		for _, route_entry := range route_arr {
+			route_map, ok := route_entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			tagnode := fmt.Sprint(route_map["tagnode"])
+			_, prefix, err := net.ParseCIDR(tagnode)
+			if err != nil {
+				ret_err = multierr.Append(ret_err, pathError(
+					EntryPath(path, info.key, tagnode),
+					"Invalid prefix: %s", err))
+				continue
+			}
+
+			source, _ := route_map["source"].(string)
+			f.addNexthops(key, prefix, route_map, info, key.ri, source)
+
+			for _, inst_key := range [...]string{"next-hop-routing-instance",
+				"next-hop-routing-instance-v6"} {
+				inst_arr, _ := route_map[inst_key].([]interface{})
+				for _, inst_entry := range inst_arr {
+					inst_map, ok := inst_entry.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					f.addNexthops(key, prefix, inst_map, info,
+						fmt.Sprint(inst_map["routing-instance"]), source)
+				}
+			}
+
+			for _, kind := range specialRouteTypes {
+				if _, exists := route_map[kind]; !exists {
+					continue
+				}
+				discard_map, _ := route_map[kind].(map[string]interface{})
+				f.addPath(key, prefix, fibPath{
+					kind:     kind,
+					distance: uintValue(discard_map["distance"], 1),
+					tag:      uintValue(discard_map["tag"], 0),
+				})
+			}
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the effective route for prefix p in table key, or nil if none
+ * of its paths resolve.
+ *
+ * A result is only memoised if resolution was not cut short while it was
+ * computed, as it would otherwise depend on the order in which prefixes
+ * are visited.
+ */
+func (f *fib) selectRoute(key fibKey, p *fibPrefix, depth int) *FibRoute {
+	if p.done {
+		return p.result
+	}
+	if p.visiting || depth > fibMaxDepth {
+		f.cuts++
+		return nil
+	}
+	cuts := f.cuts
+	p.visiting = true
+	defer func() { p.visiting = false }()
+
+	// Connected routes always take precedence in the main table
+	if key.table == 0 {
+		for _, conn := range f.connected[key.ri] {
+			if conn.prefix.String() == p.prefix.String() {
+				p.done = true
+				return nil
+			}
+		}
+	}
+
+	paths := make([]fibPath, len(p.paths))
+	copy(paths, p.paths)
+	sort.SliceStable(paths, func(i, j int) bool {
+		return paths[i].distance < paths[j].distance
+	})
+
+	var result *FibRoute
+	for i := 0; i < len(paths) && result == nil; {
+		distance := paths[i].distance
+		route := &FibRoute{
+			RoutingInstance: key.ri,
+			Table:           key.table,
+			Prefix:          p.prefix.String(),
+			Distance:        distance,
+		}
+
+		for ; i < len(paths) && paths[i].distance == distance; i++ {
+			path := paths[i]
+			if path.kind != FIB_UNICAST {
+				route.Type = path.kind
+				route.Tag = path.tag
+				route.Nexthops = nil
+				continue
+			}
+			if route.Type != "" && route.Type != FIB_UNICAST {
+				continue
+			}
+
+			nhs := f.resolvePath(key, path, depth)
+			if len(nhs) == 0 {
+				continue
+			}
+			route.Type = FIB_UNICAST
+			route.Tag = path.tag
+			route.Source = path.source
+			route.Nexthops = appendNexthops(route.Nexthops, nhs)
+		}
+
+		if route.Type != "" {
+			result = route
+		}
+	}
+
+	if f.cuts == cuts {
+		p.done = true
+		p.result = result
+	}
+	return result
+}
+
+func appendNexthops(nhs []FibNexthop, add []FibNexthop) []FibNexthop {
+	for _, nh := range add {
+		dup := false
+		for _, existing := range nhs {
+			if existing == nh {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			nhs = append(nhs, nh)
+		}
+	}
+
+	return nhs
+}
+
+/*
+ * Returns the forwarding next-hops of path, which belongs to a route in
+ * table key.
+ */
+func (f *fib) resolvePath(key fibKey, path fibPath, depth int) []FibNexthop {
+	leak_ri := ""
+	lookup := key
+	if path.lookup_ri != key.ri {
+		leak_ri = path.lookup_ri
+		lookup = fibKey{ri: path.lookup_ri}
+	}
+
+	if path.gateway == nil || path.iface != "" {
+		nh := FibNexthop{
+			Interface:       path.iface,
+			RoutingInstance: leak_ri,
+			Label:           path.label,
+			Weight:          path.weight,
+		}
+		if path.gateway != nil {
+			nh.Address = path.gateway.String()
+		}
+		return []FibNexthop{nh}
+	}
+
+	nhs := f.resolveAddress(lookup, path.gateway, depth)
+	for i, _ := range nhs {
+		if nhs[i].RoutingInstance == "" {
+			nhs[i].RoutingInstance = leak_ri
+		}
+		nhs[i].Weight = path.weight
+	}
+
+	return nhs
+}
+
+/*
+ * Resolves addr via the longest matching connected or static route in
+ * table key. As is usual for routing daemons, a default route is not
+ * used for next-hop resolution.
+ */
+func (f *fib) resolveAddress(key fibKey, addr net.IP, depth int) []FibNexthop {
+	type match struct {
+		length int
+		conn   *fibConnected
+		static *fibPrefix
+	}
+	var matches []match
+
+	for i, conn := range f.connected[key.ri] {
+		if conn.prefix.Contains(addr) {
+			ones, _ := conn.prefix.Mask.Size()
+			matches = append(matches,
+				match{length: ones, conn: &f.connected[key.ri][i]})
+		}
+	}
+	for _, p := range f.tables[key] {
+		ones, _ := p.prefix.Mask.Size()
+		if ones > 0 && p.prefix.Contains(addr) {
+			matches = append(matches, match{length: ones, static: p})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].length != matches[j].length {
+			return matches[i].length > matches[j].length
+		}
+		return matches[i].conn != nil && matches[j].conn == nil
+	})
+
+	for _, m := range matches {
+		if m.conn != nil {
+			return []FibNexthop{{
+				Address:   addr.String(),
+				Interface: m.conn.iface,
+			}}
+		}
+
+		route := f.selectRoute(key, m.static, depth+1)
+		if route == nil {
+			continue
+		}
+		if route.Type != FIB_UNICAST {
+			return nil
+		}
+
+		nhs := make([]FibNexthop, len(route.Nexthops))
+		copy(nhs, route.Nexthops)
+		for i, _ := range nhs {
+			if nhs[i].Address == "" {
+				nhs[i].Address = addr.String()
+			}
+		}
+		return nhs
+	}
+
+	return nil
+}
+
+/*
+ * Computes the set of static routes which would be installed for the
+ * static configuration in frontend_map, given the connected routes of
+ * each routing-instance.
+ *
+ * frontend_map is untranslated configuration, as the output of Translate
+ * has kernel rather than PBR table ids. Disabled next-hops are ignored, recursive and inter-VRF
+ * next-hops are resolved and, for each prefix, only the paths with the
+ * lowest distance which resolve are retained.
+ *
+ * The returned routes are ordered by routing-instance, table and prefix.
+ */
+func ComputeFib(frontend_map map[string]interface{},
+	connected []ConnectedRoute) ([]FibRoute, error) {
+	ret_err := protocols.NewMultiError()
+
+	f := &fib{
+		tables:    make(map[fibKey]fibTable),
+		connected: make(map[string][]fibConnected),
+	}
+
+	for _, conn := range connected {
+		_, prefix, err := net.ParseCIDR(conn.Prefix)
+		if err != nil {
+			ret_err = multierr.Append(ret_err, err)
+			continue
+		}
+		ri := conn.RoutingInstance
+		if ri == "" {
+			ri = "default"
+		}
+		f.connected[ri] = append(f.connected[ri],
+			fibConnected{prefix: prefix, iface: conn.Interface})
+	}
+
+	containers, err := staticContainers(frontend_map)
+	ret_err = multierr.Append(ret_err, err)
+
+	for ri, static_map := range containers {
+		path := StaticPath(ri)
+		ret_err = multierr.Append(ret_err,
+			f.addRouteLists(fibKey{ri: ri}, static_map, path))
+
+		tbl_arr, _ := static_map["table"].([]interface{})
+		for _, tbl_entry := range tbl_arr {
+			tbl_map, ok := tbl_entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			table := uintValue(tbl_map["tagnode"], 0)
+			ret_err = multierr.Append(ret_err,
+				f.addRouteLists(fibKey{ri: ri, table: table}, tbl_map,
+					EntryPath(path, "table", tbl_map["tagnode"])))
+		}
+	}
+
+	var routes []FibRoute
+	for key, tbl := range f.tables {
+		for _, p := range tbl {
+			if route := f.selectRoute(key, p, 0); route != nil {
+				routes = append(routes, *route)
+			}
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].RoutingInstance != routes[j].RoutingInstance {
+			return routes[i].RoutingInstance < routes[j].RoutingInstance
+		}
+		if routes[i].Table != routes[j].Table {
+			return routes[i].Table < routes[j].Table
+		}
+		return routes[i].Prefix < routes[j].Prefix
+	})
+
+	return routes, ret_err.ErrorOrNil()
+}
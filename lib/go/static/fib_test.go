@@ -0,0 +1,199 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"reflect"
+	"testing"
+)
+
+func TestComputeFib(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "192.168.1.1" },
+                  { "tagnode" : "192.168.1.2", "disable" : null },
+                  { "tagnode" : "192.168.2.1", "distance" : 10 }
+               ]
+            },
+            {
+               "tagnode" : "20.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "10.1.1.1" },
+                  { "tagnode" : "172.16.0.1" }
+               ],
+               "blackhole" : { "distance" : 200 }
+            },
+            {
+               "tagnode" : "30.0.0.0/8",
+               "next-hop-routing-instance" : [
+                  {
+                     "routing-instance" : "RED",
+                     "next-hop" : [ { "tagnode" : "192.168.3.1" } ]
+                  }
+               ]
+            },
+            {
+               "tagnode" : "40.0.0.0/8",
+               "next-hop" : [ { "tagnode" : "172.16.0.1" } ],
+               "unreachable" : { "distance" : 5 }
+            }
+         ],
+         "table" : [
+            {
+               "tagnode" : 100,
+               "route" : [
+                  {
+                     "tagnode" : "0.0.0.0/0",
+                     "next-hop" : [ { "tagnode" : "192.168.2.1" } ]
+                  }
+               ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "interface-route" : [
+                     {
+                        "tagnode" : "50.0.0.0/8",
+                        "next-hop-interface" : [
+                           { "interface-name" : "dp0s3" }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	connected := []static.ConnectedRoute{
+		{RoutingInstance: "default", Prefix: "192.168.1.0/24", Interface: "dp0s1"},
+		{RoutingInstance: "default", Prefix: "192.168.2.0/24", Interface: "dp0s2"},
+		{RoutingInstance: "RED", Prefix: "192.168.3.0/24", Interface: "dp0s3"},
+	}
+
+	expected := []static.FibRoute{
+		{
+			RoutingInstance: "RED", Prefix: "50.0.0.0/8",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{{Interface: "dp0s3"}},
+		},
+		{
+			RoutingInstance: "default", Prefix: "10.0.0.0/8",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.1.1", Interface: "dp0s1"},
+			},
+		},
+		{
+			RoutingInstance: "default", Prefix: "20.0.0.0/8",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.1.1", Interface: "dp0s1"},
+			},
+		},
+		{
+			RoutingInstance: "default", Prefix: "30.0.0.0/8",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.3.1", Interface: "dp0s3",
+					RoutingInstance: "RED"},
+			},
+		},
+		{
+			RoutingInstance: "default", Prefix: "40.0.0.0/8",
+			Type: static.FIB_UNREACHABLE, Distance: 5,
+		},
+		{
+			RoutingInstance: "default", Table: 100, Prefix: "0.0.0.0/0",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.2.1", Interface: "dp0s2"},
+			},
+		},
+	}
+
+	routes, err := static.ComputeFib(unmarshalConfig(t, input_json), connected)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Unexpected routes\nexpected: %+v\ngot: %+v", expected, routes)
+	}
+}
+
+func TestComputeFibMutualRecursion(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.1.0.0/16",
+               "next-hop" : [
+                  { "tagnode" : "10.2.0.1" },
+                  { "tagnode" : "192.168.1.1", "distance" : 10 }
+               ]
+            },
+            {
+               "tagnode" : "10.2.0.0/16",
+               "next-hop" : [
+                  { "tagnode" : "10.1.0.1" },
+                  { "tagnode" : "192.168.2.1", "distance" : 10 }
+               ]
+            }
+         ]
+      }
+   }
+}`)
+
+	connected := []static.ConnectedRoute{
+		{RoutingInstance: "default", Prefix: "192.168.1.0/24", Interface: "dp0s1"},
+		{RoutingInstance: "default", Prefix: "192.168.2.0/24", Interface: "dp0s2"},
+	}
+
+	//Each route resolves via the other's fallback next-hop, whichever
+	//is visited first
+	expected := []static.FibRoute{
+		{
+			RoutingInstance: "default", Prefix: "10.1.0.0/16",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.2.1", Interface: "dp0s2"},
+			},
+		},
+		{
+			RoutingInstance: "default", Prefix: "10.2.0.0/16",
+			Type: static.FIB_UNICAST, Distance: 1,
+			Nexthops: []static.FibNexthop{
+				{Address: "192.168.1.1", Interface: "dp0s1"},
+			},
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		routes, err := static.ComputeFib(unmarshalConfig(t, input_json),
+			connected)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(routes, expected) {
+			t.Fatalf("Unexpected routes\nexpected: %+v\ngot: %+v",
+				expected, routes)
+		}
+	}
+}
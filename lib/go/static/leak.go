@@ -0,0 +1,214 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"eng.vyatta.net/protocols"
+	"fmt"
+	multierr "github.com/hashicorp/go-multierror"
+	"sort"
+	"strings"
+)
+
+/*
+ * Inter-VRF route leaks for a single prefix, keyed by the routing-instance
+ * containing the route, giving the set of routing-instances it leaks into.
+ */
+type leakGraph map[string]map[string]bool
+
+func (g leakGraph) addEdge(from, to string) {
+	if g[from] == nil {
+		g[from] = make(map[string]bool)
+	}
+	g[from][to] = true
+}
+
+func (g leakGraph) sortedNodes() []string {
+	nodes := make([]string, 0, len(g))
+	for node, _ := range g {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (g leakGraph) sortedEdges(from string) []string {
+	edges := make([]string, 0, len(g[from]))
+	for to, _ := range g[from] {
+		edges = append(edges, to)
+	}
+	sort.Strings(edges)
+	return edges
+}
+
+/*
+ * Returns each cycle found in g, rotated so that it starts at its
+ * lexically smallest routing-instance.
+ */
+func (g leakGraph) cycles() [][]string {
+	var ret [][]string
+	var stack []string
+
+	found := make(map[string]bool)
+	done := make(map[string]bool)
+	on_stack := make(map[string]int)
+
+	var visit func(node string)
+	visit = func(node string) {
+		on_stack[node] = len(stack)
+		stack = append(stack, node)
+
+		for _, next := range g.sortedEdges(node) {
+			if idx, exists := on_stack[next]; exists {
+				cycle := canonicalCycle(stack[idx:])
+				key := strings.Join(cycle, " ")
+				if !found[key] {
+					found[key] = true
+					ret = append(ret, cycle)
+				}
+			} else if !done[next] {
+				visit(next)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		delete(on_stack, node)
+		done[node] = true
+	}
+
+	for _, node := range g.sortedNodes() {
+		if !done[node] {
+			visit(node)
+		}
+	}
+
+	return ret
+}
+
+func canonicalCycle(cycle []string) []string {
+	start := 0
+	for i, node := range cycle {
+		if node < cycle[start] {
+			start = i
+		}
+	}
+
+	ret := make([]string, 0, len(cycle))
+	ret = append(ret, cycle[start:]...)
+	ret = append(ret, cycle[:start]...)
+	return ret
+}
+
+/*
+ * Returns whether any next-hop in the list nkey of pmap is enabled
+ */
+func hasEnabledNexthop(pmap map[string]interface{}, nkey string) bool {
+	nh_arr, _ := pmap[nkey].([]interface{})
+	for _, nh_entry := range nh_arr {
+		nh_map, ok := nh_entry.(map[string]interface{})
+		if ok && !IsNexthopDisabled(nh_map) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Adds the inter-VRF leaks of each route in list pkey of routing-instance
+ * ri into graphs, which is keyed by prefix.
+ */
+func addRouteLeaks(graphs map[string]leakGraph, static_map map[string]interface{},
+	pkey, ri string) error {
+	ret_err := protocols.NewMultiError()
+	path := StaticPath(ri)
+
+	route_arr, err := getList(static_map, pkey, path)
+	ret_err = multierr.Append(ret_err, err)
+
+	for _, route_entry := range route_arr {
+		route_map, ok := route_entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		prefix := fmt.Sprint(route_map["tagnode"])
+
+		for _, inst_key := range [...]string{"next-hop-routing-instance",
+			"next-hop-routing-instance-v6"} {
+			inst_arr, err := getList(route_map, inst_key,
+				EntryPath(path, pkey, prefix))
+			ret_err = multierr.Append(ret_err, err)
+
+			for _, inst_entry := range inst_arr {
+				inst_map, ok := inst_entry.(map[string]interface{})
+				if !ok || !hasEnabledNexthop(inst_map, "next-hop") {
+					continue
+				}
+
+				if graphs[prefix] == nil {
+					graphs[prefix] = make(leakGraph)
+				}
+				graphs[prefix].addEdge(ri,
+					fmt.Sprint(inst_map["routing-instance"]))
+			}
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Detects inter-VRF static route leak loops, where a route to a prefix in
+ * one routing-instance has a next-hop-routing-instance leading, directly
+ * or via further routing-instances, back to a route for the same prefix
+ * in the original routing-instance.
+ *
+ * Only route and route6 are considered since interface routes egress
+ * directly via the next-hop interface without a further lookup.
+ *
+ * frontend_map may be either the output of Translate or untranslated
+ * configuration. Each loop found is reported in the returned error along
+ * with the chain of routing-instances forming it.
+ */
+func CheckLeakLoops(frontend_map map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+
+	containers, err := staticContainers(frontend_map)
+	ret_err = multierr.Append(ret_err, err)
+	ret_err = multierr.Append(ret_err, checkLeakLoops(containers))
+
+	return ret_err.ErrorOrNil()
+}
+
+func checkLeakLoops(containers map[string]map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+
+	for _, pkey := range [...]string{"route", "route6"} {
+		graphs := make(map[string]leakGraph)
+
+		for ri, static_map := range containers {
+			ret_err = multierr.Append(ret_err,
+				addRouteLeaks(graphs, static_map, pkey, ri))
+		}
+
+		prefixes := make([]string, 0, len(graphs))
+		for prefix, _ := range graphs {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+
+		for _, prefix := range prefixes {
+			for _, cycle := range graphs[prefix].cycles() {
+				chain := strings.Join(append(cycle, cycle[0]), " -> ")
+				ret_err = multierr.Append(ret_err, pathError(
+					EntryPath(StaticPath(cycle[0]), pkey, prefix),
+					"Inter-VRF route leak loop: %s", chain))
+			}
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
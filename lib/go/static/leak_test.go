@@ -0,0 +1,134 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"strings"
+	"testing"
+)
+
+func TestCheckLeakLoops(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop-routing-instance" : [
+                  {
+                     "routing-instance" : "RED",
+                     "next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+                  }
+               ]
+            },
+            {
+               "tagnode" : "11.0.0.0/8",
+               "next-hop-routing-instance" : [
+                  {
+                     "routing-instance" : "RED",
+                     "next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+                  }
+               ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route" : [
+                     {
+                        "tagnode" : "10.0.0.0/8",
+                        "next-hop-routing-instance" : [
+                           {
+                              "routing-instance" : "BLUE",
+                              "next-hop" : [ { "tagnode" : "2.2.2.2" } ]
+                           }
+                        ]
+                     },
+                     {
+                        "tagnode" : "11.0.0.0/8",
+                        "next-hop-routing-instance" : [
+                           {
+                              "routing-instance" : "default",
+                              "next-hop" : [
+                                 { "tagnode" : "3.3.3.3", "disable" : null }
+                              ]
+                           }
+                        ]
+                     }
+                  ],
+                  "route6" : [
+                     {
+                        "tagnode" : "2001::/64",
+                        "next-hop-routing-instance-v6" : [
+                           {
+                              "routing-instance" : "BLUE",
+                              "next-hop" : [ { "tagnode" : "2002::1" } ]
+                           }
+                        ]
+                     }
+                  ]
+               }
+            }
+         },
+         {
+            "instance-name" : "BLUE",
+            "protocols" : {
+               "static" : {
+                  "route" : [
+                     {
+                        "tagnode" : "10.0.0.0/8",
+                        "next-hop-routing-instance" : [
+                           {
+                              "routing-instance" : "default",
+                              "next-hop" : [ { "tagnode" : "4.4.4.4" } ]
+                           }
+                        ]
+                     }
+                  ],
+                  "route6" : [
+                     {
+                        "tagnode" : "2001::/64",
+                        "next-hop-routing-instance-v6" : [
+                           {
+                              "routing-instance" : "RED",
+                              "next-hop" : [ { "tagnode" : "2003::1" } ]
+                           }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	err := static.CheckLeakLoops(unmarshalConfig(t, input_json))
+	if err == nil {
+		t.Fatal("Expected leak loop errors")
+	}
+
+	expected := []string{
+		"[routing routing-instance BLUE protocols static route 10.0.0.0/8]\n" +
+			"Inter-VRF route leak loop: BLUE -> default -> RED -> BLUE",
+		"[routing routing-instance BLUE protocols static route6 2001::/64]\n" +
+			"Inter-VRF route leak loop: BLUE -> RED -> BLUE",
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Error does not contain %q:\n%s", e, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "11.0.0.0/8") {
+		t.Errorf("Unexpected loop via disabled next-hop:\n%s", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"eng.vyatta.net/protocols"
+	"fmt"
+	multierr "github.com/hashicorp/go-multierror"
+	"strconv"
+	"strings"
+)
+
+/* Limits on the label stack of a next-hop */
+const (
+	MPLS_LABEL_MIN  = 16
+	MPLS_LABEL_MAX  = 1048575
+	MPLS_MAX_LABELS = 16
+)
+
+/*
+ * Returns the label stack v of a next-hop, either the label leaf-list
+ * of the configuration or its translated form, outermost label first.
+ */
+func LabelStack(v interface{}) ([]uint32, error) {
+	var labels []uint32
+
+	switch stack := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		for _, label := range stack {
+			n := uintValue(label, 0)
+			if n == 0 {
+				return nil, fmt.Errorf("Invalid label %v", label)
+			}
+			labels = append(labels, n)
+		}
+	case string:
+		for _, label := range strings.Split(stack, "/") {
+			n, err := strconv.ParseUint(label, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid label %s", label)
+			}
+			labels = append(labels, uint32(n))
+		}
+	default:
+		return nil, fmt.Errorf("Invalid label stack %v", v)
+	}
+
+	return labels, nil
+}
+
+/*
+ * Returns the translated form of a label stack, as passed to the
+ * routing daemon, with labels separated by /
+ */
+func FormatLabelStack(labels []uint32) string {
+	strs := make([]string, len(labels))
+	for i, label := range labels {
+		strs[i] = strconv.FormatUint(uint64(label), 10)
+	}
+
+	return strings.Join(strs, "/")
+}
+
+/*
+ * Translates the label leaf-list of nh_map into the form expected by
+ * the routing daemon
+ */
+func TranslateLabels(nh_map map[string]interface{}, path string) error {
+	if nh_map["label"] == nil {
+		return nil
+	}
+
+	labels, err := LabelStack(nh_map["label"])
+	if err != nil {
+		delete(nh_map, "label")
+		return pathError(path, "%s", err)
+	}
+
+	nh_map["label"] = FormatLabelStack(labels)
+	return nil
+}
+
+/*
+ * Validates the label stack of the untranslated next-hop nh_map
+ */
+func validateLabels(nh_map map[string]interface{}, path string) error {
+	if nh_map["label"] == nil {
+		return nil
+	}
+
+	labels, err := LabelStack(nh_map["label"])
+	if err != nil {
+		return pathError(path, "%s", err)
+	}
+
+	ret_err := protocols.NewMultiError()
+
+	if len(labels) > MPLS_MAX_LABELS {
+		ret_err = multierr.Append(ret_err, pathError(path,
+			"At most %d labels may be pushed", MPLS_MAX_LABELS))
+	}
+	for _, label := range labels {
+		if label < MPLS_LABEL_MIN || label > MPLS_LABEL_MAX {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"Label %d must be in the range %d-%d", label,
+				MPLS_LABEL_MIN, MPLS_LABEL_MAX))
+		}
+	}
+	if nh_map["interface"] == nil {
+		ret_err = multierr.Append(ret_err, pathError(path,
+			"A labelled next-hop must have an outgoing interface"))
+	}
+
+	return ret_err.ErrorOrNil()
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"strings"
+	"testing"
+)
+
+func TestTranslateLabels(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  {
+                     "tagnode" : "1.1.1.1",
+                     "interface" : "dp0s1",
+                     "label" : [ 100, 200 ]
+                  }
+               ]
+            }
+         ]
+      }
+   }
+}`)
+
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "next-hop" : [
+                  {
+                     "interface" : "dp0s1",
+                     "label" : "100/200",
+                     "tagnode" : "1.1.1.1"
+                  }
+               ],
+               "tagnode" : "10.0.0.0/8"
+            }
+         ]
+      }
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	compareConfig(t, cfg, expected_json)
+}
+
+func TestValidateLabels(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  {
+                     "tagnode" : "1.1.1.1",
+                     "interface" : "dp0s1",
+                     "label" : [ 100 ]
+                  },
+                  {
+                     "tagnode" : "1.1.1.2",
+                     "interface" : "dp0s2",
+                     "label" : [ 100, 3 ]
+                  },
+                  { "tagnode" : "1.1.1.3", "label" : [ 100 ] },
+                  {
+                     "tagnode" : "1.1.1.4",
+                     "interface" : "dp0s3",
+                     "label" : [ 100 ],
+                     "disable" : null
+                  }
+               ]
+            }
+         ]
+      }
+   }
+}`))
+
+	path := "[protocols static route 10.0.0.0/8 next-hop "
+	err := static.Validate(cfg)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	for _, expected := range []string{
+		path + "1.1.1.2]\nLabel 3 must be in the range 16-1048575",
+		path + "1.1.1.3]\nA labelled next-hop must have an outgoing interface",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Error does not contain %q:\n%s", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "1.1.1.1]") {
+		t.Errorf("Unexpected error for valid next-hop:\n%s", err)
+	}
+}
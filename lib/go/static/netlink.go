@@ -0,0 +1,510 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/vishvananda/netlink"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+ * Routing protocol id of the static routes installed by a StaticFallback,
+ * named in /etc/iproute2/rt_protos.d/vyatta-static.conf
+ */
+const RTPROT_VYATTA_STATIC = 196
+
+const (
+	fallbackPollInterval  = 2 * time.Second
+	fallbackHandoverDelay = 10 * time.Second
+)
+
+/*
+ * The subset of netlink operations used to program static routes
+ */
+type RouteHandle interface {
+	LinkByName(name string) (netlink.Link, error)
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	RouteReplace(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+}
+
+/*
+ * StaticFallback installs translated static routes directly into the
+ * kernel via netlink while the routing daemon is not running, so that
+ * static routes (and with them management reachability) survive the
+ * daemon being stopped or crashing.
+ *
+ * Set() should be called with the translated configuration on every
+ * configuration change. While the daemon is down the routes are
+ * reconciled immediately. Run() monitors the daemon, installing the
+ * routes when it goes down and removing them once it has been up for
+ * long enough to have installed its own.
+ */
+type StaticFallback struct {
+	lock          sync.Mutex
+	handle        RouteHandle
+	daemonActive  func() bool
+	pollInterval  time.Duration
+	handoverDelay time.Duration
+	cfg           map[string]interface{}
+	installed     bool
+	failed        bool
+	activeSince   time.Time
+	stop          chan struct{}
+}
+
+/*
+ * Returns a fallback for static routes normally installed by daemon pd
+ */
+func NewStaticFallback(pd *protocols.ProtocolsDaemon) *StaticFallback {
+	return NewStaticFallbackWithHandle(&netlink.Handle{}, pd.IsActive,
+		fallbackPollInterval, fallbackHandoverDelay)
+}
+
+func NewStaticFallbackWithHandle(handle RouteHandle, daemonActive func() bool,
+	pollInterval, handoverDelay time.Duration) *StaticFallback {
+	return &StaticFallback{
+		handle:        handle,
+		daemonActive:  daemonActive,
+		pollInterval:  pollInterval,
+		handoverDelay: handoverDelay,
+		stop:          make(chan struct{}),
+	}
+}
+
+/*
+ * Records the translated static configuration cfg, which must not be
+ * modified afterwards, and installs its routes if the daemon is down or
+ * has yet to take them over.
+ */
+func (f *StaticFallback) Set(cfg map[string]interface{}) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.cfg = cfg
+	if f.daemonActive() {
+		if !f.installed {
+			return nil
+		}
+		return f.reconcile()
+	}
+
+	f.activeSince = time.Time{}
+	return f.reconcile()
+}
+
+/*
+ * Monitors the daemon until Stop() is called, handing the static routes
+ * over to and from it as it starts and stops.
+ */
+func (f *StaticFallback) Run() {
+	ticker := time.NewTicker(f.pollInterval)
+	defer ticker.Stop()
+
+	last_err := ""
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		f.lock.Lock()
+		err := f.poll()
+		f.lock.Unlock()
+
+		//Failures are retried on each poll, so only log changes
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if msg != "" && msg != last_err {
+			log.Errorln("Static route fallback: " + msg)
+		}
+		last_err = msg
+	}
+}
+
+func (f *StaticFallback) poll() error {
+	if !f.daemonActive() {
+		if !f.activeSince.IsZero() || !f.installed {
+			log.Infoln("Routing daemon down, installing static routes")
+			f.activeSince = time.Time{}
+			return f.reconcile()
+		}
+		if f.failed {
+			return f.reconcile()
+		}
+		return nil
+	}
+
+	if f.activeSince.IsZero() {
+		f.activeSince = time.Now()
+	}
+	if f.installed && time.Since(f.activeSince) >= f.handoverDelay {
+		log.Infoln("Routing daemon up, handing over static routes")
+		return f.Flush()
+	}
+
+	return nil
+}
+
+/*
+ * Stops monitoring the daemon. Any routes installed are left in place.
+ */
+func (f *StaticFallback) Stop() {
+	close(f.stop)
+}
+
+/*
+ * Returns the routes installed by the fallback
+ */
+func (f *StaticFallback) installedRoutes() ([]netlink.Route, error) {
+	return f.handle.RouteListFiltered(netlink.FAMILY_ALL,
+		&netlink.Route{
+			Protocol: RTPROT_VYATTA_STATIC,
+			Table:    syscall.RT_TABLE_UNSPEC,
+		},
+		netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TABLE)
+}
+
+/*
+ * Removes all routes installed by the fallback
+ */
+func (f *StaticFallback) Flush() error {
+	routes, err := f.installedRoutes()
+	if err != nil {
+		return err
+	}
+
+	ret_err := protocols.NewMultiError()
+	for i := range routes {
+		ret_err = multierr.Append(ret_err, f.handle.RouteDel(&routes[i]))
+	}
+
+	f.installed = false
+	f.failed = false
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns a key identifying a kernel route, as used by RouteReplace
+ */
+func routeKey(route *netlink.Route) string {
+	dst := "0.0.0.0/0"
+	if route.Dst != nil {
+		dst = route.Dst.String()
+	} else if route.Family == netlink.FAMILY_V6 {
+		//The kernel omits the destination of a default route
+		dst = "::/0"
+	}
+
+	return fmt.Sprintf("%d %d %s %d", route.Family, route.Table,
+		dst, route.Priority)
+}
+
+/*
+ * Installs the routes of the recorded configuration, removing any
+ * previously installed routes which are no longer configured. Routes
+ * which fail to be installed are retried by the next poll.
+ */
+func (f *StaticFallback) reconcile() error {
+	ret_err := protocols.NewMultiError()
+
+	routes, err := BuildNetlinkRoutes(f.cfg, f.handle)
+	ret_err = multierr.Append(ret_err, err)
+
+	wanted := make(map[string]bool)
+	for _, route := range routes {
+		wanted[routeKey(route)] = true
+	}
+
+	existing, err := f.installedRoutes()
+	if err != nil {
+		f.failed = true
+		return multierr.Append(ret_err, err).ErrorOrNil()
+	}
+	for i := range existing {
+		if !wanted[routeKey(&existing[i])] {
+			ret_err = multierr.Append(ret_err,
+				f.handle.RouteDel(&existing[i]))
+		}
+	}
+
+	for _, route := range routes {
+		err := f.handle.RouteReplace(route)
+		if err != nil {
+			ret_err = multierr.Append(ret_err, fmt.Errorf(
+				"Failed to install route %s table %d: %s",
+				route.Dst, route.Table, err))
+		}
+	}
+
+	//Some routes may have been installed even if others failed
+	f.installed = true
+	f.failed = ret_err.ErrorOrNil() != nil
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the kernel table of the main static routes of routing-instance ri
+ */
+func routingInstanceTable(handle RouteHandle, ri string) (int, error) {
+	if ri == "default" {
+		return syscall.RT_TABLE_MAIN, nil
+	}
+
+	link, err := handle.LinkByName(vrfDevicePrefix + ri)
+	if err != nil {
+		return 0, err
+	}
+	vrf, ok := link.(*netlink.Vrf)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a VRF device", link.Attrs().Name)
+	}
+
+	return int(vrf.Table), nil
+}
+
+/* Kernel route types of the static route types without next-hops */
+var kernelRouteTypes = map[string]int{
+	FIB_BLACKHOLE:   syscall.RTN_BLACKHOLE,
+	FIB_UNREACHABLE: syscall.RTN_UNREACHABLE,
+	FIB_PROHIBIT:    syscall.RTN_PROHIBIT,
+	FIB_THROW:       syscall.RTN_THROW,
+}
+
+type netlinkRouteBuilder struct {
+	handle RouteHandle
+	routes []*netlink.Route
+	errs   *multierr.Error
+}
+
+func (b *netlinkRouteBuilder) linkIndex(name string) (int, error) {
+	link, err := b.handle.LinkByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("interface %s: %s", name, err)
+	}
+
+	return link.Attrs().Index, nil
+}
+
+/*
+ * Returns the kernel next-hop for the translated next-hop nh_map in
+ * routing-instance lookup_ri of a route in routing-instance ri.
+ */
+func (b *netlinkRouteBuilder) nexthop(nh_map map[string]interface{},
+	nkey, ri, lookup_ri string) (*netlink.NexthopInfo, error) {
+	var err error
+	nh := &netlink.NexthopInfo{}
+
+	if nkey == "next-hop-interface" {
+		nh.LinkIndex, err = b.linkIndex(fmt.Sprint(nh_map["tagnode"]))
+		return nh, err
+	}
+
+	nh.Gw = net.ParseIP(fmt.Sprint(nh_map["tagnode"]))
+	if nh.Gw == nil {
+		return nil, fmt.Errorf("invalid next-hop %v", nh_map["tagnode"])
+	}
+
+	if iface, ok := nh_map["interface"].(string); ok {
+		nh.LinkIndex, err = b.linkIndex(iface)
+	} else if lookup_ri != ri {
+		//The gateway is resolved in the other routing-instance's table
+		if lookup_ri == "default" {
+			return nil, fmt.Errorf("next-hop %s in routing-instance "+
+				"default requires an interface", nh.Gw)
+		}
+		nh.LinkIndex, err = b.linkIndex(vrfDevicePrefix + lookup_ri)
+	}
+
+	return nh, err
+}
+
+/*
+ * Adds the kernel routes for a translated route entry in table
+ */
+func (b *netlinkRouteBuilder) addRoute(route_map map[string]interface{},
+	nkey, ri string, table int, path string) {
+	_, dst, err := net.ParseCIDR(fmt.Sprint(route_map["tagnode"]))
+	if err != nil {
+		b.errs = multierr.Append(b.errs, pathError(path, "%s", err))
+		return
+	}
+
+	family := netlink.FAMILY_V4
+	if dst.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	newRoute := func(distance uint32) *netlink.Route {
+		return &netlink.Route{
+			Dst:      dst,
+			Family:   family,
+			Table:    table,
+			Protocol: RTPROT_VYATTA_STATIC,
+			Priority: int(distance),
+		}
+	}
+
+	for _, rtype := range specialRouteTypes {
+		if _, exists := route_map[rtype]; !exists {
+			continue
+		}
+		rt_map, _ := route_map[rtype].(map[string]interface{})
+		route := newRoute(uintValue(rt_map["distance"], 1))
+		route.Type = kernelRouteTypes[rtype]
+		b.routes = append(b.routes, route)
+	}
+
+	var source net.IP
+	if src, ok := route_map["source"].(string); ok {
+		source = net.ParseIP(src)
+	}
+
+	//Group next-hops by distance, each group forming a multipath route
+	nh_groups := make(map[uint32][]*netlink.NexthopInfo)
+
+	addNexthops := func(parent map[string]interface{}, lookup_ri, path string) {
+		nh_arr, _ := parent[nkey].([]interface{})
+		for _, nh_entry := range nh_arr {
+			nh_map, ok := nh_entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			nh, err := b.nexthop(nh_map, nkey, ri, lookup_ri)
+			if err != nil {
+				b.errs = multierr.Append(b.errs, pathError(
+					EntryPath(path, nkey, nh_map["tagnode"]), "%s", err))
+				continue
+			}
+			if nh_map["label"] != nil {
+				labels, err := LabelStack(nh_map["label"])
+				if err != nil {
+					b.errs = multierr.Append(b.errs, pathError(
+						EntryPath(path, nkey, nh_map["tagnode"]), "%s", err))
+					continue
+				}
+				encap := &netlink.MPLSEncap{}
+				for _, label := range labels {
+					encap.Labels = append(encap.Labels, int(label))
+				}
+				nh.Encap = encap
+			}
+			if _, onlink := nh_map["onlink"]; onlink {
+				nh.Flags |= int(netlink.FLAG_ONLINK)
+			}
+			if weight := uintValue(nh_map["weight"], 1); weight > 1 {
+				nh.Hops = int(weight) - 1
+			}
+			distance := uintValue(nh_map["distance"], 1)
+			nh_groups[distance] = append(nh_groups[distance], nh)
+		}
+	}
+
+	addNexthops(route_map, ri, path)
+	inst_arr, _ := route_map["next-hop-routing-instance"].([]interface{})
+	for _, inst_entry := range inst_arr {
+		if inst_map, ok := inst_entry.(map[string]interface{}); ok {
+			inst := fmt.Sprint(inst_map["routing-instance"])
+			addNexthops(inst_map, inst, EntryPath(path,
+				"next-hop-routing-instance", inst))
+		}
+	}
+
+	distances := make([]int, 0, len(nh_groups))
+	for distance, _ := range nh_groups {
+		distances = append(distances, int(distance))
+	}
+	sort.Ints(distances)
+
+	for _, distance := range distances {
+		nhs := nh_groups[uint32(distance)]
+		route := newRoute(uint32(distance))
+		route.Src = source
+		if len(nhs) == 1 {
+			route.LinkIndex = nhs[0].LinkIndex
+			route.Gw = nhs[0].Gw
+			route.Flags = nhs[0].Flags
+			route.Encap = nhs[0].Encap
+			if route.Gw == nil {
+				route.Scope = netlink.SCOPE_LINK
+			}
+		} else {
+			route.MultiPath = nhs
+		}
+		b.routes = append(b.routes, route)
+	}
+}
+
+func (b *netlinkRouteBuilder) addRouteLists(pmap map[string]interface{},
+	ri string, table int, path string) {
+	for _, info := range routeLists {
+		route_arr, _ := pmap[info.key].([]interface{})
+		for _, route_entry := range route_arr {
+			if route_map, ok := route_entry.(map[string]interface{}); ok {
+				b.addRoute(route_map, info.nexthop, ri, table,
+					EntryPath(path, info.key, route_map["tagnode"]))
+			}
+		}
+	}
+}
+
+/*
+ * Returns the kernel routes, tagged with RTPROT_VYATTA_STATIC, for the
+ * translated static configuration cfg. Routes which cannot be built, for
+ * instance because an interface does not yet exist, are omitted and
+ * reported in the returned error.
+ */
+func BuildNetlinkRoutes(cfg map[string]interface{},
+	handle RouteHandle) ([]*netlink.Route, error) {
+	b := &netlinkRouteBuilder{handle: handle, errs: protocols.NewMultiError()}
+
+	containers, err := staticContainers(cfg)
+	if err != nil {
+		b.errs = multierr.Append(b.errs, err)
+	}
+
+	ris := make([]string, 0, len(containers))
+	for ri, _ := range containers {
+		ris = append(ris, ri)
+	}
+	sort.Strings(ris)
+
+	for _, ri := range ris {
+		static_map := containers[ri]
+		path := StaticPath(ri)
+
+		table, err := routingInstanceTable(handle, ri)
+		if err != nil {
+			b.errs = multierr.Append(b.errs, pathError(path,
+				"No table for routing-instance: %s", err))
+		} else {
+			b.addRouteLists(static_map, ri, table, path)
+		}
+
+		//PBR table ids have already been translated to kernel tables
+		tbl_arr, _ := static_map["table"].([]interface{})
+		for _, tbl_entry := range tbl_arr {
+			if tbl_map, ok := tbl_entry.(map[string]interface{}); ok {
+				b.addRouteLists(tbl_map, ri,
+					int(uintValue(tbl_map["tagnode"], 0)),
+					EntryPath(path, "table", tbl_map["tagnode"]))
+			}
+		}
+	}
+
+	return b.routes, b.errs.ErrorOrNil()
+}
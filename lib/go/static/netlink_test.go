@@ -0,0 +1,327 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeRouteHandle struct {
+	lock    sync.Mutex
+	links   map[string]netlink.Link
+	routes  map[string]netlink.Route
+	failing map[string]bool
+}
+
+func newFakeRouteHandle() *fakeRouteHandle {
+	h := &fakeRouteHandle{
+		links:   make(map[string]netlink.Link),
+		routes:  make(map[string]netlink.Route),
+		failing: make(map[string]bool),
+	}
+	for i, name := range []string{"dp0s1", "dp0s2", "vrfRED"} {
+		attrs := netlink.LinkAttrs{Name: name, Index: i + 1}
+		if name == "vrfRED" {
+			h.links[name] = &netlink.Vrf{LinkAttrs: attrs, Table: 1000}
+		} else {
+			h.links[name] = &netlink.Dummy{LinkAttrs: attrs}
+		}
+	}
+
+	//A route belonging to another protocol, which must be left alone
+	_, dst, _ := net.ParseCIDR("172.16.0.0/12")
+	h.RouteReplace(&netlink.Route{Dst: dst,
+		Family: netlink.FAMILY_V4, Table: syscall.RT_TABLE_MAIN,
+		Protocol: syscall.RTPROT_STATIC})
+	return h
+}
+
+func fakeRouteKey(route *netlink.Route) string {
+	return fmt.Sprintf("%d %s %d", route.Table, route.Dst, route.Priority)
+}
+
+func (h *fakeRouteHandle) LinkByName(name string) (netlink.Link, error) {
+	if link, ok := h.links[name]; ok {
+		return link, nil
+	}
+	return nil, fmt.Errorf("Link not found")
+}
+
+func (h *fakeRouteHandle) RouteListFiltered(family int, filter *netlink.Route,
+	filterMask uint64) ([]netlink.Route, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var routes []netlink.Route
+	for _, route := range h.routes {
+		if filterMask&netlink.RT_FILTER_PROTOCOL != 0 &&
+			route.Protocol != filter.Protocol {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (h *fakeRouteHandle) RouteReplace(route *netlink.Route) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.failing[route.Dst.String()] {
+		return fmt.Errorf("No buffer space available")
+	}
+	h.routes[fakeRouteKey(route)] = *route
+	return nil
+}
+
+/*
+ * Makes the installation of routes to dst fail, or succeed again
+ */
+func (h *fakeRouteHandle) setFailing(dst string, failing bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.failing[dst] = failing
+}
+
+func (h *fakeRouteHandle) RouteDel(route *netlink.Route) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.routes, fakeRouteKey(route))
+	return nil
+}
+
+/*
+ * Returns a summary of the routes installed by the fallback
+ */
+func (h *fakeRouteHandle) installed() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var ret []string
+	for key, route := range h.routes {
+		if route.Protocol != static.RTPROT_VYATTA_STATIC {
+			continue
+		}
+		desc := fmt.Sprintf("%s type %d", key, route.Type)
+		if route.Gw != nil || route.LinkIndex != 0 {
+			desc += fmt.Sprintf(" via %s dev %d", route.Gw, route.LinkIndex)
+		}
+		for _, nh := range route.MultiPath {
+			desc += fmt.Sprintf(" nexthop %s dev %d weight %d",
+				nh.Gw, nh.LinkIndex, nh.Hops+1)
+		}
+		ret = append(ret, desc)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func checkInstalled(t *testing.T, h *fakeRouteHandle, expected []string) {
+	t.Helper()
+
+	got := h.installed()
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected routes\nexpected: %q\ngot: %q", expected, got)
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	translated_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "192.168.1.1", "weight" : 2 },
+                  { "tagnode" : "192.168.2.1", "interface" : "dp0s2" },
+                  { "tagnode" : "192.168.3.1", "distance" : 10 }
+               ]
+            },
+            {
+               "tagnode" : "20.0.0.0/8",
+               "blackhole" : { "distance" : 5 }
+            }
+         ],
+         "interface-route6" : [
+            {
+               "tagnode" : "2001::/64",
+               "next-hop-interface" : [ { "tagnode" : "dp0s1" } ]
+            }
+         ],
+         "table" : [
+            {
+               "tagnode" : 300,
+               "route" : [
+                  {
+                     "tagnode" : "30.0.0.0/8",
+                     "unreachable" : { "distance" : 1 }
+                  }
+               ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route" : [
+                     {
+                        "tagnode" : "40.0.0.0/8",
+                        "next-hop" : [ { "tagnode" : "192.168.4.1" } ]
+                     },
+                     {
+                        "tagnode" : "50.0.0.0/8",
+                        "next-hop" : [
+                           { "tagnode" : "192.168.5.1", "interface" : "dp0s9" }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	var lock sync.Mutex
+	active := false
+	daemonActive := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return active
+	}
+	setActive := func(a bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		active = a
+	}
+
+	h := newFakeRouteHandle()
+	f := static.NewStaticFallbackWithHandle(h, daemonActive,
+		5*time.Millisecond, 20*time.Millisecond)
+
+	err := f.Set(unmarshalConfig(t, translated_json))
+	if err == nil {
+		t.Error("Expected error for missing interface")
+	} else if !strings.Contains(err.Error(),
+		"[routing routing-instance RED protocols static route 50.0.0.0/8 "+
+			"next-hop 192.168.5.1]\ninterface dp0s9: Link not found") {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	installed := []string{
+		"1000 40.0.0.0/8 1 type 0 via 192.168.4.1 dev 0",
+		"254 10.0.0.0/8 1 type 0" +
+			" nexthop 192.168.1.1 dev 0 weight 2" +
+			" nexthop 192.168.2.1 dev 2 weight 1",
+		"254 10.0.0.0/8 10 type 0 via 192.168.3.1 dev 0",
+		fmt.Sprintf("254 20.0.0.0/8 5 type %d", syscall.RTN_BLACKHOLE),
+		"254 2001::/64 1 type 0 via <nil> dev 1",
+		fmt.Sprintf("300 30.0.0.0/8 1 type %d", syscall.RTN_UNREACHABLE),
+	}
+	checkInstalled(t, h, installed)
+
+	//Reconciling removes routes which are no longer configured
+	f.Set(unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "20.0.0.0/8",
+               "blackhole" : { "distance" : 5 }
+            }
+         ]
+      }
+   }
+}`)))
+	checkInstalled(t, h, installed[3:4])
+	if len(h.routes) != 2 {
+		t.Errorf("Route of another protocol removed")
+	}
+
+	//Routes are reconciled while the daemon is up until handed over
+	setActive(true)
+	f.Set(unmarshalConfig(t, translated_json))
+	checkInstalled(t, h, installed)
+
+	//and are handed over once the daemon has been up for long enough
+	go f.Run()
+	defer f.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.installed()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkInstalled(t, h, nil)
+
+	//and reinstated when it goes down
+	setActive(false)
+	deadline = time.Now().Add(5 * time.Second)
+	for len(h.installed()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkInstalled(t, h, installed)
+}
+
+func TestStaticFallbackRetry(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "20.0.0.0/8",
+               "blackhole" : { "distance" : 5 }
+            },
+            {
+               "tagnode" : "30.0.0.0/8",
+               "unreachable" : { "distance" : 1 }
+            }
+         ]
+      }
+   }
+}`))
+	installed := []string{
+		fmt.Sprintf("254 20.0.0.0/8 5 type %d", syscall.RTN_BLACKHOLE),
+		fmt.Sprintf("254 30.0.0.0/8 1 type %d", syscall.RTN_UNREACHABLE),
+	}
+
+	h := newFakeRouteHandle()
+	h.setFailing("30.0.0.0/8", true)
+	f := static.NewStaticFallbackWithHandle(h, func() bool { return false },
+		5*time.Millisecond, 20*time.Millisecond)
+
+	err := f.Set(cfg)
+	if err == nil || !strings.Contains(err.Error(),
+		"Failed to install route 30.0.0.0/8 table 254") {
+		t.Errorf("Expected error for failed route, got %v", err)
+	}
+	checkInstalled(t, h, installed[:1])
+
+	//A failed route is retried while the daemon is down
+	go f.Run()
+	defer f.Stop()
+
+	h.setFailing("30.0.0.0/8", false)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.installed()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkInstalled(t, h, installed)
+}
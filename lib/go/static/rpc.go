@@ -0,0 +1,261 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+)
+
+/* Name of the YANG module defining the static RPCs */
+const RPC_MODULE_NAME = "vyatta-protocols-static-v1"
+
+/*
+ * StaticRPC implements the RPCs of the vyatta-protocols-static-v1 model.
+ *
+ * A VCI component for a routing daemon which consumes the translated
+ * static configuration should register an instance with:
+ *
+ *     pmc.SetRPC(static.RPC_MODULE_NAME, static.NewStaticRPC(pmc))
+ */
+type StaticRPC struct {
+	pmc *protocols.ProtocolsModelComponent
+}
+
+func NewStaticRPC(pmc *protocols.ProtocolsModelComponent) *StaticRPC {
+	return &StaticRPC{pmc: pmc}
+}
+
+type PreviewRoutesInput struct {
+	Config string `rfc7951:"vyatta-protocols-static-v1:config,omitempty"`
+}
+
+type PreviewRoutesOutput struct {
+	Routes []FibRoute `rfc7951:"vyatta-protocols-static-v1:route,omitempty"`
+}
+
+/*
+ * Returns the internal JSON configuration to operate on, either the
+ * RFC 7951 encoded cfg if supplied or the daemon configuration.
+ */
+func (r *StaticRPC) loadConfig(cfg string) (map[string]interface{}, error) {
+	var cfg_json []byte
+	var err error
+
+	if cfg != "" {
+		cfg_json, err = protocols.ConvertConfigToInternalJson([]byte(cfg))
+	} else {
+		cfg_json, err = ioutil.ReadFile(r.pmc.GetDaemonConfigFilePath())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg_map map[string]interface{}
+	err = json.Unmarshal(cfg_json, &cfg_map)
+	return cfg_map, err
+}
+
+/*
+ * preview-routes RPC
+ *
+ * Computes the static routes which would be installed for either the
+ * supplied configuration or the running configuration, given the
+ * connected routes presently in the kernel. The kernel table of each
+ * route in a PBR table is added where the table exists.
+ */
+func (r *StaticRPC) PreviewRoutes(in *PreviewRoutesInput) (*PreviewRoutesOutput, error) {
+	cfg := []byte(in.Config)
+	var err error
+
+	//The daemon configuration has kernel rather than PBR table ids
+	if len(cfg) == 0 {
+		cfg, err = r.pmc.GetSystemConfig()
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	var cfg_map map[string]interface{}
+	if err == nil {
+		cfg, err = protocols.ConvertConfigToInternalJson(cfg)
+	}
+	if err == nil {
+		err = json.Unmarshal(cfg, &cfg_map)
+	}
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	connected, err := GetConnectedRoutes()
+	if err != nil {
+		log.Errorln("Failed to get connected routes: " + err.Error())
+		return nil, err
+	}
+
+	routes, err := ComputeFib(cfg_map, connected)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range routes {
+		if routes[i].Table != 0 {
+			routes[i].KernelTable, _ = tableRegistry.KernelTable(
+				routes[i].RoutingInstance, routes[i].Table)
+		}
+	}
+
+	return &PreviewRoutesOutput{Routes: routes}, nil
+}
+
+type ListTableUsersInput struct{}
+
+type ListTableUsersOutput struct {
+	Tables []TableUsers `rfc7951:"vyatta-protocols-static-v1:table,omitempty"`
+}
+
+/*
+ * list-table-users RPC
+ *
+ * Lists the users holding each PBR table in the table registry
+ */
+func (r *StaticRPC) ListTableUsers(in *ListTableUsersInput) (*ListTableUsersOutput, error) {
+	tables, err := tableRegistry.List()
+	if err != nil {
+		log.Errorln("Failed to list tables: " + err.Error())
+		return nil, err
+	}
+
+	return &ListTableUsersOutput{Tables: tables}, nil
+}
+
+type CollectTablesInput struct{}
+
+type CollectTablesOutput struct {
+	Tables []TableUsers `rfc7951:"vyatta-protocols-static-v1:table,omitempty"`
+}
+
+/*
+ * collect-tables RPC
+ *
+ * Deletes each PBR table in the table registry which is no longer held
+ * by any user, returning those which were deleted
+ */
+func (r *StaticRPC) CollectTables(in *CollectTablesInput) (*CollectTablesOutput, error) {
+	released, err := tableRegistry.Collect()
+	if err != nil {
+		log.Errorln("Failed to collect tables: " + err.Error())
+	}
+
+	out := &CollectTablesOutput{}
+	for _, key := range released {
+		out.Tables = append(out.Tables, TableUsers{
+			RoutingInstance: key.RoutingInstance,
+			Table:           key.Table,
+		})
+	}
+
+	return out, err
+}
+
+type GetTableMappingInput struct {
+	RoutingInstance string `rfc7951:"vyatta-protocols-static-v1:routing-instance,omitempty"`
+	Table           uint32 `rfc7951:"vyatta-protocols-static-v1:table,omitempty"`
+}
+
+type TableMapping struct {
+	RoutingInstance string `rfc7951:"routing-instance"`
+	Table           uint32 `rfc7951:"table"`
+	KernelTable     uint32 `rfc7951:"kernel-table"`
+}
+
+type GetTableMappingOutput struct {
+	Tables []TableMapping `rfc7951:"vyatta-protocols-static-v1:table,omitempty"`
+}
+
+/*
+ * get-table-mapping RPC
+ *
+ * Shows the kernel table of each PBR table held in the table registry,
+ * optionally only those of one routing-instance or table
+ */
+func (r *StaticRPC) GetTableMapping(in *GetTableMappingInput) (*GetTableMappingOutput, error) {
+	tables, err := tableRegistry.List()
+	if err != nil {
+		log.Errorln("Failed to list tables: " + err.Error())
+		return nil, err
+	}
+
+	out := &GetTableMappingOutput{}
+	for _, tbl := range tables {
+		if len(tbl.Users) == 0 ||
+			(in.RoutingInstance != "" && in.RoutingInstance != tbl.RoutingInstance) ||
+			(in.Table != 0 && in.Table != tbl.Table) {
+			continue
+		}
+
+		kernel_table, err := tableRegistry.KernelTable(tbl.RoutingInstance,
+			tbl.Table)
+		if err != nil {
+			log.Errorf("Failed to map %s %d: %s", tbl.RoutingInstance,
+				tbl.Table, err)
+			continue
+		}
+		out.Tables = append(out.Tables, TableMapping{
+			RoutingInstance: tbl.RoutingInstance,
+			Table:           tbl.Table,
+			KernelTable:     kernel_table,
+		})
+	}
+
+	return out, nil
+}
+
+type GetNeighborStatusInput struct {
+	RoutingInstance string `rfc7951:"vyatta-protocols-static-v1:routing-instance,omitempty"`
+}
+
+type GetNeighborStatusOutput struct {
+	Neighbors []NeighStatus `rfc7951:"vyatta-protocols-static-v1:neighbor,omitempty"`
+}
+
+/*
+ * get-neighbor-status RPC
+ *
+ * Compares each static ARP and IPv6 neighbour of the daemon configuration
+ * with the kernel neighbour table, optionally only those of one
+ * routing-instance
+ */
+func (r *StaticRPC) GetNeighborStatus(in *GetNeighborStatusInput) (*GetNeighborStatusOutput, error) {
+	cfg_map, err := r.loadConfig("")
+	if err != nil {
+		log.Errorln("Failed to load configuration: " + err.Error())
+		return nil, err
+	}
+
+	repairs, err := ReadNeighRepairs(neighRepairFile)
+	if err != nil {
+		log.Warningln("Failed to read neighbour repairs: " + err.Error())
+	}
+
+	statuses, err := GetNeighStatus(cfg_map, &netlinkNeighHandle{}, repairs)
+	if err != nil {
+		log.Errorln("Failed to get neighbour status: " + err.Error())
+		return nil, err
+	}
+
+	out := &GetNeighborStatusOutput{}
+	for _, status := range statuses {
+		if in.RoutingInstance == "" || in.RoutingInstance == status.RoutingInstance {
+			out.Neighbors = append(out.Neighbors, status)
+		}
+	}
+
+	return out, nil
+}
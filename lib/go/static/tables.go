@@ -0,0 +1,444 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	multierr "github.com/hashicorp/go-multierror"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+/* Name under which static routing holds the PBR tables it uses */
+const TABLE_USER_STATIC = "static"
+
+/* State of the table registry shared by all components on the system */
+const tableRegistryFile = "/run/routing/pbr-tables.json"
+
+/* Registry through which static routing acquires and releases tables */
+var tableRegistry = NewTableRegistry()
+
+/*
+ * Sets the registry through which static routing acquires and releases
+ * tables, returning the previous one
+ */
+func SetTableRegistry(r *TableRegistry) *TableRegistry {
+	old := tableRegistry
+	tableRegistry = r
+	return old
+}
+
+/*
+ * A PBR table of a routing-instance
+ */
+type TableKey struct {
+	RoutingInstance string
+	Table           uint32
+}
+
+func (k TableKey) String() string {
+	return fmt.Sprintf("table %d in routing-instance %s",
+		k.Table, k.RoutingInstance)
+}
+
+/*
+ * The users holding a PBR table, along with the kernel table it maps to
+ * once known. A table without users is yet to be released.
+ */
+type TableUsers struct {
+	RoutingInstance string   `json:"routing-instance" rfc7951:"routing-instance"`
+	Table           uint32   `json:"table" rfc7951:"table"`
+	KernelTable     uint32   `json:"kernel-table,omitempty" rfc7951:"kernel-table,omitempty"`
+	Users           []string `json:"users,omitempty" rfc7951:"user,omitempty"`
+}
+
+/*
+ * Creates and deletes the PBR tables of routing-instances,
+ * and maps them to kernel tables
+ */
+type TableManager interface {
+	AddTable(ri string, table uint32) error
+	DelTable(ri string, table uint32) error
+	KernelTable(ri string, table uint32) (uint32, error)
+}
+
+/*
+ * VrfManager manages tables using the VRF manager
+ */
+type VrfManager struct{}
+
+func vrfManagerCmd(op, ri string, table uint32) error {
+	out, err := exec.Command("/opt/vyatta/sbin/vrf-manager", op, ri,
+		strconv.FormatUint(uint64(table), 10)).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+func (m *VrfManager) AddTable(ri string, table uint32) error {
+	return vrfManagerCmd("--add-table", ri, table)
+}
+
+func (m *VrfManager) DelTable(ri string, table uint32) error {
+	return vrfManagerCmd("--del-table", ri, table)
+}
+
+func (m *VrfManager) KernelTable(ri string, table uint32) (uint32, error) {
+	out, err := exec.Command("/opt/vyatta/sbin/getvrftable", "--pbr-table",
+		ri, strconv.FormatUint(uint64(table), 10)).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	// Output may be formatted as a float
+	kernel_table, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad format for table id: %s", err)
+	}
+	return uint32(kernel_table), nil
+}
+
+/*
+ * TableRegistry records which users (static routing, PBR policy or other
+ * components) hold each PBR table, so that a table shared between them is
+ * created when first acquired and deleted only once released by all.
+ *
+ * The registry is kept in a file shared by all processes. A table whose
+ * deletion fails is retained without users until a later Collect(), or
+ * until it is acquired again and so created afresh. The kernel table of
+ * each table is cached until it is deleted or created afresh, so that
+ * the VRF manager is only consulted when a table is created.
+ */
+type TableRegistry struct {
+	lock    sync.Mutex
+	path    string
+	manager TableManager
+}
+
+func NewTableRegistry() *TableRegistry {
+	return NewTableRegistryWithFile(tableRegistryFile, &VrfManager{})
+}
+
+func NewTableRegistryWithFile(path string, manager TableManager) *TableRegistry {
+	return &TableRegistry{path: path, manager: manager}
+}
+
+type tableEntry struct {
+	users  map[string]bool
+	kernel uint32
+}
+
+/* Each table, as held in the registry file */
+type tableState map[TableKey]*tableEntry
+
+/*
+ * Returns the entry for key, adding it if absent
+ */
+func (state tableState) entry(key TableKey) *tableEntry {
+	if state[key] == nil {
+		state[key] = &tableEntry{users: make(map[string]bool)}
+	}
+	return state[key]
+}
+
+/*
+ * Calls fn with the current state of the registry, holding a lock on the
+ * registry file, and writes back the state if modify is set.
+ */
+func (r *TableRegistry) access(modify bool, fn func(state tableState)) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	how := syscall.LOCK_SH
+	if modify {
+		how = syscall.LOCK_EX
+	}
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	var tables []TableUsers
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &tables)
+		if err != nil {
+			return fmt.Errorf("Corrupt table registry %s: %s", r.path, err)
+		}
+	}
+
+	state := make(tableState)
+	for _, tbl := range tables {
+		entry := state.entry(TableKey{tbl.RoutingInstance, tbl.Table})
+		entry.kernel = tbl.KernelTable
+		for _, user := range tbl.Users {
+			entry.users[user] = true
+		}
+	}
+
+	fn(state)
+	if !modify {
+		return nil
+	}
+
+	data, err = json.Marshal(state.list())
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt(data, 0)
+	}
+	return err
+}
+
+/*
+ * Returns the tables of the state, sorted by routing-instance and table
+ */
+func (state tableState) list() []TableUsers {
+	tables := make([]TableUsers, 0, len(state))
+	for key, entry := range state {
+		tbl := TableUsers{RoutingInstance: key.RoutingInstance,
+			Table: key.Table, KernelTable: entry.kernel}
+		for user, _ := range entry.users {
+			tbl.Users = append(tbl.Users, user)
+		}
+		sort.Strings(tbl.Users)
+		tables = append(tables, tbl)
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].RoutingInstance != tables[j].RoutingInstance {
+			return tables[i].RoutingInstance < tables[j].RoutingInstance
+		}
+		return tables[i].Table < tables[j].Table
+	})
+	return tables
+}
+
+/*
+ * Records that user holds table of routing-instance ri, creating the
+ * table if it is not already held. A table without users, whose deletion
+ * failed, is created again and its cached kernel table forgotten, as it
+ * may no longer exist.
+ */
+func (r *TableRegistry) Acquire(user, ri string, table uint32) error {
+	key := TableKey{ri, table}
+	var add, known bool
+
+	err := r.access(true, func(state tableState) {
+		known = state[key] != nil
+		entry := state.entry(key)
+		add = len(entry.users) == 0
+		if add {
+			entry.kernel = 0
+		}
+		entry.users[user] = true
+	})
+	if err != nil || !add {
+		return err
+	}
+
+	log.Infof("Adding %s for %s", key, user)
+	err = r.manager.AddTable(ri, table)
+	if err != nil {
+		r.access(true, func(state tableState) {
+			delete(state.entry(key).users, user)
+			if len(state[key].users) == 0 && !known {
+				delete(state, key)
+			}
+		})
+		return fmt.Errorf("Failed to add table: %s", err)
+	}
+
+	return nil
+}
+
+/*
+ * Records that user no longer holds table of routing-instance ri,
+ * deleting the table if it is no longer held by any user. A table which
+ * is not in the registry, such as one created before the registry was
+ * used, is deleted.
+ */
+func (r *TableRegistry) Release(user, ri string, table uint32) error {
+	key := TableKey{ri, table}
+	var unknown, unused bool
+
+	err := r.access(true, func(state tableState) {
+		if state[key] == nil {
+			unknown = true
+			return
+		}
+		delete(state[key].users, user)
+		unused = len(state[key].users) == 0
+	})
+	if err != nil {
+		return err
+	}
+	if unknown {
+		log.Infof("Deleting %s, which is not in the table registry", key)
+		err = r.manager.DelTable(ri, table)
+		if err != nil {
+			return fmt.Errorf("Failed to delete table: %s", err)
+		}
+		return nil
+	}
+	if !unused {
+		return nil
+	}
+
+	_, err = r.delete(key)
+	return err
+}
+
+/*
+ * Deletes a table without users, forgetting it once deleted, and returns
+ * whether it was deleted. A table which has been acquired again since
+ * it was found to be unused is left in place. The registry is not
+ * locked while the VRF manager runs, as it consults the registry before
+ * deleting a table.
+ */
+func (r *TableRegistry) delete(key TableKey) (bool, error) {
+	var unused bool
+
+	err := r.access(true, func(state tableState) {
+		entry, ok := state[key]
+		unused = ok && len(entry.users) == 0
+	})
+	if err != nil || !unused {
+		return false, err
+	}
+
+	log.Infof("Deleting unused %s", key)
+	err = r.manager.DelTable(key.RoutingInstance, key.Table)
+	if err != nil {
+		log.Errorf("Failed to delete %s: %s", key, err)
+		return false, fmt.Errorf("Failed to delete table: %s", err)
+	}
+
+	return true, r.access(true, func(state tableState) {
+		if entry, ok := state[key]; ok && len(entry.users) == 0 {
+			delete(state, key)
+		}
+	})
+}
+
+/*
+ * Returns whether table of routing-instance ri is held by any user
+ */
+func (r *TableRegistry) InUse(ri string, table uint32) (bool, error) {
+	var in_use bool
+
+	err := r.access(false, func(state tableState) {
+		entry := state[TableKey{ri, table}]
+		in_use = entry != nil && len(entry.users) > 0
+	})
+	return in_use, err
+}
+
+/*
+ * Returns the users of each table in the registry
+ */
+func (r *TableRegistry) List() ([]TableUsers, error) {
+	var tables []TableUsers
+
+	err := r.access(false, func(state tableState) {
+		tables = state.list()
+	})
+	return tables, err
+}
+
+/*
+ * Deletes each orphaned table, which is held by no user, returning
+ * the tables which were released
+ */
+func (r *TableRegistry) Collect() ([]TableKey, error) {
+	var orphans []TableKey
+
+	err := r.access(false, func(state tableState) {
+		for _, tbl := range state.list() {
+			if len(tbl.Users) == 0 {
+				orphans = append(orphans,
+					TableKey{tbl.RoutingInstance, tbl.Table})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret_err := protocols.NewMultiError()
+	var released []TableKey
+	for _, key := range orphans {
+		deleted, err := r.delete(key)
+		if err != nil {
+			ret_err = multierr.Append(ret_err,
+				fmt.Errorf("%s: %s", key, err))
+			continue
+		}
+		if deleted {
+			released = append(released, key)
+		}
+	}
+
+	return released, ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the kernel table which table of routing-instance ri maps to,
+ * caching it if the table is held.
+ */
+func (r *TableRegistry) KernelTable(ri string, table uint32) (uint32, error) {
+	key := TableKey{ri, table}
+	var kernel_table uint32
+
+	err := r.access(false, func(state tableState) {
+		if state[key] != nil {
+			kernel_table = state[key].kernel
+		}
+	})
+	if err != nil || kernel_table != 0 {
+		return kernel_table, err
+	}
+
+	kernel_table, err = r.manager.KernelTable(ri, table)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Mapped %s to kernel table %d", key, kernel_table)
+
+	err = r.access(true, func(state tableState) {
+		if state[key] != nil {
+			state[key].kernel = kernel_table
+		}
+	})
+	return kernel_table, err
+}
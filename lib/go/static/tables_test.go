@@ -0,0 +1,351 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeTableManager struct {
+	ops     []string
+	failDel bool
+	failAdd uint32
+	failMap uint32
+	onDel   func()
+}
+
+func (m *fakeTableManager) AddTable(ri string, table uint32) error {
+	m.ops = append(m.ops, fmt.Sprintf("add %s %d", ri, table))
+	if table == m.failAdd {
+		return fmt.Errorf("no such routing-instance")
+	}
+	return nil
+}
+
+func (m *fakeTableManager) DelTable(ri string, table uint32) error {
+	m.ops = append(m.ops, fmt.Sprintf("del %s %d", ri, table))
+	if m.onDel != nil {
+		m.onDel()
+	}
+	if m.failDel {
+		return fmt.Errorf("table in use")
+	}
+	return nil
+}
+
+func (m *fakeTableManager) KernelTable(ri string, table uint32) (uint32, error) {
+	m.ops = append(m.ops, fmt.Sprintf("map %s %d", ri, table))
+	if table == m.failMap {
+		return 0, fmt.Errorf("no kernel table")
+	}
+	return 1000 + table, nil
+}
+
+func checkTables(t *testing.T, r *static.TableRegistry, expected string) {
+	t.Helper()
+
+	tables, err := r.List()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got := fmt.Sprint(tables); got != expected {
+		t.Errorf("Unexpected tables\nexpected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestTableRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &fakeTableManager{}
+	r := static.NewTableRegistryWithFile(filepath.Join(dir, "pbr-tables.json"), m)
+
+	for _, user := range []string{static.TABLE_USER_STATIC, "pbr"} {
+		if err := r.Acquire(user, "RED", 10); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}
+	r.Acquire(static.TABLE_USER_STATIC, "default", 20)
+	checkTables(t, r, "[{RED 10 0 [pbr static]} {default 20 0 [static]}]")
+
+	//The kernel table is looked up once and cached
+	for i := 0; i < 2; i++ {
+		if kt, _ := r.KernelTable("RED", 10); kt != 1010 {
+			t.Errorf("Unexpected kernel table %d", kt)
+		}
+	}
+	checkTables(t, r, "[{RED 10 1010 [pbr static]} {default 20 0 [static]}]")
+
+	//Another process shares the registry
+	other := static.NewTableRegistryWithFile(filepath.Join(dir, "pbr-tables.json"),
+		&fakeTableManager{})
+	if in_use, _ := other.InUse("RED", 10); !in_use {
+		t.Error("Table not in use by other process")
+	}
+
+	r.Release(static.TABLE_USER_STATIC, "RED", 10)
+	if in_use, _ := r.InUse("RED", 10); !in_use {
+		t.Error("Table released while still held")
+	}
+
+	//A table whose deletion fails is retained until collected
+	m.failDel = true
+	if err := r.Release("pbr", "RED", 10); err == nil {
+		t.Error("Expected error deleting table")
+	}
+	checkTables(t, r, "[{RED 10 1010 []} {default 20 0 [static]}]")
+	if in_use, _ := r.InUse("RED", 10); in_use {
+		t.Error("Released table still in use")
+	}
+
+	m.failDel = false
+	released, err := r.Collect()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if fmt.Sprint(released) != "[table 10 in routing-instance RED]" {
+		t.Errorf("Unexpected tables released: %v", released)
+	}
+	checkTables(t, r, "[{default 20 0 [static]}]")
+
+	//and refreshed once the table has been recreated
+	r.Acquire("pbr", "RED", 10)
+	r.KernelTable("RED", 10)
+
+	expected := "[add RED 10 add default 20 map RED 10 del RED 10 del RED 10" +
+		" add RED 10 map RED 10]"
+	if fmt.Sprint(m.ops) != expected {
+		t.Errorf("Unexpected table operations\nexpected: %s\ngot: %s",
+			expected, m.ops)
+	}
+}
+
+func TestTableRegistryCollectReacquired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pbr-tables.json")
+	m := &fakeTableManager{failDel: true}
+	r := static.NewTableRegistryWithFile(path, m)
+
+	for _, table := range []uint32{10, 20} {
+		r.Acquire("pbr", "RED", table)
+		r.Release("pbr", "RED", table)
+	}
+	checkTables(t, r, "[{RED 10 0 []} {RED 20 0 []}]")
+
+	//Table 20 is acquired by another process while table 10 is deleted
+	other := static.NewTableRegistryWithFile(path, &fakeTableManager{})
+	m.failDel = false
+	m.onDel = func() {
+		m.onDel = nil
+		other.Acquire(static.TABLE_USER_STATIC, "RED", 20)
+	}
+
+	released, err := r.Collect()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if fmt.Sprint(released) != "[table 10 in routing-instance RED]" {
+		t.Errorf("Unexpected tables released: %v", released)
+	}
+	checkTables(t, r, "[{RED 20 0 [static]}]")
+}
+
+func TestTableRegistryAcquireUnused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &fakeTableManager{failDel: true}
+	r := static.NewTableRegistryWithFile(filepath.Join(dir, "pbr-tables.json"), m)
+
+	for _, table := range []uint32{10, 20} {
+		r.Acquire("pbr", "RED", table)
+		r.KernelTable("RED", table)
+		r.Release("pbr", "RED", table)
+	}
+	checkTables(t, r, "[{RED 10 1010 []} {RED 20 1020 []}]")
+
+	//A table whose deletion failed is added again and mapped afresh
+	if err := r.Acquire(static.TABLE_USER_STATIC, "RED", 10); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	checkTables(t, r, "[{RED 10 0 [static]} {RED 20 1020 []}]")
+	if kt, _ := r.KernelTable("RED", 10); kt != 1010 {
+		t.Errorf("Unexpected kernel table %d", kt)
+	}
+
+	//and retained for collection if it cannot be added
+	m.failAdd = 20
+	if err := r.Acquire(static.TABLE_USER_STATIC, "RED", 20); err == nil {
+		t.Error("Expected error adding table")
+	}
+	checkTables(t, r, "[{RED 10 1010 [static]} {RED 20 0 []}]")
+
+	expected := "[add RED 10 map RED 10 del RED 10 add RED 20 map RED 20" +
+		" del RED 20 add RED 10 map RED 10 add RED 20]"
+	if fmt.Sprint(m.ops) != expected {
+		t.Errorf("Unexpected table operations\nexpected: %s\ngot: %s",
+			expected, m.ops)
+	}
+}
+
+func TestTableRegistryReleaseUnknown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &fakeTableManager{}
+	r := static.NewTableRegistryWithFile(filepath.Join(dir, "pbr-tables.json"), m)
+
+	//A table which is not in the registry is deleted
+	if err := r.Release(static.TABLE_USER_STATIC, "RED", 10); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	m.failDel = true
+	if err := r.Release(static.TABLE_USER_STATIC, "RED", 20); err == nil {
+		t.Error("Expected error deleting table")
+	}
+	checkTables(t, r, "[]")
+
+	expected := "[del RED 10 del RED 20]"
+	if fmt.Sprint(m.ops) != expected {
+		t.Errorf("Unexpected table operations\nexpected: %s\ngot: %s",
+			expected, m.ops)
+	}
+}
+
+func TestTranslateTablesFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &fakeTableManager{failAdd: 10, failMap: 30}
+	old := static.SetTableRegistry(static.NewTableRegistryWithFile(
+		filepath.Join(dir, "pbr-tables.json"), m))
+	defer static.SetTableRegistry(old)
+
+	cfg := unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "table" : [
+            {
+               "tagnode" : 10,
+               "route" : [ { "tagnode" : "10.0.0.0/8", "blackhole" : { } } ]
+            },
+            {
+               "tagnode" : 20,
+               "route" : [ { "tagnode" : "20.0.0.0/8", "blackhole" : { } } ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "table" : [
+                     {
+                        "tagnode" : 30,
+                        "route" : [ { "tagnode" : "30.0.0.0/8", "blackhole" : { } } ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`))
+
+	err = static.Translate(cfg, nil)
+	if err == nil {
+		t.Fatal("Expected error for tables which cannot be added")
+	}
+	for _, e := range []string{
+		"[protocols static table 10]\nFailed to add table: no such routing-instance",
+		"[routing routing-instance RED protocols static table 30]\n" +
+			"Failed to getvrftable: no kernel table",
+	} {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Error does not contain %q:\n%s", e, err)
+		}
+	}
+
+	//Only the table which was added is passed to the daemon
+	compareConfig(t, cfg, []byte(`{
+   "protocols" : {
+      "static" : {
+         "table" : [
+            {
+               "route" : [ { "blackhole" : { }, "tagnode" : "20.0.0.0/8" } ],
+               "tagnode" : 1020
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : { "static" : { } }
+         }
+      ]
+   }
+}`))
+}
+
+func TestTranslateTablesReleaseFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatalf("Failed to create registry dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &fakeTableManager{failDel: true}
+	old := static.SetTableRegistry(static.NewTableRegistryWithFile(
+		filepath.Join(dir, "pbr-tables.json"), m))
+	defer static.SetTableRegistry(old)
+
+	old_cfg := unmarshalConfig(t, []byte(`{
+   "protocols" : {
+      "static" : {
+         "table" : [
+            {
+               "tagnode" : 10,
+               "route" : [ { "tagnode" : "10.0.0.0/8", "blackhole" : { } } ]
+            }
+         ]
+      }
+   }
+}`))
+	cfg := unmarshalConfig(t, []byte(`{ "protocols" : { "static" : { } } }`))
+
+	err = static.Translate(cfg, old_cfg)
+	expected := "[protocols static table 10]\nFailed to delete table: table in use"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Error does not contain %q:\n%v", expected, err)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* Next-hop tracking methods */
+const (
+	TRACK_BFD  = "bfd"
+	TRACK_ICMP = "icmp"
+)
+
+/* Interval at which the state of BFD sessions is polled */
+const bfdPollInterval = time.Second
+
+/*
+ * The next-hop to be probed by a Prober
+ */
+type TrackTarget struct {
+	Address         net.IP
+	Interface       string
+	RoutingInstance string
+	Timeout         time.Duration
+}
+
+/*
+ * A Prober determines whether a tracked next-hop is currently reachable
+ */
+type Prober interface {
+	Probe(target TrackTarget) bool
+}
+
+/*
+ * The tracking configuration of a single next-hop
+ */
+type TrackSpec struct {
+	Method           string
+	Target           TrackTarget
+	Interval         time.Duration
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+type trackedNexthop struct {
+	spec      TrackSpec
+	down      bool
+	failures  int
+	successes int
+	stop      chan struct{}
+}
+
+/*
+ * NexthopTracker tracks the reachability of each static next-hop which
+ * has tracking configured, using the Prober registered for its method.
+ *
+ * Tracked next-hops are keyed by their config path. Before a configuration
+ * is translated, Withdraw() should be called to disable any next-hops which
+ * are currently unreachable. The changed callback is invoked whenever a
+ * next-hop is withdrawn or reinstated, at which point the configuration
+ * should be withdrawn, translated and sent to the daemon again.
+ */
+type NexthopTracker struct {
+	lock     sync.Mutex
+	probers  map[string]Prober
+	changed  func()
+	nexthops map[string]*trackedNexthop
+}
+
+/*
+ * Returns a tracker using the BFD and ICMP probers
+ */
+func NewNexthopTracker(changed func()) *NexthopTracker {
+	return NewNexthopTrackerWithProbers(map[string]Prober{
+		TRACK_BFD:  &BfdProber{},
+		TRACK_ICMP: &IcmpProber{},
+	}, changed)
+}
+
+func NewNexthopTrackerWithProbers(probers map[string]Prober,
+	changed func()) *NexthopTracker {
+	return &NexthopTracker{
+		probers:  probers,
+		changed:  changed,
+		nexthops: make(map[string]*trackedNexthop),
+	}
+}
+
+/*
+ * Calls fn for each next-hop with a gateway address in the untranslated
+ * static configuration of frontend_map, along with its config path and
+ * the routing-instance in which the gateway is reached.
+ */
+func walkNexthops(frontend_map map[string]interface{},
+	fn func(nh_map map[string]interface{}, lookup_ri, path string)) {
+	containers, _ := staticContainers(frontend_map)
+
+	walkRoutes := func(pmap map[string]interface{}, ri, path string) {
+		for _, info := range routeLists {
+			if info.nexthop != "next-hop" {
+				continue
+			}
+			route_arr, _ := pmap[info.key].([]interface{})
+			for _, route_entry := range route_arr {
+				route_map, ok := route_entry.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				route_path := EntryPath(path, info.key, route_map["tagnode"])
+
+				walkList := func(parent map[string]interface{}, lookup_ri, path string) {
+					nh_arr, _ := parent[info.nexthop].([]interface{})
+					for _, nh_entry := range nh_arr {
+						if nh_map, ok := nh_entry.(map[string]interface{}); ok {
+							fn(nh_map, lookup_ri, EntryPath(path,
+								info.nexthop, nexthopKey(nh_map)))
+						}
+					}
+				}
+				walkList(route_map, ri, route_path)
+
+				for _, inst_key := range [...]string{"next-hop-routing-instance",
+					"next-hop-routing-instance-v6"} {
+					inst_arr, _ := route_map[inst_key].([]interface{})
+					for _, inst_entry := range inst_arr {
+						inst_map, ok := inst_entry.(map[string]interface{})
+						if !ok {
+							continue
+						}
+						inst := fmt.Sprint(inst_map["routing-instance"])
+						walkList(inst_map, inst,
+							EntryPath(route_path, inst_key, inst))
+					}
+				}
+			}
+		}
+	}
+
+	ri_names := make([]string, 0, len(containers))
+	for ri, _ := range containers {
+		ri_names = append(ri_names, ri)
+	}
+	sort.Strings(ri_names)
+
+	for _, ri := range ri_names {
+		static_map := containers[ri]
+		path := StaticPath(ri)
+		walkRoutes(static_map, ri, path)
+
+		tbl_arr, _ := static_map["table"].([]interface{})
+		for _, tbl_entry := range tbl_arr {
+			if tbl_map, ok := tbl_entry.(map[string]interface{}); ok {
+				walkRoutes(tbl_map, ri,
+					EntryPath(path, "table", tbl_map["tagnode"]))
+			}
+		}
+	}
+}
+
+/*
+ * Returns the tracking configuration of nh_map, or nil if it is not tracked
+ */
+func trackSpec(nh_map map[string]interface{}, lookup_ri string) *TrackSpec {
+	track_map, ok := nh_map["track"].(map[string]interface{})
+	if !ok || IsNexthopDisabled(nh_map) {
+		return nil
+	}
+
+	spec := &TrackSpec{
+		Target: TrackTarget{
+			Address:         net.ParseIP(nexthopKey(nh_map)),
+			RoutingInstance: lookup_ri,
+		},
+	}
+	if spec.Target.Address == nil {
+		return nil
+	}
+	if iface, ok := nh_map["interface"].(string); ok {
+		spec.Target.Interface = iface
+	}
+
+	if _, exists := track_map[TRACK_BFD]; exists {
+		spec.Method = TRACK_BFD
+		spec.Interval = bfdPollInterval
+		spec.FailureThreshold = 1
+		spec.SuccessThreshold = 1
+		return spec
+	}
+
+	icmp_map, ok := track_map[TRACK_ICMP].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	spec.Method = TRACK_ICMP
+	spec.Interval = time.Duration(uintValue(icmp_map["interval"], 5000)) *
+		time.Millisecond
+	spec.Target.Timeout = time.Duration(uintValue(icmp_map["timeout"], 1000)) *
+		time.Millisecond
+	spec.FailureThreshold = int(uintValue(icmp_map["failure-threshold"], 3))
+	spec.SuccessThreshold = int(uintValue(icmp_map["success-threshold"], 1))
+	return spec
+}
+
+/*
+ * Starts tracking each next-hop with tracking configured in the
+ * untranslated configuration of frontend_map and stops tracking any
+ * others. The state of next-hops whose tracking is unchanged is retained.
+ */
+func (t *NexthopTracker) Configure(frontend_map map[string]interface{}) {
+	specs := make(map[string]*TrackSpec)
+	walkNexthops(frontend_map,
+		func(nh_map map[string]interface{}, lookup_ri, path string) {
+			if spec := trackSpec(nh_map, lookup_ri); spec != nil {
+				specs[path] = spec
+			}
+		})
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for path, tnh := range t.nexthops {
+		spec := specs[path]
+		if spec == nil || !trackSpecEqual(spec, &tnh.spec) {
+			log.Infoln("Stopped tracking " + path)
+			close(tnh.stop)
+			delete(t.nexthops, path)
+		}
+	}
+
+	for path, spec := range specs {
+		if t.nexthops[path] != nil {
+			continue
+		}
+		if t.probers[spec.Method] == nil {
+			log.Errorf("No prober for %s tracking of %s", spec.Method, path)
+			continue
+		}
+
+		log.Infof("Tracking %s using %s", path, spec.Method)
+		tnh := &trackedNexthop{spec: *spec, stop: make(chan struct{})}
+		t.nexthops[path] = tnh
+		go t.run(path, tnh)
+	}
+}
+
+func trackSpecEqual(a, b *TrackSpec) bool {
+	return a.Method == b.Method &&
+		a.Target.Address.Equal(b.Target.Address) &&
+		a.Target.Interface == b.Target.Interface &&
+		a.Target.RoutingInstance == b.Target.RoutingInstance &&
+		a.Target.Timeout == b.Target.Timeout &&
+		a.Interval == b.Interval &&
+		a.FailureThreshold == b.FailureThreshold &&
+		a.SuccessThreshold == b.SuccessThreshold
+}
+
+func (t *NexthopTracker) run(path string, tnh *trackedNexthop) {
+	ticker := time.NewTicker(tnh.spec.Interval)
+	defer ticker.Stop()
+
+	prober := t.probers[tnh.spec.Method]
+
+	for {
+		select {
+		case <-tnh.stop:
+			return
+		case <-ticker.C:
+		}
+
+		reachable := prober.Probe(tnh.spec.Target)
+
+		t.lock.Lock()
+		select {
+		case <-tnh.stop:
+			t.lock.Unlock()
+			return
+		default:
+		}
+		changed := tnh.update(reachable)
+		if changed {
+			if tnh.down {
+				log.Warnln("Withdrawing unreachable next-hop " + path)
+			} else {
+				log.Infoln("Reinstating reachable next-hop " + path)
+			}
+		}
+		t.lock.Unlock()
+
+		if changed && t.changed != nil {
+			t.changed()
+		}
+	}
+}
+
+/*
+ * Records the result of a probe, returning whether the next-hop
+ * has been withdrawn or reinstated as a result.
+ */
+func (tnh *trackedNexthop) update(reachable bool) bool {
+	if reachable {
+		tnh.failures = 0
+		tnh.successes++
+		if tnh.down && tnh.successes >= tnh.spec.SuccessThreshold {
+			tnh.down = false
+			return true
+		}
+	} else {
+		tnh.successes = 0
+		tnh.failures++
+		if !tnh.down && tnh.failures >= tnh.spec.FailureThreshold {
+			tnh.down = true
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Returns whether the next-hop at the given config path is tracked and
+ * currently withdrawn
+ */
+func (t *NexthopTracker) IsWithdrawn(path string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	tnh := t.nexthops[path]
+	return tnh != nil && tnh.down
+}
+
+/*
+ * Disables each withdrawn next-hop in the untranslated configuration
+ * frontend_map, so that Translate removes it.
+ */
+func (t *NexthopTracker) Withdraw(frontend_map map[string]interface{}) {
+	walkNexthops(frontend_map,
+		func(nh_map map[string]interface{}, lookup_ri, path string) {
+			if t.IsWithdrawn(path) {
+				nh_map["disable"] = nil
+			}
+		})
+}
+
+/*
+ * Stops tracking all next-hops
+ */
+func (t *NexthopTracker) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for path, tnh := range t.nexthops {
+		close(tnh.stop)
+		delete(t.nexthops, path)
+	}
+}
+
+/*
+ * Returns the arguments needed to run a command in routing-instance ri
+ */
+func routingInstanceCmd(ri string, args ...string) []string {
+	if ri == "" || ri == "default" {
+		return args
+	}
+
+	return append([]string{"/sbin/ip", "vrf", "exec", vrfDevicePrefix + ri},
+		args...)
+}
+
+/*
+ * IcmpProber probes a next-hop by sending a single ICMP echo request
+ */
+type IcmpProber struct {
+	//Path of the ping command, /bin/ping if empty
+	Ping string
+}
+
+func (p *IcmpProber) Probe(target TrackTarget) bool {
+	ping := p.Ping
+	if ping == "" {
+		ping = "/bin/ping"
+	}
+
+	//ping accepts a fractional number of seconds to wait for a reply
+	wait := strconv.FormatFloat(target.Timeout.Seconds(), 'f', -1, 64)
+
+	args := []string{ping, "-n", "-q", "-c", "1", "-W", wait}
+	if target.Interface != "" {
+		args = append(args, "-I", target.Interface)
+	}
+	args = append(args, target.Address.String())
+
+	args = routingInstanceCmd(target.RoutingInstance, args...)
+	return exec.Command(args[0], args[1:]...).Run() == nil
+}
+
+/*
+ * BfdProber determines the reachability of a next-hop from the state of
+ * the routing daemon's BFD session with it
+ */
+type BfdProber struct {
+	//Path of the vtysh command, /usr/bin/vtysh if empty
+	Vtysh string
+}
+
+func (p *BfdProber) Probe(target TrackTarget) bool {
+	vtysh := p.Vtysh
+	if vtysh == "" {
+		vtysh = "/usr/bin/vtysh"
+	}
+
+	cmd := "show bfd"
+	if target.RoutingInstance != "" && target.RoutingInstance != "default" {
+		cmd += " vrf " + target.RoutingInstance
+	}
+	cmd += " peer " + target.Address.String() + " json"
+
+	var peer struct {
+		Status string `json:"status"`
+	}
+	err := json.Unmarshal(protocols.ExecCmd([]string{vtysh, "-c", cmd}), &peer)
+	if err != nil {
+		return false
+	}
+
+	return peer.Status == "up"
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testProber struct {
+	lock      sync.Mutex
+	reachable map[string]bool
+}
+
+func (p *testProber) Probe(target static.TrackTarget) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.reachable[target.Address.String()]
+}
+
+func (p *testProber) set(addr string, reachable bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reachable[addr] = reachable
+}
+
+func waitChange(t *testing.T, changed chan struct{}) {
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for next-hop state change")
+	}
+}
+
+func TestNexthopTracker(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  {
+                     "tagnode" : "192.168.1.1",
+                     "track" : {
+                        "icmp" : {
+                           "interval" : 10,
+                           "timeout" : 5,
+                           "failure-threshold" : 2,
+                           "success-threshold" : 2
+                        }
+                     }
+                  },
+                  { "tagnode" : "192.168.1.2" }
+               ]
+            }
+         ]
+      }
+   }
+}`)
+	path := "protocols static route 10.0.0.0/8 next-hop 192.168.1.1"
+
+	prober := &testProber{reachable: map[string]bool{"192.168.1.1": true}}
+	changed := make(chan struct{}, 10)
+	tracker := static.NewNexthopTrackerWithProbers(
+		map[string]static.Prober{static.TRACK_ICMP: prober},
+		func() { changed <- struct{}{} })
+	defer tracker.Stop()
+
+	tracker.Configure(unmarshalConfig(t, input_json))
+	if tracker.IsWithdrawn(path) {
+		t.Fatal("Next-hop withdrawn before being probed")
+	}
+
+	prober.set("192.168.1.1", false)
+	waitChange(t, changed)
+	if !tracker.IsWithdrawn(path) {
+		t.Fatal("Unreachable next-hop not withdrawn")
+	}
+
+	cfg := unmarshalConfig(t, input_json)
+	tracker.Withdraw(cfg)
+	if err := static.Translate(cfg, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "next-hop" : [ { "tagnode" : "192.168.1.2" } ],
+               "tagnode" : "10.0.0.0/8"
+            }
+         ]
+      }
+   }
+}`)
+	compareConfig(t, cfg, expected_json)
+
+	prober.set("192.168.1.1", true)
+	waitChange(t, changed)
+	if tracker.IsWithdrawn(path) {
+		t.Fatal("Reachable next-hop not reinstated")
+	}
+
+	//Removing tracking stops the next-hop being withdrawn
+	prober.set("192.168.1.1", false)
+	tracker.Configure(unmarshalConfig(t, expected_json))
+	time.Sleep(50 * time.Millisecond)
+	if tracker.IsWithdrawn(path) {
+		t.Fatal("Untracked next-hop withdrawn")
+	}
+}
+
+/*
+ * Writes an executable stub command to dir, which records its arguments
+ * in name.args then runs script
+ */
+func writeStubCommand(t *testing.T, dir, name, script string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	stub := "#!/bin/sh\nprintf '%s\\n' \"$*\" >> " + path + ".args\n" + script
+	err := ioutil.WriteFile(path, []byte(stub), 0755)
+	if err != nil {
+		t.Fatalf("Failed to write stub %s: %s", name, err)
+	}
+	return path
+}
+
+func stubArgs(t *testing.T, path string) []string {
+	t.Helper()
+
+	args, err := ioutil.ReadFile(path + ".args")
+	if err != nil {
+		t.Fatalf("Failed to read stub arguments: %s", err)
+	}
+	os.Remove(path + ".args")
+	return strings.Split(strings.TrimSpace(string(args)), "\n")
+}
+
+func TestIcmpProber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "track")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//The stand-in responder only answers 192.0.2.1
+	ping := writeStubCommand(t, dir, "ping", `
+for arg; do last="$arg"; done
+[ "$last" = 192.0.2.1 ]
+`)
+	prober := &static.IcmpProber{Ping: ping}
+
+	tests := []struct {
+		target    static.TrackTarget
+		reachable bool
+		args      string
+	}{
+		{
+			target: static.TrackTarget{Address: net.ParseIP("192.0.2.1"),
+				Timeout: 1500 * time.Millisecond},
+			reachable: true,
+			args:      "-n -q -c 1 -W 1.5 192.0.2.1",
+		},
+		{
+			target: static.TrackTarget{Address: net.ParseIP("192.0.2.2"),
+				Interface: "dp0s1", Timeout: 200 * time.Millisecond},
+			reachable: false,
+			args:      "-n -q -c 1 -W 0.2 -I dp0s1 192.0.2.2",
+		},
+	}
+
+	for _, test := range tests {
+		addr := test.target.Address.String()
+		if reachable := prober.Probe(test.target); reachable != test.reachable {
+			t.Errorf("%s: expected reachable %v, got %v", addr,
+				test.reachable, reachable)
+		}
+		if args := stubArgs(t, ping); len(args) != 1 || args[0] != test.args {
+			t.Errorf("%s: expected ping %q, got %q", addr, test.args, args)
+		}
+	}
+}
+
+func TestBfdProber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "track")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vtysh := writeStubCommand(t, dir, "vtysh", `
+case "$2" in
+*"peer 192.0.2.1 json")
+	echo '{"multihop":false,"peer":"192.0.2.1","id":1,"status":"up","uptime":10}';;
+*"peer 192.0.2.2 json")
+	echo '{"multihop":false,"peer":"192.0.2.2","id":2,"status":"down","downtime":5}';;
+*)
+	echo '% No BFD peer found'; exit 1;;
+esac
+`)
+	prober := &static.BfdProber{Vtysh: vtysh}
+
+	tests := []struct {
+		target    static.TrackTarget
+		reachable bool
+		args      string
+	}{
+		{
+			target:    static.TrackTarget{Address: net.ParseIP("192.0.2.1")},
+			reachable: true,
+			args:      "-c show bfd peer 192.0.2.1 json",
+		},
+		{
+			target: static.TrackTarget{Address: net.ParseIP("192.0.2.2"),
+				RoutingInstance: "default"},
+			reachable: false,
+			args:      "-c show bfd peer 192.0.2.2 json",
+		},
+		{
+			target: static.TrackTarget{Address: net.ParseIP("192.0.2.3"),
+				RoutingInstance: "RED"},
+			reachable: false,
+			args:      "-c show bfd vrf RED peer 192.0.2.3 json",
+		},
+	}
+
+	for _, test := range tests {
+		addr := test.target.Address.String()
+		if reachable := prober.Probe(test.target); reachable != test.reachable {
+			t.Errorf("%s: expected reachable %v, got %v", addr,
+				test.reachable, reachable)
+		}
+		if args := stubArgs(t, vtysh); len(args) != 1 || args[0] != test.args {
+			t.Errorf("%s: expected vtysh %q, got %q", addr, test.args, args)
+		}
+	}
+}
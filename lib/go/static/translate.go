@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, AT&T Intellectual Property.
+// Copyright (c) 2018-2019, 2021, AT&T Intellectual Property.
 // All rights reserved.
 //
 // SPDX-License-Identifier: MPL-2.0
@@ -6,22 +6,90 @@
 package static
 
 import (
+	"eng.vyatta.net/protocols"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"os/exec"
+	multierr "github.com/hashicorp/go-multierror"
 	"strconv"
+	"strings"
 )
 
-func MapByKey(arr []interface{}, key_name string) map[string]map[string]interface{} {
+/*
+ * Returns an error carrying the config path at which it was detected
+ */
+func pathError(path string, format string, a ...interface{}) error {
+	return protocols.AddErrorContext(fmt.Errorf(format, a...), path)
+}
+
+/*
+ * Returns the config path of the static container in routing-instance ri
+ */
+func StaticPath(ri string) string {
+	if ri == "" || ri == "default" {
+		return "protocols static"
+	}
+
+	return "routing routing-instance " + ri + " protocols static"
+}
+
+/*
+ * Returns the config path of the list entry with the given key under path
+ */
+func EntryPath(path, list string, key interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %v", path, list, key))
+}
+
+/*
+ * Returns the map stored at key in pmap, or nil if there is none
+ */
+func getMap(pmap map[string]interface{}, key, path string) (map[string]interface{}, error) {
+	if pmap == nil || pmap[key] == nil {
+		return nil, nil
+	}
+
+	ret, ok := pmap[key].(map[string]interface{})
+	if !ok {
+		return nil, pathError(path, "%s: expected container, got %T",
+			key, pmap[key])
+	}
+
+	return ret, nil
+}
+
+/*
+ * Returns the list stored at key in pmap, or nil if there is none
+ */
+func getList(pmap map[string]interface{}, key, path string) ([]interface{}, error) {
+	if pmap == nil || pmap[key] == nil {
+		return nil, nil
+	}
+
+	ret, ok := pmap[key].([]interface{})
+	if !ok {
+		return nil, pathError(path, "%s: expected list, got %T",
+			key, pmap[key])
+	}
+
+	return ret, nil
+}
+
+func MapByKey(arr []interface{}, key_name string) (map[string]map[string]interface{}, error) {
 	pmap := make(map[string]map[string]interface{})
 
 	for _, entry := range arr {
-		entry_map := entry.(map[string]interface{})
+		entry_map, ok := entry.(map[string]interface{})
+		if !ok {
+			return pmap, fmt.Errorf("expected list entry, got %T", entry)
+		}
+		if entry_map[key_name] == nil {
+			return pmap, errors.New("list entry missing key " + key_name)
+		}
 		key := fmt.Sprint(entry_map[key_name])
 		pmap[key] = entry_map
 	}
 
-	return pmap
+	return pmap, nil
 }
 
 func IsNexthopDisabled(nh_map map[string]interface{}) bool {
@@ -33,22 +101,26 @@ func IsNexthopDisabled(nh_map map[string]interface{}) bool {
 	return disabled
 }
 
-func TranslateNexthops(pmap map[string]interface{}, key string) {
-	if pmap == nil || pmap[key] == nil {
-		return
-	}
-
-	nh_arr := pmap[key].([]interface{})
+func TranslateNexthops(pmap map[string]interface{}, key, path string) error {
+	nh_arr, err := getList(pmap, key, path)
 	if nh_arr == nil {
-		return
+		return err
 	}
 
+	ret_err := protocols.NewMultiError()
 	deleted := false
 
 	//Reverse order so can delete disabled nexthops
 	for i := len(nh_arr) - 1; i >= 0; i-- {
 		nh_entry := nh_arr[i]
-		nh_map := nh_entry.(map[string]interface{})
+		nh_map, ok := nh_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: expected list entry, got %T", key, nh_entry))
+			nh_arr = append(nh_arr[:i], nh_arr[i+1:]...)
+			deleted = true
+			continue
+		}
 
 		//Remove from array if disabled
 		if IsNexthopDisabled(nh_map) {
@@ -62,6 +134,17 @@ func TranslateNexthops(pmap map[string]interface{}, key string) {
 				nh_map["tagnode"] = nh_map["interface-name"]
 				delete(nh_map, "interface-name")
 			}
+			if nh_map["tagnode"] == nil {
+				ret_err = multierr.Append(ret_err, pathError(path,
+					"%s: list entry missing key", key))
+			}
+
+			ret_err = multierr.Append(ret_err, TranslateLabels(nh_map,
+				EntryPath(path, key, nh_map["tagnode"])))
+
+			//Tracking is performed by a NexthopTracker, which
+			//withdraws a next-hop by disabling it before translation.
+			delete(nh_map, "track")
 		}
 	}
 
@@ -73,26 +156,35 @@ func TranslateNexthops(pmap map[string]interface{}, key string) {
 			pmap[key] = nh_arr
 		}
 	}
+
+	return ret_err.ErrorOrNil()
 }
 
 func TranslateNexthopInstances(pmap map[string]interface{}, pkey string,
-	nkey string) {
-	if pmap == nil || pmap[pkey] == nil {
-		return
-	}
-
-	inst_arr := pmap[pkey].([]interface{})
+	nkey string, path string) error {
+	inst_arr, err := getList(pmap, pkey, path)
 	if inst_arr == nil {
-		return
+		return err
 	}
 
+	ret_err := protocols.NewMultiError()
 	deleted := false
 
 	//Reverse order so can delete disabled instances
 	for i := len(inst_arr) - 1; i >= 0; i-- {
 		inst_entry := inst_arr[i]
-		inst_entry_map := inst_entry.(map[string]interface{})
-		TranslateNexthops(inst_entry_map, nkey)
+		inst_entry_map, ok := inst_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: expected list entry, got %T", pkey, inst_entry))
+			inst_arr = append(inst_arr[:i], inst_arr[i+1:]...)
+			deleted = true
+			continue
+		}
+
+		inst_path := EntryPath(path, pkey, inst_entry_map["routing-instance"])
+		ret_err = multierr.Append(ret_err,
+			TranslateNexthops(inst_entry_map, nkey, inst_path))
 		if inst_entry_map[nkey] == nil {
 			inst_arr = append(inst_arr[:i], inst_arr[i+1:]...)
 			deleted = true
@@ -107,27 +199,88 @@ func TranslateNexthopInstances(pmap map[string]interface{}, pkey string,
 			pmap[pkey] = inst_arr
 		}
 	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Removes the weight of any next-hop of a route which, once disabled
+ * next-hops have been removed, is the only one with its distance and so
+ * is not part of a multipath route.
+ */
+func TranslateWeights(route_map map[string]interface{}, nkey string) {
+	var nh_maps []map[string]interface{}
+
+	nh_lists := []interface{}{route_map[nkey]}
+	inst_arr, _ := route_map["next-hop-routing-instance"].([]interface{})
+	for _, inst_entry := range inst_arr {
+		if inst_map, ok := inst_entry.(map[string]interface{}); ok {
+			nh_lists = append(nh_lists, inst_map[nkey])
+		}
+	}
+
+	distances := make(map[uint32]int)
+	for _, nh_list := range nh_lists {
+		nh_arr, _ := nh_list.([]interface{})
+		for _, nh_entry := range nh_arr {
+			if nh_map, ok := nh_entry.(map[string]interface{}); ok {
+				nh_maps = append(nh_maps, nh_map)
+				distances[uintValue(nh_map["distance"], 1)]++
+			}
+		}
+	}
+
+	for _, nh_map := range nh_maps {
+		if distances[uintValue(nh_map["distance"], 1)] < 2 {
+			delete(nh_map, "weight")
+		}
+	}
 }
 
-func TranslateRoutes(pmap map[string]interface{}, pkey string, nkey string) {
-	if pmap == nil || pmap[pkey] == nil {
-		return
+/*
+ * Returns whether a translated route has anything other than its key
+ * and the attributes which apply to its paths, such as its source.
+ */
+func routeHasPaths(route_map map[string]interface{}) bool {
+	for key, _ := range route_map {
+		if key != "tagnode" && key != "source" {
+			return true
+		}
 	}
 
-	route_arr := pmap[pkey].([]interface{})
+	return false
+}
+
+func TranslateRoutes(pmap map[string]interface{}, pkey, nkey, path string) error {
+	route_arr, err := getList(pmap, pkey, path)
 	if route_arr == nil {
-		return
+		return err
 	}
 
+	ret_err := protocols.NewMultiError()
 	deleted := false
 
 	//Reverse order so can delete disabled routes
 	for i := len(route_arr) - 1; i >= 0; i-- {
 		route_entry := route_arr[i]
-		route_entry_map := route_entry.(map[string]interface{})
+		route_entry_map, ok := route_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: expected list entry, got %T", pkey, route_entry))
+			route_arr = append(route_arr[:i], route_arr[i+1:]...)
+			deleted = true
+			continue
+		}
+
+		route_path := EntryPath(path, pkey, route_entry_map["tagnode"])
+		if route_entry_map["tagnode"] == nil {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: list entry missing key", pkey))
+		}
 
 		//Translate next-hop[-interface]s
-		TranslateNexthops(route_entry_map, nkey)
+		ret_err = multierr.Append(ret_err,
+			TranslateNexthops(route_entry_map, nkey, route_path))
 		nh_if := route_entry_map[nkey]
 		if nh_if == nil {
 			delete(route_entry_map, nkey)
@@ -143,15 +296,22 @@ func TranslateRoutes(pmap map[string]interface{}, pkey string, nkey string) {
 		}
 
 		//Translate next-hop-routing-instances
-		TranslateNexthopInstances(route_entry_map,
-			"next-hop-routing-instance", nkey)
+		ret_err = multierr.Append(ret_err,
+			TranslateNexthopInstances(route_entry_map,
+				"next-hop-routing-instance", nkey, route_path))
 		inst_if := route_entry_map["next-hop-routing-instance"]
 		if inst_if == nil {
 			delete(route_entry_map, "next-hop-routing-instance")
 		}
 
-		//Delete route itself if only tagnode is defined
-		if len(route_entry_map) <= 1 {
+		//DHCP gateways are resolved by a DhcpGatewayWatcher, which
+		//replaces them with next-hops before translation.
+		delete(route_entry_map, "dhcp-interface")
+
+		TranslateWeights(route_entry_map, nkey)
+
+		//Delete route itself if only tagnode and source are defined
+		if !routeHasPaths(route_entry_map) {
 			route_arr = append(route_arr[:i], route_arr[i+1:]...)
 			deleted = true
 		}
@@ -165,170 +325,335 @@ func TranslateRoutes(pmap map[string]interface{}, pkey string, nkey string) {
 			pmap[pkey] = route_arr
 		}
 	}
+
+	return ret_err.ErrorOrNil()
 }
 
-func TranslateTables(pmap, old_pmap map[string]interface{}, key string, ri string) {
+/*
+ * Translates the static multicast RPF routes in list key of pmap,
+ * removing disabled next-hops and next-hop-interfaces and any route
+ * left with neither.
+ */
+func TranslateMroutes(pmap map[string]interface{}, key, path string) error {
+	route_arr, err := getList(pmap, key, path)
+	if route_arr == nil {
+		return err
+	}
+
+	ret_err := protocols.NewMultiError()
+	deleted := false
+
+	//Reverse order so can delete routes left without next-hops
+	for i := len(route_arr) - 1; i >= 0; i-- {
+		route_entry := route_arr[i]
+		route_entry_map, ok := route_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: expected list entry, got %T", key, route_entry))
+			route_arr = append(route_arr[:i], route_arr[i+1:]...)
+			deleted = true
+			continue
+		}
+
+		route_path := EntryPath(path, key, route_entry_map["tagnode"])
+		if route_entry_map["tagnode"] == nil {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: list entry missing key", key))
+		}
+
+		for _, nkey := range [...]string{"next-hop", "next-hop-interface"} {
+			ret_err = multierr.Append(ret_err,
+				TranslateNexthops(route_entry_map, nkey, route_path))
+		}
+
+		if route_entry_map["next-hop"] == nil &&
+			route_entry_map["next-hop-interface"] == nil {
+			route_arr = append(route_arr[:i], route_arr[i+1:]...)
+			deleted = true
+		}
+	}
+
+	//Delete parent if all routes have gone
+	if deleted {
+		if len(route_arr) == 0 {
+			delete(pmap, key)
+		} else {
+			pmap[key] = route_arr
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Translates each of the route lists found in a static container or table
+ */
+func translateRouteLists(pmap map[string]interface{}, path string) error {
+	ret_err := protocols.NewMultiError()
+
+	ret_err = multierr.Append(ret_err, TranslateRoutes(pmap,
+		"interface-route", "next-hop-interface", path))
+	ret_err = multierr.Append(ret_err, TranslateRoutes(pmap,
+		"interface-route6", "next-hop-interface", path))
+	ret_err = multierr.Append(ret_err, TranslateRoutes(pmap,
+		"route", "next-hop", path))
+	ret_err = multierr.Append(ret_err, TranslateRoutes(pmap,
+		"route6", "next-hop", path))
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Translates the tables in list key of pmap to the kernel tables they
+ * map to, acquiring each new table and releasing each table of old_pmap
+ * which has gone. A table which cannot be acquired or mapped is removed,
+ * rather than its routes being passed to the daemon in the wrong table.
+ */
+func TranslateTables(pmap, old_pmap map[string]interface{}, key, ri, path string) error {
 	if pmap[key] == nil && old_pmap[key] == nil {
-		return
+		return nil
 	}
 
-	var tbl_arr, old_tbl_arr []interface{}
+	ret_err := protocols.NewMultiError()
+
+	tbl_arr, err := getList(pmap, key, path)
+	ret_err = multierr.Append(ret_err, err)
+	old_tbl_arr, err := getList(old_pmap, key, path)
+	ret_err = multierr.Append(ret_err, err)
 
-	if pmap[key] == nil {
-		tbl_arr = make([]interface{}, 0)
-	} else {
-		tbl_arr = pmap[key].([]interface{})
+	tbl_map, err := MapByKey(tbl_arr, "tagnode")
+	if err != nil {
+		ret_err = multierr.Append(ret_err,
+			protocols.AddErrorContext(err, EntryPath(path, key, "")))
 	}
-	if old_pmap[key] == nil {
-		old_tbl_arr = make([]interface{}, 0)
-	} else {
-		old_tbl_arr = old_pmap[key].([]interface{})
+	old_tbl_map, err := MapByKey(old_tbl_arr, "tagnode")
+	if err != nil {
+		ret_err = multierr.Append(ret_err,
+			protocols.AddErrorContext(err, EntryPath(path, key, "")))
 	}
 
-	tbl_map := MapByKey(tbl_arr, "tagnode")
-	old_tbl_map := MapByKey(old_tbl_arr, "tagnode")
+	//Reverse order so can delete tables which cannot be translated
+	deleted := false
+	for i := len(tbl_arr) - 1; i >= 0; i-- {
+		tbl_entry_map, ok := tbl_arr[i].(map[string]interface{})
+		if !ok || tbl_entry_map["tagnode"] == nil {
+			tbl_arr = append(tbl_arr[:i], tbl_arr[i+1:]...)
+			deleted = true
+			continue
+		}
 
-	for table_id, tbl_entry_map := range tbl_map {
-		exec.Command("/opt/vyatta/sbin/vrf-manager", "--add-table", ri, table_id).Run()
+		table_id := fmt.Sprint(tbl_entry_map["tagnode"])
+		tbl_path := EntryPath(path, key, table_id)
 
-		out, err := exec.Command("/opt/vyatta/sbin/getvrftable", "--pbr-table", ri, table_id).Output()
+		table, err := strconv.ParseUint(table_id, 10, 32)
 		if err != nil {
-			msg := "Failed to getvrftable: " + err.Error()
-			log.Errorln(msg)
-			return
+			ret_err = multierr.Append(ret_err, pathError(tbl_path,
+				"Bad table id: %s", err))
+			tbl_arr = append(tbl_arr[:i], tbl_arr[i+1:]...)
+			deleted = true
+			continue
 		}
-		log.Infoln("Translated table " + table_id + " to " + string(out))
-		// Again, float - strange but true
-		new_table_id, err := strconv.ParseFloat(string(out), 64)
+
+		err = tableRegistry.Acquire(TABLE_USER_STATIC, ri, uint32(table))
 		if err != nil {
-			msg := "Bad format for table id: " + err.Error()
+			log.Errorln(err.Error())
+			ret_err = multierr.Append(ret_err, pathError(tbl_path, "%s", err))
+			tbl_arr = append(tbl_arr[:i], tbl_arr[i+1:]...)
+			deleted = true
+			continue
+		}
+
+		kernel_table, err := tableRegistry.KernelTable(ri, uint32(table))
+		if err != nil {
+			msg := "Failed to getvrftable: " + err.Error()
 			log.Errorln(msg)
-			return
+			ret_err = multierr.Append(ret_err, pathError(tbl_path, "%s", msg))
+			tbl_arr = append(tbl_arr[:i], tbl_arr[i+1:]...)
+			deleted = true
+			continue
 		}
+		// Again, float - strange but true
+		new_table_id := float64(kernel_table)
 		tbl_entry_map["tagnode"] = new_table_id
 
-		TranslateRoutes(tbl_entry_map, "interface-route",
-			"next-hop-interface")
-		TranslateRoutes(tbl_entry_map, "interface-route6",
-			"next-hop-interface")
-		TranslateRoutes(tbl_entry_map, "route", "next-hop")
-		TranslateRoutes(tbl_entry_map, "route6", "next-hop")
+		ret_err = multierr.Append(ret_err,
+			translateRouteLists(tbl_entry_map, tbl_path))
+	}
+
+	//Delete parent if all tables have gone
+	if deleted {
+		if len(tbl_arr) == 0 {
+			delete(pmap, key)
+		} else {
+			pmap[key] = tbl_arr
+		}
 	}
 
 	for table_id, _ := range old_tbl_map {
 		if tbl_map[table_id] == nil {
-			log.Infoln("Requesting delete of table " + table_id + " in VRF " + ri)
-			exec.Command("/opt/vyatta/sbin/vrf-manager", "--del-table", ri, table_id).Run()
+			table, err := strconv.ParseUint(table_id, 10, 32)
+			if err != nil {
+				continue
+			}
+			log.Infoln("Releasing table " + table_id + " in VRF " + ri)
+			err = tableRegistry.Release(TABLE_USER_STATIC, ri, uint32(table))
+			if err != nil {
+				log.Errorln(err.Error())
+				ret_err = multierr.Append(ret_err,
+					pathError(EntryPath(path, key, table_id), "%s", err))
+			}
 		}
 	}
 
+	return ret_err.ErrorOrNil()
 }
 
-func TranslateProtocols(proto_if, old_proto_if interface{}, ri string) {
+func TranslateProtocols(proto_if, old_proto_if interface{}, ri string) error {
 	if proto_if == nil && old_proto_if == nil {
-		return
+		return nil
 	}
 
+	path := StaticPath(ri)
+	proto_path := strings.TrimSuffix(path, " static")
+
 	var proto_map, old_proto_map map[string]interface{}
+	var ok bool
+
 	if proto_if == nil {
 		proto_map = make(map[string]interface{})
-	} else {
-		proto_map = proto_if.(map[string]interface{})
+	} else if proto_map, ok = proto_if.(map[string]interface{}); !ok {
+		return pathError(proto_path, "expected container, got %T", proto_if)
 	}
 	if old_proto_if == nil {
 		old_proto_map = make(map[string]interface{})
-	} else {
-		old_proto_map = old_proto_if.(map[string]interface{})
+	} else if old_proto_map, ok = old_proto_if.(map[string]interface{}); !ok {
+		return pathError(proto_path, "expected container, got %T", old_proto_if)
 	}
 
 	if proto_map["static"] == nil && old_proto_map["static"] == nil {
-		return
+		return nil
 	}
 
-	var static_map, old_static_map map[string]interface{}
+	static_map, err := getMap(proto_map, "static", proto_path)
+	if err != nil {
+		return err
+	}
+	old_static_map, err := getMap(old_proto_map, "static", proto_path)
+	if err != nil {
+		return err
+	}
 
-	if proto_map["static"] == nil {
+	if static_map == nil {
 		static_map = make(map[string]interface{})
-	} else {
-		static_map = proto_map["static"].(map[string]interface{})
 	}
-	if old_proto_map["static"] == nil {
+	if old_static_map == nil {
 		old_static_map = make(map[string]interface{})
-	} else {
-		old_static_map = old_proto_map["static"].(map[string]interface{})
 	}
 
-	TranslateRoutes(static_map, "interface-route", "next-hop-interface")
-	TranslateRoutes(static_map, "interface-route6", "next-hop-interface")
-	TranslateRoutes(static_map, "route", "next-hop")
-	TranslateRoutes(static_map, "route6", "next-hop")
-	TranslateTables(static_map, old_static_map, "table", ri)
+	ret_err := protocols.NewMultiError()
+
+	ret_err = multierr.Append(ret_err, translateRouteLists(static_map, path))
+	ret_err = multierr.Append(ret_err,
+		TranslateMroutes(static_map, "mroute", path))
+	ret_err = multierr.Append(ret_err,
+		TranslateMroutes(static_map, "mroute6", path))
+	ret_err = multierr.Append(ret_err,
+		TranslateTables(static_map, old_static_map, "table", ri, path))
+
+	return ret_err.ErrorOrNil()
 }
 
-func TranslateRouting(routing_if, old_routing_if interface{}) {
+func TranslateRouting(routing_if, old_routing_if interface{}) error {
 	if routing_if == nil && old_routing_if == nil {
-		return
+		return nil
 	}
 
 	var old_routing_map, routing_map map[string]interface{}
+	var ok bool
 
 	if old_routing_if == nil {
 		// make it valid for convenience
 		old_routing_map = make(map[string]interface{})
-	} else {
-		old_routing_map = old_routing_if.(map[string]interface{})
+	} else if old_routing_map, ok = old_routing_if.(map[string]interface{}); !ok {
+		return pathError("routing", "expected container, got %T", old_routing_if)
 	}
 
 	if routing_if == nil {
 		// make it valid for convenience
 		routing_map = make(map[string]interface{})
-	} else {
-		routing_map = routing_if.(map[string]interface{})
+	} else if routing_map, ok = routing_if.(map[string]interface{}); !ok {
+		return pathError("routing", "expected container, got %T", routing_if)
 	}
 
-	var ri_arr, old_ri_arr []interface{}
-
 	if routing_map["routing-instance"] == nil &&
 		old_routing_map["routing-instance"] == nil {
-		return
+		return nil
 	}
 
-	if routing_map["routing-instance"] == nil {
-		ri_arr = make([]interface{}, 0)
-	} else {
-		ri_arr = routing_map["routing-instance"].([]interface{})
+	ret_err := protocols.NewMultiError()
+
+	ri_arr, err := getList(routing_map, "routing-instance", "routing")
+	if err != nil {
+		return err
 	}
-	if old_routing_map["routing-instance"] == nil {
-		old_ri_arr = make([]interface{}, 0)
-	} else {
-		old_ri_arr = old_routing_map["routing-instance"].([]interface{})
+	old_ri_arr, err := getList(old_routing_map, "routing-instance", "routing")
+	if err != nil {
+		return err
 	}
 
-	ri_map_by_key := MapByKey(ri_arr, "instance-name")
-	old_ri_map_by_key := MapByKey(old_ri_arr, "instance-name")
+	ri_map_by_key, err := MapByKey(ri_arr, "instance-name")
+	if err != nil {
+		ret_err = multierr.Append(ret_err,
+			protocols.AddErrorContext(err, "routing routing-instance"))
+	}
+	old_ri_map_by_key, err := MapByKey(old_ri_arr, "instance-name")
+	if err != nil {
+		ret_err = multierr.Append(ret_err,
+			protocols.AddErrorContext(err, "routing routing-instance"))
+	}
 
 	for key, ri_entry_map := range ri_map_by_key {
 		old_ri_entry_map := old_ri_map_by_key[key]
 		if old_ri_entry_map == nil {
 			old_ri_entry_map = make(map[string]interface{})
 		}
-		TranslateProtocols(ri_entry_map["protocols"],
-			old_ri_entry_map["protocols"], key)
+		ret_err = multierr.Append(ret_err,
+			TranslateProtocols(ri_entry_map["protocols"],
+				old_ri_entry_map["protocols"], key))
 	}
 	for key, old_ri_entry_map := range old_ri_map_by_key {
 		ri_entry := ri_map_by_key[key]
 		if ri_entry == nil {
-			TranslateProtocols(nil,
-				old_ri_entry_map["protocols"], key)
+			ret_err = multierr.Append(ret_err,
+				TranslateProtocols(nil,
+					old_ri_entry_map["protocols"], key))
 		}
 	}
+
+	return ret_err.ErrorOrNil()
 }
 
-func Translate(frontend_map, old_frontend_map map[string]interface{}) {
-	if frontend_map != nil || old_frontend_map != nil {
+/*
+ * Translates the static configuration in frontend_map in place into the
+ * format expected by the routing daemon, adding and removing PBR tables
+ * as required by the differences from old_frontend_map.
+ *
+ * Any problems found are aggregated into the returned error, each
+ * prefixed by the config path at which it was found.
+ */
+func Translate(frontend_map, old_frontend_map map[string]interface{}) error {
+	if frontend_map == nil && old_frontend_map == nil {
+		return nil
+	}
+
+	ret_err := protocols.NewMultiError()
+
+	ret_err = multierr.Append(ret_err,
 		TranslateProtocols(frontend_map["protocols"],
-			old_frontend_map["protocols"], "default")
+			old_frontend_map["protocols"], "default"))
+	ret_err = multierr.Append(ret_err,
 		TranslateRouting(frontend_map["routing"],
-			old_frontend_map["routing"])
-	}
+			old_frontend_map["routing"]))
+
+	return ret_err.ErrorOrNil()
 }
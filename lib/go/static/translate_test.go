@@ -0,0 +1,445 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"eng.vyatta.net/protocols/static"
+	"strings"
+	"testing"
+)
+
+func TestTranslateNexthops(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1" },
+                  { "tagnode" : "2.2.2.2", "disable" : null }
+               ]
+            },
+            {
+               "tagnode" : "11.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "3.3.3.3", "disable" : null }
+               ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route6" : [
+                     {
+                        "tagnode" : "2001::/64",
+                        "next-hop-routing-instance-v6" : [
+                           {
+                              "routing-instance" : "BLUE",
+                              "next-hop" : [
+                                 { "tagnode" : "2002::1" }
+                              ]
+                           }
+                        ]
+                     }
+                  ],
+                  "interface-route" : [
+                     {
+                        "tagnode" : "12.0.0.0/8",
+                        "next-hop-interface" : [
+                           { "interface-name" : "dp0s1" }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1" }
+               ],
+               "tagnode" : "10.0.0.0/8"
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "interface-route" : [
+                     {
+                        "next-hop-interface" : [
+                           { "tagnode" : "dp0s1" }
+                        ],
+                        "tagnode" : "12.0.0.0/8"
+                     }
+                  ],
+                  "route6" : [
+                     {
+                        "next-hop-routing-instance" : [
+                           {
+                              "next-hop" : [
+                                 { "tagnode" : "2002::1" }
+                              ],
+                              "routing-instance" : "BLUE"
+                           }
+                        ],
+                        "tagnode" : "2001::/64"
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	compareConfig(t, cfg, expected_json)
+}
+
+func TestTranslateWeights(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1", "weight" : 2 },
+                  { "tagnode" : "1.1.1.2", "weight" : 3, "disable" : null },
+                  { "tagnode" : "1.1.1.3", "weight" : 4, "distance" : 10 }
+               ],
+               "next-hop-routing-instance" : [
+                  {
+                     "routing-instance" : "RED",
+                     "next-hop" : [
+                        { "tagnode" : "1.1.1.4", "weight" : 5, "distance" : 10 }
+                     ]
+                  }
+               ]
+            }
+         ]
+      }
+   }
+}`)
+
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1" },
+                  { "distance" : 10, "tagnode" : "1.1.1.3", "weight" : 4 }
+               ],
+               "next-hop-routing-instance" : [
+                  {
+                     "next-hop" : [
+                        { "distance" : 10, "tagnode" : "1.1.1.4", "weight" : 5 }
+                     ],
+                     "routing-instance" : "RED"
+                  }
+               ],
+               "tagnode" : "10.0.0.0/8"
+            }
+         ]
+      }
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	compareConfig(t, cfg, expected_json)
+}
+
+func TestTranslateErrorContext(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : { "tagnode" : "1.1.1.1" }
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route" : [
+                     {
+                        "tagnode" : "11.0.0.0/8",
+                        "next-hop-routing-instance" : [
+                           {
+                              "routing-instance" : "BLUE",
+                              "next-hop" : [ "2.2.2.2" ]
+                           }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, nil)
+	if err == nil {
+		t.Fatal("Expected error for malformed configuration")
+	}
+
+	for _, ctx := range []string{
+		"[protocols static route 10.0.0.0/8]",
+		"[routing routing-instance RED protocols static route " +
+			"11.0.0.0/8 next-hop-routing-instance BLUE]",
+	} {
+		if !strings.Contains(err.Error(), ctx) {
+			t.Errorf("Error does not contain %s:\n%s", ctx, err)
+		}
+	}
+}
+
+func TestTranslateRouteTypes(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "source" : "192.168.1.254",
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1", "disable" : null }
+               ]
+            },
+            {
+               "tagnode" : "11.0.0.0/8",
+               "source" : "192.168.1.254",
+               "throw" : { "distance" : 1 }
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route6" : [
+                     {
+                        "tagnode" : "2001::/64",
+                        "next-hop" : [
+                           {
+                              "tagnode" : "2002::1",
+                              "interface" : "dp0s1",
+                              "onlink" : null
+                           }
+                        ],
+                        "prohibit" : { "distance" : 10 }
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "route" : [
+            {
+               "source" : "192.168.1.254",
+               "tagnode" : "11.0.0.0/8",
+               "throw" : { "distance" : 1 }
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "route6" : [
+                     {
+                        "next-hop" : [
+                           {
+                              "interface" : "dp0s1",
+                              "onlink" : null,
+                              "tagnode" : "2002::1"
+                           }
+                        ],
+                        "prohibit" : { "distance" : 10 },
+                        "tagnode" : "2001::/64"
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	compareConfig(t, cfg, expected_json)
+}
+
+func TestTranslateMroutes(t *testing.T) {
+	input_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "mroute" : [
+            {
+               "tagnode" : "10.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1" },
+                  { "tagnode" : "2.2.2.2", "disable" : null }
+               ],
+               "next-hop-interface" : [
+                  { "tagnode" : "dp0s1", "disable" : null }
+               ]
+            },
+            {
+               "tagnode" : "11.0.0.0/8",
+               "next-hop" : [
+                  { "tagnode" : "3.3.3.3", "disable" : null }
+               ]
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "mroute6" : [
+                     {
+                        "tagnode" : "2001::/64",
+                        "next-hop-interface" : [
+                           { "tagnode" : "dp0s2", "distance" : 10 }
+                        ]
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	expected_json := []byte(`{
+   "protocols" : {
+      "static" : {
+         "mroute" : [
+            {
+               "next-hop" : [
+                  { "tagnode" : "1.1.1.1" }
+               ],
+               "tagnode" : "10.0.0.0/8"
+            }
+         ]
+      }
+   },
+   "routing" : {
+      "routing-instance" : [
+         {
+            "instance-name" : "RED",
+            "protocols" : {
+               "static" : {
+                  "mroute6" : [
+                     {
+                        "next-hop-interface" : [
+                           { "distance" : 10, "tagnode" : "dp0s2" }
+                        ],
+                        "tagnode" : "2001::/64"
+                     }
+                  ]
+               }
+            }
+         }
+      ]
+   }
+}`)
+
+	cfg := unmarshalConfig(t, input_json)
+	err := static.Translate(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	compareConfig(t, cfg, expected_json)
+}
+
+func unmarshalConfig(t *testing.T, cfg []byte) map[string]interface{} {
+	var cfg_map map[string]interface{}
+
+	err := json.Unmarshal(cfg, &cfg_map)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal config: %s", err)
+	}
+
+	return cfg_map
+}
+
+func compareConfig(t *testing.T, cfg map[string]interface{}, expected_json []byte) {
+	var buffer bytes.Buffer
+
+	cfg_json, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %s", err)
+	}
+
+	err = json.Compact(&buffer, expected_json)
+	if err != nil {
+		t.Fatalf("Failed to compact expected config: %s", err)
+	}
+
+	if !bytes.Equal(cfg_json, buffer.Bytes()) {
+		t.Errorf("Unexpected translation\nexpected: %s\ngot: %s",
+			buffer.Bytes(), cfg_json)
+	}
+}
@@ -0,0 +1,621 @@
+// Copyright (c) 2021, AT&T Intellectual Property.
+// All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static
+
+import (
+	"encoding/json"
+	"eng.vyatta.net/protocols"
+	"fmt"
+	multierr "github.com/hashicorp/go-multierror"
+	"net"
+	"sort"
+)
+
+/*
+ * Per route-list validation parameters
+ */
+type routeListInfo struct {
+	key     string
+	nexthop string
+	ipv6    bool
+}
+
+var routeLists = [...]routeListInfo{
+	{"route", "next-hop", false},
+	{"route6", "next-hop", true},
+	{"interface-route", "next-hop-interface", false},
+	{"interface-route6", "next-hop-interface", true},
+}
+
+/*
+ * Static multicast RPF route lists, each entry of which may have
+ * both next-hops and next-hop-interfaces
+ */
+var mrouteLists = [...]routeListInfo{
+	{"mroute", "next-hop", false},
+	{"mroute", "next-hop-interface", false},
+	{"mroute6", "next-hop", true},
+	{"mroute6", "next-hop-interface", true},
+}
+
+/*
+ * Returns the static container of each routing-instance
+ * in frontend_map, keyed by routing-instance name.
+ */
+func staticContainers(frontend_map map[string]interface{}) (map[string]map[string]interface{}, error) {
+	ret_err := protocols.NewMultiError()
+	containers := make(map[string]map[string]interface{})
+
+	proto_map, err := getMap(frontend_map, "protocols", "")
+	ret_err = multierr.Append(ret_err, err)
+	static_map, err := getMap(proto_map, "static", "protocols")
+	ret_err = multierr.Append(ret_err, err)
+	if static_map != nil {
+		containers["default"] = static_map
+	}
+
+	routing_map, err := getMap(frontend_map, "routing", "")
+	ret_err = multierr.Append(ret_err, err)
+	ri_arr, err := getList(routing_map, "routing-instance", "routing")
+	ret_err = multierr.Append(ret_err, err)
+	ri_map, err := MapByKey(ri_arr, "instance-name")
+	if err != nil {
+		ret_err = multierr.Append(ret_err,
+			protocols.AddErrorContext(err, "routing routing-instance"))
+	}
+
+	for ri, ri_entry_map := range ri_map {
+		ri_path := EntryPath("routing", "routing-instance", ri)
+		proto_map, err := getMap(ri_entry_map, "protocols", ri_path)
+		ret_err = multierr.Append(ret_err, err)
+		static_map, err := getMap(proto_map, "static", ri_path+" protocols")
+		ret_err = multierr.Append(ret_err, err)
+		if static_map != nil {
+			containers[ri] = static_map
+		}
+	}
+
+	return containers, ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the next-hop key of nh_map once normalised as by TranslateNexthops
+ */
+func nexthopKey(nh_map map[string]interface{}) string {
+	if nh_map["interface-name"] != nil {
+		return fmt.Sprint(nh_map["interface-name"])
+	}
+
+	return fmt.Sprint(nh_map["tagnode"])
+}
+
+/*
+ * Validates a single next-hop of the route to prefix
+ */
+func validateNexthop(nh string, prefix *net.IPNet, info routeListInfo, path string) error {
+	if info.nexthop != "next-hop" {
+		return nil
+	}
+
+	addr := net.ParseIP(nh)
+	if addr == nil {
+		return pathError(path, "Invalid next-hop address %s", nh)
+	}
+
+	if info.ipv6 && addr.To4() != nil {
+		return pathError(path, "IPv4 next-hop %s is not valid for an IPv6 route", nh)
+	}
+	if !info.ipv6 && addr.To4() == nil {
+		return pathError(path, "IPv6 next-hop %s is not valid for an IPv4 route", nh)
+	}
+
+	if prefix != nil && addr.Equal(prefix.IP) {
+		return pathError(path, "next-hop must not be the same as the destination prefix")
+	}
+
+	return nil
+}
+
+/*
+ * The enabled next-hops found across all next-hop lists of a route
+ */
+type routeNexthops struct {
+	// Path of each next-hop, keyed to detect duplicates
+	seen map[string]string
+	// Number of next-hops with each distance
+	distances map[uint32]int
+	// Paths of weighted next-hops, with their distance
+	weighted map[string]uint32
+}
+
+func newRouteNexthops() *routeNexthops {
+	return &routeNexthops{
+		seen:      make(map[string]string),
+		distances: make(map[uint32]int),
+		weighted:  make(map[string]uint32),
+	}
+}
+
+func (nhs *routeNexthops) count() int {
+	return len(nhs.seen)
+}
+
+/*
+ * Validates the next-hop list nkey of nh_parent, recording each enabled
+ * next-hop in nhs so checks can be made across the lists of a route.
+ */
+func validateNexthopList(nh_parent map[string]interface{}, prefix *net.IPNet,
+	info routeListInfo, ri string, nhs *routeNexthops, path string) error {
+	ret_err := protocols.NewMultiError()
+
+	nh_arr, err := getList(nh_parent, info.nexthop, path)
+	ret_err = multierr.Append(ret_err, err)
+
+	for _, nh_entry := range nh_arr {
+		nh_map, ok := nh_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"%s: expected list entry, got %T", info.nexthop, nh_entry))
+			continue
+		}
+
+		nh := nexthopKey(nh_map)
+		nh_path := EntryPath(path, info.nexthop, nh)
+		ret_err = multierr.Append(ret_err,
+			validateNexthop(nh, prefix, info, nh_path))
+		ret_err = multierr.Append(ret_err, validateLabels(nh_map, nh_path))
+
+		if IsNexthopDisabled(nh_map) {
+			continue
+		}
+
+		// Interface names are unique across routing-instances,
+		// but addresses are only unique within one.
+		seen_key := nh
+		if info.nexthop == "next-hop" {
+			seen_key = ri + " " + nh
+		}
+		if prev, exists := nhs.seen[seen_key]; exists {
+			ret_err = multierr.Append(ret_err, pathError(nh_path,
+				"Duplicate next-hop, also configured at %s", prev))
+			continue
+		}
+		nhs.seen[seen_key] = nh_path
+
+		distance := uintValue(nh_map["distance"], 1)
+		nhs.distances[distance]++
+		if nh_map["weight"] != nil {
+			nhs.weighted[nh_path] = distance
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates a single static route in routing-instance ri. That the
+ * routing-instance of a next-hop-routing-instance exists is checked by
+ * its YANG must, as the static configuration of a routing-instance is
+ * present only if the routing-instance has static routes.
+ */
+func validateRoute(route_map map[string]interface{}, info routeListInfo,
+	ri string, path string) error {
+	ret_err := protocols.NewMultiError()
+	nhs := newRouteNexthops()
+
+	var prefix *net.IPNet
+	if tagnode, ok := route_map["tagnode"].(string); ok {
+		_, prefix, _ = net.ParseCIDR(tagnode)
+	}
+
+	ret_err = multierr.Append(ret_err,
+		validateNexthopList(route_map, prefix, info, ri, nhs, path))
+	if info.nexthop == "next-hop" && !info.ipv6 {
+		dhcp_info := routeListInfo{info.key, "dhcp-interface", false}
+		ret_err = multierr.Append(ret_err,
+			validateNexthopList(route_map, prefix, dhcp_info, ri, nhs, path))
+	}
+
+	for _, inst_key := range [...]string{"next-hop-routing-instance",
+		"next-hop-routing-instance-v6"} {
+		inst_arr, err := getList(route_map, inst_key, path)
+		ret_err = multierr.Append(ret_err, err)
+
+		for _, inst_entry := range inst_arr {
+			inst_map, ok := inst_entry.(map[string]interface{})
+			if !ok {
+				ret_err = multierr.Append(ret_err, pathError(path,
+					"%s: expected list entry, got %T", inst_key, inst_entry))
+				continue
+			}
+
+			inst := fmt.Sprint(inst_map["routing-instance"])
+			inst_path := EntryPath(path, inst_key, inst)
+
+			if inst == ri {
+				ret_err = multierr.Append(ret_err, pathError(inst_path,
+					"inter-vrf route next-hop should be in a different routing-instance."))
+			}
+
+			ret_err = multierr.Append(ret_err,
+				validateNexthopList(inst_map, prefix, info, inst, nhs,
+					inst_path))
+		}
+	}
+
+	if nhs.count() > 0 {
+		for _, discard := range specialRouteTypes {
+			if _, exists := route_map[discard]; exists {
+				ret_err = multierr.Append(ret_err, pathError(path,
+					"Must not configure both %s and next-hops", discard))
+			}
+		}
+	}
+
+	weighted := make([]string, 0, len(nhs.weighted))
+	for nh_path, _ := range nhs.weighted {
+		weighted = append(weighted, nh_path)
+	}
+	sort.Strings(weighted)
+
+	for _, nh_path := range weighted {
+		if nhs.distances[nhs.weighted[nh_path]] < 2 {
+			ret_err = multierr.Append(ret_err, pathError(nh_path,
+				"weight is only valid for a multipath route, "+
+					"with multiple next-hops of the same distance"))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates each route list found in a static container or table
+ */
+func validateRouteLists(pmap map[string]interface{}, ri string,
+	path string) error {
+	ret_err := protocols.NewMultiError()
+
+	for _, info := range routeLists {
+		route_arr, err := getList(pmap, info.key, path)
+		ret_err = multierr.Append(ret_err, err)
+
+		for _, route_entry := range route_arr {
+			route_map, ok := route_entry.(map[string]interface{})
+			if !ok {
+				ret_err = multierr.Append(ret_err, pathError(path,
+					"%s: expected list entry, got %T", info.key, route_entry))
+				continue
+			}
+
+			route_path := EntryPath(path, info.key, route_map["tagnode"])
+			ret_err = multierr.Append(ret_err,
+				validateRoute(route_map, info, ri, route_path))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the static multicast RPF routes of routing-instance ri
+ */
+func validateMroutes(static_map map[string]interface{}, ri, path string) error {
+	ret_err := protocols.NewMultiError()
+	route_nhs := make(map[string]*routeNexthops)
+
+	for _, info := range mrouteLists {
+		route_arr, err := getList(static_map, info.key, path)
+		ret_err = multierr.Append(ret_err, err)
+
+		for _, route_entry := range route_arr {
+			route_map, ok := route_entry.(map[string]interface{})
+			if !ok {
+				ret_err = multierr.Append(ret_err, pathError(path,
+					"%s: expected list entry, got %T", info.key, route_entry))
+				continue
+			}
+
+			var prefix *net.IPNet
+			if tagnode, ok := route_map["tagnode"].(string); ok {
+				_, prefix, _ = net.ParseCIDR(tagnode)
+			}
+
+			route_path := EntryPath(path, info.key, route_map["tagnode"])
+			if route_nhs[route_path] == nil {
+				route_nhs[route_path] = newRouteNexthops()
+			}
+			ret_err = multierr.Append(ret_err,
+				validateNexthopList(route_map, prefix, info, ri,
+					route_nhs[route_path], route_path))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the static IPv6 neighbours of a static container. A neighbour
+ * must have a link-local or global unicast address.
+ */
+func validateNeighbor6(static_map map[string]interface{}, path string) error {
+	ret_err := protocols.NewMultiError()
+
+	neigh_arr, err := getList(static_map, "neighbor6", path)
+	ret_err = multierr.Append(ret_err, err)
+
+	for _, neigh_entry := range neigh_arr {
+		neigh_map, ok := neigh_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"neighbor6: expected list entry, got %T", neigh_entry))
+			continue
+		}
+
+		neigh_path := EntryPath(path, "neighbor6", neigh_map["tagnode"])
+		ip := net.ParseIP(fmt.Sprint(neigh_map["tagnode"]))
+		switch {
+		case ip == nil || ip.To4() != nil:
+			ret_err = multierr.Append(ret_err,
+				pathError(neigh_path, "Must be an IPv6 address"))
+		case ip.IsLinkLocalUnicast():
+		case !ip.IsGlobalUnicast():
+			ret_err = multierr.Append(ret_err, pathError(neigh_path,
+				"Must be a link-local or global unicast address"))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the static container of routing-instance ri
+ */
+func ValidateStatic(static_map map[string]interface{}, ri string) error {
+	ret_err := protocols.NewMultiError()
+	path := StaticPath(ri)
+
+	ret_err = multierr.Append(ret_err,
+		validateRouteLists(static_map, ri, path))
+	ret_err = multierr.Append(ret_err, validateMroutes(static_map, ri, path))
+	ret_err = multierr.Append(ret_err, validateNeighbor6(static_map, path))
+
+	tbl_arr, err := getList(static_map, "table", path)
+	ret_err = multierr.Append(ret_err, err)
+	for _, tbl_entry := range tbl_arr {
+		tbl_map, ok := tbl_entry.(map[string]interface{})
+		if !ok {
+			ret_err = multierr.Append(ret_err, pathError(path,
+				"table: expected list entry, got %T", tbl_entry))
+			continue
+		}
+
+		tbl_path := EntryPath(path, "table", tbl_map["tagnode"])
+		ret_err = multierr.Append(ret_err,
+			validateRouteLists(tbl_map, ri, tbl_path))
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Performs semantic validation of the untranslated static configuration
+ * in frontend_map, covering the default and all non-default
+ * routing-instances, including detection of inter-VRF route leak loops
+ * and of conflicting static neighbours. frontend_map is not modified.
+ *
+ * Any problems found are aggregated into the returned error, each
+ * prefixed by the config path at which it was found.
+ */
+func Validate(frontend_map map[string]interface{}) error {
+	ret_err := protocols.NewMultiError()
+
+	containers, err := staticContainers(frontend_map)
+	ret_err = multierr.Append(ret_err, err)
+
+	ri_names := make([]string, 0, len(containers))
+	for ri, _ := range containers {
+		ri_names = append(ri_names, ri)
+	}
+	sort.Strings(ri_names)
+
+	for _, ri := range ri_names {
+		ret_err = multierr.Append(ret_err,
+			ValidateStatic(containers[ri], ri))
+	}
+
+	ret_err = multierr.Append(ret_err, checkLeakLoops(containers))
+	ret_err = multierr.Append(ret_err, ValidateNeighbours(frontend_map))
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Checks that the preferred source address of each route in the static
+ * container static_map of routing-instance ri is one of its addresses.
+ */
+func validateSources(static_map map[string]interface{}, ri string,
+	local []net.IP) error {
+	ret_err := protocols.NewMultiError()
+
+	checkRoutes := func(pmap map[string]interface{}, path string) {
+		for _, key := range [...]string{"route", "route6"} {
+			route_arr, _ := pmap[key].([]interface{})
+			for _, route_entry := range route_arr {
+				route_map, ok := route_entry.(map[string]interface{})
+				if !ok || route_map["source"] == nil {
+					continue
+				}
+
+				src_path := EntryPath(EntryPath(path, key,
+					route_map["tagnode"]), "source", route_map["source"])
+				src := net.ParseIP(fmt.Sprint(route_map["source"]))
+				if src == nil {
+					ret_err = multierr.Append(ret_err, pathError(src_path,
+						"Invalid source address"))
+					continue
+				}
+
+				found := false
+				for _, addr := range local {
+					if addr.Equal(src) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					ret_err = multierr.Append(ret_err, pathError(src_path,
+						"source address %s is not local to routing-instance %s",
+						src, ri))
+				}
+			}
+		}
+	}
+
+	path := StaticPath(ri)
+	checkRoutes(static_map, path)
+
+	tbl_arr, _ := static_map["table"].([]interface{})
+	for _, tbl_entry := range tbl_arr {
+		if tbl_map, ok := tbl_entry.(map[string]interface{}); ok {
+			checkRoutes(tbl_map, EntryPath(path, "table", tbl_map["tagnode"]))
+		}
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Returns the routing-instance of each interface of a routing-instance
+ * in the internal JSON configuration cfg. Other interfaces belong to the
+ * default routing-instance.
+ */
+func ConfiguredInterfaceRoutingInstances(cfg map[string]interface{}) map[string]string {
+	if_ri := make(map[string]string)
+
+	routing_map, _ := cfg["routing"].(map[string]interface{})
+	ri_arr, _ := routing_map["routing-instance"].([]interface{})
+	for _, ri_entry := range ri_arr {
+		ri_map, _ := ri_entry.(map[string]interface{})
+		ri, ok := ri_map["instance-name"].(string)
+		if !ok {
+			continue
+		}
+		if_arr, _ := ri_map["interface"].([]interface{})
+		for _, if_entry := range if_arr {
+			if_map, _ := if_entry.(map[string]interface{})
+			if ifname, ok := if_map["name"].(string); ok {
+				if_ri[ifname] = ri
+			}
+		}
+	}
+
+	return if_ri
+}
+
+/*
+ * Returns the local addresses of each routing-instance which are
+ * configured on the interfaces, and their vifs, in the internal JSON
+ * configuration cfg, keyed by routing-instance name. The names of the
+ * interfaces whose addresses are learned by DHCP, so are not configured,
+ * are also returned.
+ */
+func ConfiguredLocalAddresses(cfg map[string]interface{}) (map[string][]net.IP, []string) {
+	local := make(map[string][]net.IP)
+	var dynamic []string
+	if_ri := ConfiguredInterfaceRoutingInstances(cfg)
+
+	addAddresses := func(ifname string, if_map map[string]interface{}) {
+		ri, ok := if_ri[ifname]
+		if !ok {
+			ri = "default"
+		}
+
+		addr_arr, _ := if_map["address"].([]interface{})
+		for _, addr := range addr_arr {
+			ip, _, err := net.ParseCIDR(fmt.Sprint(addr))
+			if err != nil {
+				//dhcp or dhcpv6
+				dynamic = append(dynamic, ifname)
+				continue
+			}
+			if ip.To4() != nil {
+				ip = ip.To4()
+			}
+			local[ri] = append(local[ri], ip)
+		}
+	}
+
+	intfs_map, _ := cfg["interfaces"].(map[string]interface{})
+	for _, intf_list := range intfs_map {
+		intf_arr, _ := intf_list.([]interface{})
+		for _, intf_entry := range intf_arr {
+			intf_map, ok := intf_entry.(map[string]interface{})
+			if !ok || intf_map["tagnode"] == nil {
+				continue
+			}
+			ifname := fmt.Sprint(intf_map["tagnode"])
+			addAddresses(ifname, intf_map)
+
+			vif_arr, _ := intf_map["vif"].([]interface{})
+			for _, vif_entry := range vif_arr {
+				vif_map, ok := vif_entry.(map[string]interface{})
+				if ok && vif_map["tagnode"] != nil {
+					addAddresses(fmt.Sprintf("%s.%v", ifname,
+						vif_map["tagnode"]), vif_map)
+				}
+			}
+		}
+	}
+
+	return local, dynamic
+}
+
+/*
+ * Checks that the preferred source address of each route is local to
+ * its routing-instance, given the local addresses of each
+ * routing-instance as returned by ConfiguredLocalAddresses().
+ */
+func ValidateSources(frontend_map map[string]interface{},
+	local map[string][]net.IP) error {
+	ret_err := protocols.NewMultiError()
+
+	containers, err := staticContainers(frontend_map)
+	ret_err = multierr.Append(ret_err, err)
+
+	ri_names := make([]string, 0, len(containers))
+	for ri, _ := range containers {
+		ri_names = append(ri_names, ri)
+	}
+	sort.Strings(ri_names)
+
+	for _, ri := range ri_names {
+		ret_err = multierr.Append(ret_err,
+			validateSources(containers[ri], ri, local[ri]))
+	}
+
+	return ret_err.ErrorOrNil()
+}
+
+/*
+ * Validates the static configuration contained in the internal JSON
+ * cfg, as passed to a ProtocolsModelComponent check function.
+ *
+ * Route source addresses and the interfaces of static neighbours depend
+ * on the candidate interface and routing-instance configuration, which
+ * check functions do not receive, so are checked by the
+ * vyatta-static-validate configd validate script of the model instead.
+ */
+func ValidateJson(cfg []byte) error {
+	var frontend_map map[string]interface{}
+
+	err := json.Unmarshal(cfg, &frontend_map)
+	if err != nil {
+		return err
+	}
+
+	return Validate(frontend_map)
+}
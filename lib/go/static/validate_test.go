@@ -0,0 +1,371 @@
+// Copyright (c) 2021, AT&T Intellectual Property.  All rights reserved.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package static_test
+
+import (
+	"eng.vyatta.net/protocols/static"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		static string
+		errors []string
+	}{
+		{
+			name: "valid",
+			static: `{
+				"route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"next-hop" : [ { "tagnode" : "1.1.1.1" } ],
+					"next-hop-routing-instance" : [ {
+						"routing-instance" : "BLUE",
+						"next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+					} ]
+				} ],
+				"route6" : [ {
+					"tagnode" : "2001::/64",
+					"blackhole" : { "distance" : 1 },
+					"next-hop" : [ { "tagnode" : "2002::1", "disable" : null } ]
+				} ]
+			}`,
+		},
+		{
+			name: "blackhole with next-hop",
+			static: `{
+				"route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"blackhole" : { "distance" : 1 },
+					"next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+				} ]
+			}`,
+			errors: []string{
+				"[routing routing-instance RED protocols static route 10.0.0.0/8]\n" +
+					"Must not configure both blackhole and next-hops",
+			},
+		},
+		{
+			name: "address family mismatch",
+			static: `{
+				"route6" : [ {
+					"tagnode" : "2001::/64",
+					"next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+				} ]
+			}`,
+			errors: []string{
+				"route6 2001::/64 next-hop 1.1.1.1]\n" +
+					"IPv4 next-hop 1.1.1.1 is not valid for an IPv6 route",
+			},
+		},
+		{
+			name: "next-hop is destination",
+			static: `{
+				"table" : [ {
+					"tagnode" : 10,
+					"route" : [ {
+						"tagnode" : "10.1.1.1/32",
+						"next-hop" : [ { "tagnode" : "10.1.1.1" } ]
+					} ]
+				} ]
+			}`,
+			errors: []string{
+				"table 10 route 10.1.1.1/32 next-hop 10.1.1.1]\n" +
+					"next-hop must not be the same as the destination prefix",
+			},
+		},
+		{
+			name: "same next-hop-routing-instance",
+			static: `{
+				"route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"next-hop-routing-instance" : [
+						{
+							"routing-instance" : "RED",
+							"next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+						},
+						{
+							"routing-instance" : "GREEN",
+							"next-hop" : [ { "tagnode" : "1.1.1.1" } ]
+						}
+					]
+				} ]
+			}`,
+			errors: []string{
+				"next-hop-routing-instance RED]\n" +
+					"inter-vrf route next-hop should be in a different routing-instance.",
+			},
+		},
+		{
+			name: "duplicate next-hop interface",
+			static: `{
+				"interface-route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"next-hop-interface" : [ { "tagnode" : "dp0s1" } ],
+					"next-hop-routing-instance" : [ {
+						"routing-instance" : "BLUE",
+						"next-hop-interface" : [
+							{ "interface-name" : "dp0s1" }
+						]
+					} ]
+				} ]
+			}`,
+			errors: []string{
+				"next-hop-routing-instance BLUE next-hop-interface dp0s1]\n" +
+					"Duplicate next-hop, also configured at " +
+					"routing routing-instance RED protocols static " +
+					"interface-route 10.0.0.0/8 next-hop-interface dp0s1",
+			},
+		},
+		{
+			name: "weight on single path",
+			static: `{
+				"route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"next-hop" : [
+						{ "tagnode" : "1.1.1.1", "weight" : 2 },
+						{ "tagnode" : "1.1.1.2", "weight" : 3 },
+						{ "tagnode" : "1.1.1.3", "weight" : 4,
+						  "distance" : 10 }
+					]
+				} ]
+			}`,
+			errors: []string{
+				"next-hop 1.1.1.3]\n" +
+					"weight is only valid for a multipath route",
+			},
+		},
+		{
+			name: "prohibit with next-hop",
+			static: `{
+				"route6" : [ {
+					"tagnode" : "2001::/64",
+					"prohibit" : { "distance" : 1 },
+					"next-hop" : [ { "tagnode" : "2002::1" } ]
+				} ]
+			}`,
+			errors: []string{
+				"[routing routing-instance RED protocols static route6 2001::/64]\n" +
+					"Must not configure both prohibit and next-hops",
+			},
+		},
+		{
+			name: "mroute address family mismatch",
+			static: `{
+				"mroute6" : [ {
+					"tagnode" : "2001::/64",
+					"next-hop" : [
+						{ "tagnode" : "1.1.1.1" },
+						{ "tagnode" : "2002::1" }
+					]
+				} ]
+			}`,
+			errors: []string{
+				"mroute6 2001::/64 next-hop 1.1.1.1]\n" +
+					"IPv4 next-hop 1.1.1.1 is not valid for an IPv6 route",
+			},
+		},
+		{
+			name: "dhcp-interface with blackhole",
+			static: `{
+				"route" : [ {
+					"tagnode" : "0.0.0.0/0",
+					"blackhole" : { "distance" : 1 },
+					"dhcp-interface" : [ { "tagnode" : "dp0s1" } ]
+				} ]
+			}`,
+			errors: []string{
+				"[routing routing-instance RED protocols static route 0.0.0.0/0]\n" +
+					"Must not configure both blackhole and next-hops",
+			},
+		},
+		{
+			name: "neighbor6 addresses",
+			static: `{
+				"neighbor6" : [
+					{ "tagnode" : "fe80::1", "interface" : "dp0s1" },
+					{ "tagnode" : "2001::1", "interface" : "dp0s1" },
+					{ "tagnode" : "ff02::1", "interface" : "dp0s1" },
+					{ "tagnode" : "::ffff:10.0.0.1", "interface" : "dp0s1" }
+				]
+			}`,
+			errors: []string{
+				"[routing routing-instance RED protocols static neighbor6 ff02::1]\n" +
+					"Must be a link-local or global unicast address",
+				"[routing routing-instance RED protocols static neighbor6 ::ffff:10.0.0.1]\n" +
+					"Must be an IPv6 address",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		//BLUE has no static configuration so is absent
+		cfg := unmarshalConfig(t, []byte(`{
+			"routing" : {
+				"routing-instance" : [
+					{
+						"instance-name" : "RED",
+						"protocols" : { "static" : `+test.static+` }
+					}
+				]
+			}
+		}`))
+
+		err := static.Validate(cfg)
+		if len(test.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+			continue
+		}
+		for _, expected := range test.errors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: error does not contain %q:\n%s",
+					test.name, expected, err)
+			}
+		}
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+		"protocols" : {
+			"static" : {
+				"route" : [ {
+					"tagnode" : "10.0.0.0/8",
+					"source" : "192.168.1.1",
+					"next-hop" : [ { "tagnode" : "192.168.1.254" } ]
+				} ],
+				"route6" : [ {
+					"tagnode" : "2004::/64",
+					"source" : "2003:0:0::0001",
+					"blackhole" : { }
+				} ],
+				"table" : [ {
+					"tagnode" : 10,
+					"route" : [ {
+						"tagnode" : "10.0.0.0/8",
+						"source" : "192.168.2.1",
+						"blackhole" : { }
+					} ],
+					"route6" : [ {
+						"tagnode" : "2001::/64",
+						"source" : "2002::1",
+						"throw" : { }
+					} ]
+				} ]
+			}
+		},
+		"routing" : {
+			"routing-instance" : [ {
+				"instance-name" : "RED",
+				"protocols" : {
+					"static" : {
+						"route" : [ {
+							"tagnode" : "10.0.0.0/8",
+							"source" : "192.168.1.1",
+							"blackhole" : { }
+						} ]
+					}
+				}
+			} ]
+		}
+	}`))
+
+	local := map[string][]net.IP{
+		"default": {net.ParseIP("192.168.1.1"), net.ParseIP("2003::1")},
+		"RED":     {net.ParseIP("192.168.2.1")},
+	}
+
+	err := static.ValidateSources(cfg, local)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	expected := []string{
+		"[protocols static table 10 route 10.0.0.0/8 source 192.168.2.1]\n" +
+			"source address 192.168.2.1 is not local to routing-instance default",
+		"[protocols static table 10 route6 2001::/64 source 2002::1]\n" +
+			"source address 2002::1 is not local to routing-instance default",
+		"[routing routing-instance RED protocols static route 10.0.0.0/8 source 192.168.1.1]\n" +
+			"source address 192.168.1.1 is not local to routing-instance RED",
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Error does not contain %q:\n%s", e, err)
+		}
+	}
+	for _, path := range []string{"[protocols static route 10.0.0.0/8",
+		"[protocols static route6 2004::/64"} {
+		if strings.Contains(err.Error(), path) {
+			t.Errorf("Unexpected error for local source:\n%s", err)
+		}
+	}
+}
+
+func TestConfiguredLocalAddresses(t *testing.T) {
+	cfg := unmarshalConfig(t, []byte(`{
+		"interfaces" : {
+			"dataplane" : [ {
+				"tagnode" : "dp0s1",
+				"address" : [ "192.168.1.1/24", "2003::1/64" ],
+				"vif" : [ { "tagnode" : 10, "address" : [ "192.168.10.1/24" ] } ]
+			}, {
+				"tagnode" : "dp0s2",
+				"address" : [ "192.168.2.1/24", "dhcpv6" ]
+			} ],
+			"loopback" : [ { "tagnode" : "lo", "address" : [ "10.255.0.1/32" ] } ]
+		},
+		"routing" : {
+			"routing-instance" : [ {
+				"instance-name" : "RED",
+				"interface" : [ { "name" : "dp0s2" }, { "name" : "dp0s1.10" } ]
+			} ]
+		}
+	}`))
+
+	local, dynamic := static.ConfiguredLocalAddresses(cfg)
+	expected := map[string][]string{
+		"default": {"10.255.0.1", "192.168.1.1", "2003::1"},
+		"RED":     {"192.168.10.1", "192.168.2.1"},
+	}
+	for ri, addrs := range expected {
+		var got []string
+		for _, ip := range local[ri] {
+			got = append(got, ip.String())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, addrs) {
+			t.Errorf("Unexpected addresses of %s: expected %v, got %v",
+				ri, addrs, got)
+		}
+	}
+	if !reflect.DeepEqual(dynamic, []string{"dp0s2"}) {
+		t.Errorf("Unexpected DHCP interfaces %v", dynamic)
+	}
+
+	//A source added along with its interface address is accepted
+	cfg["protocols"] = map[string]interface{}{
+		"static": map[string]interface{}{
+			"route6": []interface{}{map[string]interface{}{
+				"tagnode":   "2004::/64",
+				"source":    "2003:0:0::0001",
+				"blackhole": map[string]interface{}{},
+			}},
+		},
+	}
+	if err := static.ValidateSources(cfg, local); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
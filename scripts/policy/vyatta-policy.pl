@@ -1,7 +1,7 @@
 #!/usr/bin/perl
 
 # **** License ****
-# Copyright (c) 2019 AT&T Intellectual Property. All rights reserved.
+# Copyright (c) 2019, 2021 AT&T Intellectual Property. All rights reserved.
 # Copyright (c) 2014-2015 by Brocade Communications Systems, Inc.
 # All rights reserved.
 #
@@ -16,14 +16,12 @@ use Vyatta::Misc;
 use Getopt::Long;
 
 my ( $clisttype );
-my ( $routemap, $deleteroutemap, $listpolicy, $listcommunity );
+my ( $listpolicy, $listcommunity );
 my ( $checkcommlist , $checkextcommlist );
 my ( $oplistcommunity );
 
 GetOptions(
     "comm-list-type=s"               => \$clisttype,
-    "check-routemap-action=s"        => \$routemap,
-    "check-delete-routemap-action=s" => \$deleteroutemap,
     "list-policy=s"		     => \$listpolicy,
     "list-community=s"		     => \$listcommunity,
     "check-community-list=s"         => \$checkcommlist,
@@ -31,8 +29,6 @@ GetOptions(
     "op-list-community=s"              => \$oplistcommunity,
 ) or exit 1;
 
-check_routemap_action($routemap)              if ($routemap);
-check_delete_routemap_action($deleteroutemap) if ($deleteroutemap);
 list_policy($listpolicy)	    	      if ($listpolicy);
 list_community($listcommunity)  	      if ($listcommunity);
 op_list_community($oplistcommunity)  	      if ($oplistcommunity);
@@ -42,40 +38,6 @@ check_community_list($checkextcommlist, $clisttype, "extcommunity-list")  if ($c
 exit 0;
 
 
-## check_routemap_action
-# check if the action has been changed since the last commit.
-# we need to do this because routing will wipe the entire config if
-# the action is changed.
-# $1 = policy route-map <name> rule <num> action
-sub check_routemap_action {
-    my $routemap = shift;
-    my $config   = new Vyatta::Config;
-
-    my $action    = $config->setLevel("$routemap");
-    my $origvalue = $config->returnOrigValue();
-    if ($origvalue) {
-        my $value = $config->returnValue();
-        if ( "$value" ne "$origvalue" ) {
-            exit 1;
-        }
-    }
-
-    exit 0;
-}
-
-## check_delete_routemap_action
-# don't allow deleteing the route-map action if other sibling nodes exist.
-# action is required for all other route-map definitions
-# $1 = policy route-map <name> rule <num>
-sub check_delete_routemap_action {
-    my $routemap = shift;
-    my $config   = new Vyatta::Config;
-
-    my @nodes = $config->listNodes("$routemap");
-
-    exit(@nodes) ? 1 : 0;
-}
-
 ## list available policies
 sub list_policy {
    my $policy = shift;
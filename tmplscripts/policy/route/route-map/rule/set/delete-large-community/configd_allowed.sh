@@ -0,0 +1,4 @@
+#!/bin/bash
+local -a params
+params=$( /opt/vyatta/sbin/vyatta-policy.pl --list-community large-community-list )
+echo -n ${params[@]##*/}